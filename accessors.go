@@ -0,0 +1,70 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// UserSIDString returns the SID of the token user in string form with a single TokenUser query,
+// without the account lookups of UserDetails
+func (t *Token) UserSIDString() (string, error) {
+	if err := t.acquire(); err != nil {
+		return "", err
+	}
+	defer t.release()
+
+	var sid string
+	err := withTokenInfo(t.token, windows.TokenUser, func(b []byte) error {
+		sid = (*windows.Tokenuser)(unsafe.Pointer(&b[0])).User.Sid.String()
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error while getting token user: %w", err)
+	}
+	return sid, nil
+}
+
+// SessionID returns the Terminal Services session of the token
+func (t *Token) SessionID() (uint32, error) {
+	if err := t.acquire(); err != nil {
+		return 0, err
+	}
+	defer t.release()
+
+	id, err := tokenInfoUint32(t.token, windows.TokenSessionId)
+	if err != nil {
+		return 0, fmt.Errorf("error while getting token session: %w", err)
+	}
+	return id, nil
+}
+
+// IsAdminMember reports whether the token user is a member of BUILTIN\Administrators, including the filtered tokens
+// of UAC where the group is deny-only. Use Token().IsElevated to know whether the membership is effective
+func (t *Token) IsAdminMember() (bool, error) {
+	if err := t.acquire(); err != nil {
+		return false, err
+	}
+	defer t.release()
+
+	admins, err := windows.CreateWellKnownSid(windows.WinBuiltinAdministratorsSid)
+	if err != nil {
+		return false, fmt.Errorf("error while creating Administrators SID: %w", err)
+	}
+
+	member := false
+	err = withTokenInfo(t.token, windows.TokenGroups, func(b []byte) error {
+		for _, g := range (*windows.Tokengroups)(unsafe.Pointer(&b[0])).AllGroups() {
+			if windows.EqualSid(g.Sid, admins) {
+				member = true
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("error while getting token groups: %w", err)
+	}
+	return member, nil
+}
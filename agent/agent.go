@@ -0,0 +1,297 @@
+// Package agent runs a small HTTP agent on remote hosts which acquires tokens and launches processes as users of
+// that host on behalf of a central controller, and the client the controller uses to drive it.
+//
+// The agent is meant to run as a service under LocalSystem and to be served over mutually authenticated TLS:
+//
+//	a := &agent.Agent{Authorize: agent.AllowClientCertificates("controller.example.com")}
+//	srv := agent.NewServer(":8443", tlsConfig, a)
+//	err := srv.ListenAndServeTLS("", "")
+//
+// The controller then calls every host with a Client:
+//
+//	c := agent.NewClient("https://host:8443", httpClient)
+//	res, err := c.Launch(ctx, agent.LaunchRequest{Identity: agent.Identity{Kind: agent.IdentityInteractive}, Command: `C:\tools\job.exe`})
+package agent
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/fourcorelabs/wintoken"
+)
+
+// IdentityKind selects how the agent acquires the token of an Identity
+type IdentityKind string
+
+const (
+	// IdentitySystem is the LocalSystem token
+	IdentitySystem IdentityKind = "system"
+	// IdentityInteractive is the token of the user logged on to the active session
+	IdentityInteractive IdentityKind = "interactive"
+	// IdentitySession is the token of the user logged on to Identity.SessionID
+	IdentitySession IdentityKind = "session"
+	// IdentityProcess is the token of the process Identity.PID
+	IdentityProcess IdentityKind = "process"
+	// IdentityService is the token of the process hosting the service Identity.Service
+	IdentityService IdentityKind = "service"
+	// IdentityUser logs on Identity.User with its password, or with S4U when the password is empty
+	IdentityUser IdentityKind = "user"
+)
+
+// Identity describes the user a request runs as
+type Identity struct {
+	Kind      IdentityKind `json:"kind"`
+	SessionID uint32       `json:"session_id,omitempty"`
+	PID       int          `json:"pid,omitempty"`
+	Service   string       `json:"service,omitempty"`
+	User      string       `json:"user,omitempty"`
+	Domain    string       `json:"domain,omitempty"`
+	Password  string       `json:"password,omitempty"`
+	// Elevated uses the linked token of split UAC tokens
+	Elevated bool `json:"elevated,omitempty"`
+}
+
+// token acquires the token of the identity
+func (id Identity) token() (*wintoken.Token, error) {
+	typ := wintoken.TokenPrimary
+	if id.Elevated {
+		typ = wintoken.TokenLinked
+	}
+
+	switch id.Kind {
+	case IdentitySystem:
+		return wintoken.GetSystemToken(wintoken.TokenPrimary)
+	case IdentityInteractive:
+		return wintoken.GetInteractiveToken(typ)
+	case IdentitySession:
+		return wintoken.GetSessionToken(id.SessionID, typ)
+	case IdentityProcess:
+		return wintoken.OpenProcessToken(id.PID, typ)
+	case IdentityService:
+		return wintoken.GetServiceToken(id.Service, typ)
+	case IdentityUser:
+		if id.Password == "" {
+			return wintoken.S4ULogon(id.User, id.Domain, nil, wintoken.TokenPrimary)
+		}
+		return wintoken.LogonUser(id.User, id.Domain, id.Password, wintoken.LogonInteractive, typ)
+	default:
+		return nil, fmt.Errorf("unknown identity kind %q", id.Kind)
+	}
+}
+
+// TokenRequest asks the agent to acquire a token and describe it
+type TokenRequest struct {
+	Identity Identity `json:"identity"`
+}
+
+// TokenResponse describes the token acquired for a TokenRequest
+type TokenResponse struct {
+	User       wintoken.TokenUserDetail   `json:"user"`
+	SessionID  uint32                     `json:"session_id"`
+	Privileges []wintoken.PrivilegeDetail `json:"privileges"`
+}
+
+// LaunchRequest asks the agent to launch a process as an identity
+type LaunchRequest struct {
+	Identity Identity `json:"identity"`
+	Command  string   `json:"command"`
+	Args     []string `json:"args,omitempty"`
+	Dir      string   `json:"dir,omitempty"`
+	Env      []string `json:"env,omitempty"`
+	// Wait makes the agent wait for the process to exit and report its exit code
+	Wait bool `json:"wait,omitempty"`
+}
+
+// LaunchResponse reports the process launched for a LaunchRequest
+type LaunchResponse struct {
+	PID int `json:"pid"`
+	// ExitCode is set when the request waited for the process
+	ExitCode *uint32 `json:"exit_code,omitempty"`
+}
+
+// InventoryResponse is the process and token inventory of the agent host, see wintoken.Processes
+type InventoryResponse struct {
+	Host      string                 `json:"host"`
+	Time      time.Time              `json:"time"`
+	Processes []wintoken.ProcessInfo `json:"processes"`
+}
+
+// errorResponse is the body of failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Agent serves the token, launch and inventory requests of a controller. It implements http.Handler with the routes
+// POST /v1/token and POST /v1/launch, both taking and returning JSON, and GET /v1/inventory
+type Agent struct {
+	// Authorize authenticates and authorizes every request, the request is rejected with 403 when it returns an
+	// error. It is required, an Agent without Authorize rejects every request
+	Authorize func(r *http.Request) error
+}
+
+// ErrUnauthorized is returned by Authorize functions for requests which are not allowed
+var ErrUnauthorized = errors.New("request is not authorized")
+
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.Authorize == nil {
+		writeError(w, http.StatusForbidden, ErrUnauthorized)
+		return
+	}
+	if err := a.Authorize(r); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if r.URL.Path == "/v1/inventory" {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		res, err := inventory()
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/token":
+		var req TokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		res, err := describeToken(req)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	case "/v1/launch":
+		var req LaunchRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		res, err := launch(req)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown route %s", r.URL.Path))
+	}
+}
+
+func describeToken(req TokenRequest) (TokenResponse, error) {
+	t, err := req.Identity.token()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+	defer t.Close()
+
+	var res TokenResponse
+	if res.User, err = t.UserDetails(); err != nil {
+		return res, err
+	}
+	// the environment of the user stays on the host
+	res.User.Environ = nil
+	if res.SessionID, err = t.SessionID(); err != nil {
+		return res, err
+	}
+	if res.Privileges, err = t.GetPrivileges(); err != nil {
+		return res, err
+	}
+	return res, nil
+}
+
+func launch(req LaunchRequest) (LaunchResponse, error) {
+	t, err := req.Identity.token()
+	if err != nil {
+		return LaunchResponse{}, err
+	}
+	defer t.Close()
+
+	p, err := t.StartProcess(req.Command, req.Args, &wintoken.StartOptions{Dir: req.Dir, Env: req.Env, HideWindow: true})
+	if err != nil {
+		return LaunchResponse{}, err
+	}
+
+	res := LaunchResponse{PID: p.Pid}
+	if !req.Wait {
+		return res, p.Release()
+	}
+	code, err := p.Wait()
+	if err != nil {
+		return res, err
+	}
+	res.ExitCode = &code
+	return res, nil
+}
+
+// inventory lists the processes of the host while SeDebugPrivilege is enabled, so the tokens of every user can be
+// queried. The privilege is restored once the list is built
+func inventory() (InventoryResponse, error) {
+	var processes []wintoken.ProcessInfo
+	err := wintoken.WithPrivilege(wintoken.SeDebugPrivilege, func() error {
+		var err error
+		processes, err = wintoken.Processes()
+		return err
+	})
+	if err != nil {
+		return InventoryResponse{}, err
+	}
+	host, _ := os.Hostname()
+	return InventoryResponse{Host: host, Time: time.Now(), Processes: processes}, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// AllowClientCertificates authorizes requests whose verified TLS client certificate has one of the common names
+func AllowClientCertificates(commonNames ...string) func(r *http.Request) error {
+	allowed := make(map[string]bool, len(commonNames))
+	for _, cn := range commonNames {
+		allowed[cn] = true
+	}
+	return func(r *http.Request) error {
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			return ErrUnauthorized
+		}
+		if !allowed[r.TLS.VerifiedChains[0][0].Subject.CommonName] {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+}
+
+// NewServer serves the agent on addr with tlsConfig, which must hold the agent certificate and the ClientCAs
+// trusted to sign controller certificates. Client certificates are always required and verified
+func NewServer(addr string, tlsConfig *tls.Config, a *Agent) *http.Server {
+	cfg := tlsConfig.Clone()
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return &http.Server{
+		Addr:              addr,
+		Handler:           a,
+		TLSConfig:         cfg,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
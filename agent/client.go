@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Client calls an Agent from the controller
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// NewClient creates a client for the agent at baseURL, such as https://host:8443. httpClient must present the
+// controller certificate, http.DefaultClient is used if nil
+func NewClient(baseURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{base: strings.TrimRight(baseURL, "/"), http: httpClient}
+}
+
+// Token asks the agent to acquire the token of req.Identity and describe it
+func (c *Client) Token(ctx context.Context, req TokenRequest) (TokenResponse, error) {
+	var res TokenResponse
+	err := c.post(ctx, "/v1/token", req, &res)
+	return res, err
+}
+
+// Launch asks the agent to launch a process as req.Identity
+func (c *Client) Launch(ctx context.Context, req LaunchRequest) (LaunchResponse, error) {
+	var res LaunchResponse
+	err := c.post(ctx, "/v1/launch", req, &res)
+	return res, err
+}
+
+// Inventory queries the process and token inventory of the agent host
+func (c *Client) Inventory(ctx context.Context) (InventoryResponse, error) {
+	var res InventoryResponse
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/v1/inventory", nil)
+	if err != nil {
+		return res, err
+	}
+	err = c.do(r, &res)
+	return res, err
+}
+
+func (c *Client) post(ctx context.Context, path string, req, res interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	return c.do(r, res)
+}
+
+func (c *Client) do(r *http.Request, res interface{}) error {
+	resp, err := c.http.Do(r)
+	if err != nil {
+		return fmt.Errorf("error while calling agent %s: %w", c.base, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var e errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("agent %s returned %s", c.base, resp.Status)
+		}
+		return fmt.Errorf("agent %s returned %s: %s", c.base, resp.Status, e.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
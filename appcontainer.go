@@ -0,0 +1,117 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modntdll                = windows.NewLazySystemDLL("ntdll.dll")
+	procNtCreateLowBoxToken = modntdll.NewProc("NtCreateLowBoxToken")
+)
+
+const (
+	procThreadAttributeSecurityCapabilities         = 0x00020009
+	procThreadAttributeAllApplicationPackagesPolicy = 0x0002000F
+	processCreationAllApplicationPackagesOptOut     = 0x01
+)
+
+// AppContainer describes the AppContainer a process is launched in or a LowBox token is created for
+type AppContainer struct {
+	// SID is the AppContainer SID, see DeriveAppContainerSID or CreateAppContainerProfile
+	SID *windows.SID
+	// Capabilities are the capability SIDs granted to the AppContainer
+	Capabilities []*windows.SID
+	// LessPrivileged creates a Less Privileged AppContainer (LPAC). LPAC processes are not members of
+	// ALL APPLICATION PACKAGES (S-1-15-2-1), so only objects that grant ALL RESTRICTED APPLICATION PACKAGES
+	// (S-1-15-2-2) or one of the capabilities can be opened. This only applies to launched processes
+	LessPrivileged bool
+}
+
+// securityCapabilities mirrors SECURITY_CAPABILITIES
+type securityCapabilities struct {
+	appContainerSid *windows.SID
+	capabilities    *windows.SIDAndAttributes
+	capabilityCount uint32
+	reserved        uint32
+}
+
+func (ac *AppContainer) capabilityAttributes() []windows.SIDAndAttributes {
+	caps := make([]windows.SIDAndAttributes, len(ac.Capabilities))
+	for i, sid := range ac.Capabilities {
+		caps[i] = windows.SIDAndAttributes{Sid: sid, Attributes: windows.SE_GROUP_ENABLED}
+	}
+	return caps
+}
+
+// attributes returns the process thread attributes which launch a process inside the AppContainer
+func (ac *AppContainer) attributes() []procThreadAttribute {
+	sc := &securityCapabilities{appContainerSid: ac.SID}
+	if caps := ac.capabilityAttributes(); len(caps) > 0 {
+		sc.capabilities = &caps[0]
+		sc.capabilityCount = uint32(len(caps))
+	}
+
+	attrs := []procThreadAttribute{{
+		attribute: procThreadAttributeSecurityCapabilities,
+		value:     unsafe.Pointer(sc),
+		size:      unsafe.Sizeof(*sc),
+	}}
+
+	if ac.LessPrivileged {
+		policy := new(uint32)
+		*policy = processCreationAllApplicationPackagesOptOut
+		attrs = append(attrs, procThreadAttribute{
+			attribute: procThreadAttributeAllApplicationPackagesPolicy,
+			value:     unsafe.Pointer(policy),
+			size:      unsafe.Sizeof(*policy),
+		})
+	}
+
+	return attrs
+}
+
+// CreateLowBoxToken creates a LowBox (AppContainer) token from the token using NtCreateLowBoxToken.
+// The new token has the same type as the original one
+func (t *Token) CreateLowBoxToken(ac AppContainer) (*Token, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+	if ac.SID == nil {
+		return nil, ErrNoAppContainerSID
+	}
+
+	var (
+		lowBoxToken windows.Token
+		capsPtr     *windows.SIDAndAttributes
+	)
+	caps := ac.capabilityAttributes()
+	if len(caps) > 0 {
+		capsPtr = &caps[0]
+	}
+
+	oa := windows.OBJECT_ATTRIBUTES{}
+	oa.Length = uint32(unsafe.Sizeof(oa))
+
+	r1, _, _ := procNtCreateLowBoxToken.Call(
+		uintptr(unsafe.Pointer(&lowBoxToken)),
+		uintptr(t.token),
+		windows.TOKEN_ALL_ACCESS,
+		uintptr(unsafe.Pointer(&oa)),
+		uintptr(unsafe.Pointer(ac.SID)),
+		uintptr(len(caps)),
+		uintptr(unsafe.Pointer(capsPtr)),
+		0,
+		0,
+	)
+	runtime.KeepAlive(caps)
+	if r1 != 0 {
+		return nil, fmt.Errorf("error while NtCreateLowBoxToken: %w", windows.NTStatus(r1))
+	}
+
+	return newToken(lowBoxToken, t.typ), nil
+}
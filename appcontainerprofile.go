@@ -0,0 +1,113 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	moduserenv                                    = windows.NewLazySystemDLL("userenv.dll")
+	procCreateAppContainerProfile                 = moduserenv.NewProc("CreateAppContainerProfile")
+	procDeleteAppContainerProfile                 = moduserenv.NewProc("DeleteAppContainerProfile")
+	procDeriveAppContainerSidFromAppContainerName = moduserenv.NewProc("DeriveAppContainerSidFromAppContainerName")
+)
+
+// hresultAlreadyExists is HRESULT_FROM_WIN32(ERROR_ALREADY_EXISTS)
+const hresultAlreadyExists = 0x800700B7
+
+// copyFreeSID copies a SID allocated with AllocateAndInitializeSid into Go memory and frees it
+func copyFreeSID(sid *windows.SID) (*windows.SID, error) {
+	defer windows.FreeSid(sid)
+	return sid.Copy()
+}
+
+// CreateAppContainerProfile creates the AppContainer profile name with the given capabilities and returns its SID.
+// If the profile already exists, the SID of the existing profile is returned
+func CreateAppContainerProfile(name, displayName, description string, capabilities []*windows.SID) (*windows.SID, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	displayNamePtr, err := windows.UTF16PtrFromString(displayName)
+	if err != nil {
+		return nil, err
+	}
+	descriptionPtr, err := windows.UTF16PtrFromString(description)
+	if err != nil {
+		return nil, err
+	}
+
+	ac := AppContainer{Capabilities: capabilities}
+	caps := ac.capabilityAttributes()
+	var capsPtr *windows.SIDAndAttributes
+	if len(caps) > 0 {
+		capsPtr = &caps[0]
+	}
+
+	var sid *windows.SID
+	r1, _, _ := procCreateAppContainerProfile.Call(
+		uintptr(unsafe.Pointer(namePtr)),
+		uintptr(unsafe.Pointer(displayNamePtr)),
+		uintptr(unsafe.Pointer(descriptionPtr)),
+		uintptr(unsafe.Pointer(capsPtr)),
+		uintptr(len(caps)),
+		uintptr(unsafe.Pointer(&sid)),
+	)
+	if uint32(r1) == hresultAlreadyExists {
+		return DeriveAppContainerSID(name)
+	}
+	if err := hresultError(r1); err != nil {
+		return nil, fmt.Errorf("error while CreateAppContainerProfile: %w", err)
+	}
+
+	return copyFreeSID(sid)
+}
+
+// DeleteAppContainerProfile deletes the AppContainer profile name and its storage
+func DeleteAppContainerProfile(name string) error {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+
+	r1, _, _ := procDeleteAppContainerProfile.Call(uintptr(unsafe.Pointer(namePtr)))
+	if err := hresultError(r1); err != nil {
+		return fmt.Errorf("error while DeleteAppContainerProfile: %w", err)
+	}
+	return nil
+}
+
+// DeriveAppContainerSID derives the SID of the AppContainer name, the profile does not need to exist
+func DeriveAppContainerSID(name string) (*windows.SID, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var sid *windows.SID
+	r1, _, _ := procDeriveAppContainerSidFromAppContainerName.Call(uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(&sid)))
+	if err := hresultError(r1); err != nil {
+		return nil, fmt.Errorf("error while DeriveAppContainerSidFromAppContainerName: %w", err)
+	}
+
+	return copyFreeSID(sid)
+}
+
+// NewAppContainer creates (or reuses) the AppContainer profile name with the capabilities named by
+// capabilityNames, such as "internetClient", and returns an AppContainer ready to be used in StartOptions.
+// Call DeleteAppContainerProfile once the sandboxed processes have exited to clean up the profile
+func NewAppContainer(name string, capabilityNames ...string) (*AppContainer, error) {
+	caps, err := CapabilitySIDs(capabilityNames...)
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := CreateAppContainerProfile(name, name, name, caps)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppContainer{SID: sid, Capabilities: caps}, nil
+}
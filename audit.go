@@ -0,0 +1,146 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// AuditOperation identifies the token operation an AuditRecord describes
+type AuditOperation string
+
+const (
+	// AuditStealToken is recorded when the token of another process is opened and duplicated
+	AuditStealToken AuditOperation = "steal"
+	// AuditSessionToken is recorded when the token of a session user is queried with WTSQueryUserToken
+	AuditSessionToken AuditOperation = "session"
+	// AuditDuplicateToken is recorded when a Token is duplicated with ToPrimary, ToImpersonation or Clone
+	AuditDuplicateToken AuditOperation = "duplicate"
+	// AuditLaunch is recorded when a process is started with Token.StartProcess
+	AuditLaunch AuditOperation = "launch"
+	// AuditImpersonate is recorded when a thread impersonates a Token with RunAs or AssignToThread
+	AuditImpersonate AuditOperation = "impersonate"
+	// AuditPrivilegeChange is recorded when privileges of a Token are enabled, disabled or removed
+	AuditPrivilegeChange AuditOperation = "privileges"
+	// AuditLogon is recorded when a token is created by logging on an account with LogonUser or S4U
+	AuditLogon AuditOperation = "logon"
+)
+
+// AuditRecord describes a single token operation reported to the audit hook
+type AuditRecord struct {
+	Time      time.Time
+	Operation AuditOperation
+	// Duration is the time the operation took
+	Duration time.Duration
+	// PID is the process the token was taken from, or the launched process for AuditLaunch, 0 if not applicable
+	PID int
+	// SessionID is the session of the resulting token
+	SessionID uint32
+	// UserSID is the string SID of the user of the resulting token, empty if the operation failed
+	UserSID string
+	// Privileges lists the privileges which were modified for AuditPrivilegeChange
+	Privileges []Privilege
+	// Detail describes the operation further, such as "enabling" for AuditPrivilegeChange
+	Detail string
+	// Logon describes the logon for AuditLogon
+	Logon *LogonRecord
+	// Caller is the first function outside this package in the call stack, as function (file:line)
+	Caller string
+	// Err is the error returned by the operation, nil on success
+	Err error
+}
+
+// LogonRecord mirrors the fields of the Windows security event 4624 for a logon performed by this package, so the
+// audit trail can be reconciled with the security log through LogonID
+type LogonRecord struct {
+	// TargetUserName and TargetDomainName are the account which was requested to log on
+	TargetUserName   string
+	TargetDomainName string
+	LogonType        LogonType
+	// AuthenticationPackage, such as NTLM, Kerberos or Negotiate, and LogonID come from the logon session
+	AuthenticationPackage string
+	LogonID               windows.LUID
+	// LogonProcess is the name of the token source, such as "Advapi" for LogonUser
+	LogonProcess  string
+	ElevatedToken bool
+}
+
+// packagePath is the import path of this package, used to skip its frames when looking for the caller
+const packagePath = "github.com/fourcorelabs/wintoken"
+
+var auditHook atomic.Value
+
+// SetAuditHook registers fn to be called after every token steal, duplication, impersonation, privilege change, logon and launch, successful or not.
+// fn runs synchronously on the goroutine performing the operation and should return quickly, pass nil to remove the hook
+func SetAuditHook(fn func(AuditRecord)) {
+	auditHook.Store(fn)
+}
+
+// audit reports an operation started at start to the audit hook, the ETW provider, the event log and the metrics.
+// token is the resulting token and may be 0 if the operation failed
+func audit(op AuditOperation, start time.Time, pid int, sessionID uint32, token windows.Token, err error) {
+	auditRecord(AuditRecord{Operation: op, PID: pid, SessionID: sessionID, Err: err}, start, token)
+}
+
+// auditRecord completes rec with the time, caller and token details and reports it
+func auditRecord(rec AuditRecord, start time.Time, token windows.Token) {
+	rec.Time = time.Now()
+	rec.Duration = rec.Time.Sub(start)
+	recordMetrics(rec)
+
+	if !auditConsumed() {
+		return
+	}
+	fn, _ := auditHook.Load().(func(AuditRecord))
+
+	rec.Caller = auditCaller()
+	if token != 0 && rec.Err == nil {
+		rec.UserSID = tokenUserSID(token)
+		if id, err := tokenInfoUint32(token, windows.TokenSessionId); err == nil {
+			rec.SessionID = id
+		}
+	}
+
+	if fn != nil {
+		fn(rec)
+	}
+	writeETWEvent(rec)
+	writeEventLog(rec)
+}
+
+// auditLogon reports a logon of domain\user started at start, token is the raw logon token and may be 0 if the
+// logon failed. The logon session is only queried when someone consumes the record
+func auditLogon(start time.Time, user, domain string, logonType LogonType, token windows.Token, err error) {
+	rec := AuditRecord{Operation: AuditLogon, Err: err}
+	if auditConsumed() {
+		rec.Logon = newLogonRecord(user, domain, logonType, token)
+	}
+	auditRecord(rec, start, token)
+}
+
+// auditConsumed reports whether audit records are consumed beyond the metrics
+func auditConsumed() bool {
+	fn, _ := auditHook.Load().(func(AuditRecord))
+	return fn != nil || etwEnabled() || eventLogEnabled()
+}
+
+// auditCaller returns the first frame of the call stack which does not belong to this package
+func auditCaller() string {
+	pc := make([]uintptr, 32)
+	n := runtime.Callers(3, pc)
+	frames := runtime.CallersFrames(pc[:n])
+
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, packagePath+".") {
+			return fmt.Sprintf("%s (%s:%d)", frame.Function, frame.File, frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
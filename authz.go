@@ -0,0 +1,187 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modauthz                            = windows.NewLazySystemDLL("authz.dll")
+	procAuthzInitializeResourceManager  = modauthz.NewProc("AuthzInitializeResourceManager")
+	procAuthzFreeResourceManager        = modauthz.NewProc("AuthzFreeResourceManager")
+	procAuthzInitializeContextFromToken = modauthz.NewProc("AuthzInitializeContextFromToken")
+	procAuthzInitializeContextFromSid   = modauthz.NewProc("AuthzInitializeContextFromSid")
+	procAuthzFreeContext                = modauthz.NewProc("AuthzFreeContext")
+	procAuthzAccessCheck                = modauthz.NewProc("AuthzAccessCheck")
+	procAuthzGetInformationFromContext  = modauthz.NewProc("AuthzGetInformationFromContext")
+)
+
+const (
+	authzRMFlagNoAudit        = 0x1
+	authzContextInfoGroupSids = 2
+	maximumAllowed            = 0x02000000
+)
+
+// authzAccessRequest is the AUTHZ_ACCESS_REQUEST structure
+type authzAccessRequest struct {
+	desiredAccess        uint32
+	principalSelfSid     *windows.SID
+	objectTypeList       uintptr
+	objectTypeListLength uint32
+	optionalArguments    uintptr
+}
+
+// authzAccessReply is the AUTHZ_ACCESS_REPLY structure for a single result
+type authzAccessReply struct {
+	resultListLength      uint32
+	grantedAccessMask     *uint32
+	saclEvaluationResults *uint32
+	err                   *uint32
+}
+
+// AuthzContext is an Authz client context, it computes access the way the kernel would for a token
+// without impersonating, and can evaluate any security descriptor, including ones of objects on other machines.
+// It must be closed with Close
+type AuthzContext struct {
+	rm  windows.Handle
+	ctx windows.Handle
+}
+
+func newAuthzResourceManager() (windows.Handle, error) {
+	var rm windows.Handle
+	if r1, _, err := procAuthzInitializeResourceManager.Call(authzRMFlagNoAudit, 0, 0, 0, 0, uintptr(unsafe.Pointer(&rm))); r1 == 0 {
+		return 0, fmt.Errorf("error while AuthzInitializeResourceManager: %w", err)
+	}
+	return rm, nil
+}
+
+// authzInitializeContext calls AuthzInitializeContextFromToken or AuthzInitializeContextFromSid, whose LUID Identifier
+// is passed by value: in one register on 64-bit Windows and in two stack slots on 386. The identifier is left zero
+func authzInitializeContext(proc *windows.LazyProc, subject uintptr, rm windows.Handle, ctx *windows.Handle) error {
+	var (
+		id windows.LUID
+		r1 uintptr
+		e1 error
+	)
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		r1, _, e1 = proc.Call(0, subject, uintptr(rm), 0, uintptr(*(*uint64)(unsafe.Pointer(&id))), 0, uintptr(unsafe.Pointer(ctx)))
+	} else {
+		r1, _, e1 = proc.Call(0, subject, uintptr(rm), 0, uintptr(id.LowPart), uintptr(id.HighPart), 0, uintptr(unsafe.Pointer(ctx)))
+	}
+	if r1 == 0 {
+		return e1
+	}
+	return nil
+}
+
+// AuthzContext creates an Authz client context from the groups and privileges of the token
+func (t *Token) AuthzContext() (*AuthzContext, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	rm, err := newAuthzResourceManager()
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx windows.Handle
+	if err := authzInitializeContext(procAuthzInitializeContextFromToken, uintptr(t.token), rm, &ctx); err != nil {
+		procAuthzFreeResourceManager.Call(uintptr(rm))
+		return nil, fmt.Errorf("error while AuthzInitializeContextFromToken: %w", err)
+	}
+	return &AuthzContext{rm: rm, ctx: ctx}, nil
+}
+
+// NewAuthzContextFromSID creates an Authz client context for an account without a token. Authz expands its group
+// memberships, including nested and domain-local groups of the local domain, so the context reflects what a logon on
+// this machine would get. Expanding domain accounts needs a domain controller and the caller may need to be a member
+// of the Windows Authorization Access Group
+func NewAuthzContextFromSID(sid *windows.SID) (*AuthzContext, error) {
+	rm, err := newAuthzResourceManager()
+	if err != nil {
+		return nil, err
+	}
+
+	var ctx windows.Handle
+	err = authzInitializeContext(procAuthzInitializeContextFromSid, uintptr(unsafe.Pointer(sid)), rm, &ctx)
+	runtime.KeepAlive(sid)
+	if err != nil {
+		procAuthzFreeResourceManager.Call(uintptr(rm))
+		return nil, fmt.Errorf("error while AuthzInitializeContextFromSid: %w", err)
+	}
+	return &AuthzContext{rm: rm, ctx: ctx}, nil
+}
+
+// Close frees the client context and its resource manager
+func (c *AuthzContext) Close() {
+	if c.ctx != 0 {
+		procAuthzFreeContext.Call(uintptr(c.ctx))
+		c.ctx = 0
+	}
+	if c.rm != 0 {
+		procAuthzFreeResourceManager.Call(uintptr(c.rm))
+		c.rm = 0
+	}
+}
+
+// Groups lists the group SIDs of the client context, for contexts created from a SID these are the expanded groups
+func (c *AuthzContext) Groups() ([]GroupDetail, error) {
+	var n uint32
+	procAuthzGetInformationFromContext.Call(uintptr(c.ctx), authzContextInfoGroupSids, 0, uintptr(unsafe.Pointer(&n)), 0)
+	if n == 0 {
+		return nil, nil
+	}
+
+	b := make([]byte, n)
+	if r1, _, err := procAuthzGetInformationFromContext.Call(uintptr(c.ctx), authzContextInfoGroupSids, uintptr(n), uintptr(unsafe.Pointer(&n)), uintptr(unsafe.Pointer(&b[0]))); r1 == 0 {
+		return nil, fmt.Errorf("error while AuthzGetInformationFromContext: %w", err)
+	}
+	return decodeGroups((*windows.Tokengroups)(unsafe.Pointer(&b[0]))), nil
+}
+
+// accessCheck runs AuthzAccessCheck and returns the granted mask with the access check status of the reply
+func (c *AuthzContext) accessCheck(sd *windows.SECURITY_DESCRIPTOR, desired uint32) (uint32, windows.Errno, error) {
+	var granted, saclResult, status uint32
+	request := authzAccessRequest{desiredAccess: desired}
+	reply := authzAccessReply{
+		resultListLength:      1,
+		grantedAccessMask:     &granted,
+		saclEvaluationResults: &saclResult,
+		err:                   &status,
+	}
+
+	if r1, _, err := procAuthzAccessCheck.Call(0, uintptr(c.ctx), uintptr(unsafe.Pointer(&request)), 0, uintptr(unsafe.Pointer(sd)), 0, 0, uintptr(unsafe.Pointer(&reply)), 0); r1 == 0 {
+		return 0, 0, fmt.Errorf("error while AuthzAccessCheck: %w", err)
+	}
+	return granted, windows.Errno(status), nil
+}
+
+// AccessCheck evaluates sd for the client context with AuthzAccessCheck and returns the granted access mask.
+// If desired is not fully granted the granted mask is returned with an error wrapping windows.ERROR_ACCESS_DENIED
+func (c *AuthzContext) AccessCheck(sd *windows.SECURITY_DESCRIPTOR, desired uint32) (uint32, error) {
+	granted, status, err := c.accessCheck(sd, desired)
+	if err != nil {
+		return 0, err
+	}
+	if status != 0 {
+		return granted, fmt.Errorf("access check failed: %w", status)
+	}
+	return granted, nil
+}
+
+// MaximumAccess returns every access right the client context is granted by sd, zero if it grants nothing
+func (c *AuthzContext) MaximumAccess(sd *windows.SECURITY_DESCRIPTOR) (uint32, error) {
+	granted, status, err := c.accessCheck(sd, maximumAllowed)
+	if err != nil {
+		return 0, err
+	}
+	if status != 0 && status != windows.ERROR_ACCESS_DENIED {
+		return granted, fmt.Errorf("access check failed: %w", status)
+	}
+	return granted, nil
+}
@@ -0,0 +1,320 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// TokenBackend is how a TokenBuilder creates its token
+type TokenBackend int
+
+const (
+	// BackendRestricted derives the token from a base token with CreateRestrictedToken, it cannot change the user
+	// or add groups
+	BackendRestricted TokenBackend = iota
+	// BackendS4U logs the user on with S4ULogon, the token gets the real groups of the user
+	BackendS4U
+	// BackendCreateToken forges the token with CreateToken, it needs SeCreateTokenPrivilege
+	BackendCreateToken
+)
+
+func (b TokenBackend) String() string {
+	switch b {
+	case BackendRestricted:
+		return "RestrictedToken"
+	case BackendS4U:
+		return "S4U"
+	case BackendCreateToken:
+		return "NtCreateToken"
+	default:
+		return "Unknown"
+	}
+}
+
+// TokenBuilder describes a token and builds it with the best backend available to the caller, for example
+//
+//	t, err := wintoken.NewTokenBuilder().User(sid).AddGroup(group).AddPrivilege(wintoken.SeChangeNotifyPrivilege).Integrity(wintoken.IntegrityLow).Build()
+//
+// Errors of the builder methods are reported by Build
+type TokenBuilder struct {
+	user         *windows.SID
+	groups       []windows.SIDAndAttributes
+	privileges   []Privilege
+	integrity    IntegrityLevel
+	hasIntegrity bool
+	policy       MandatoryPolicy
+	hasPolicy    bool
+	base         *Token
+	typ          tokenType
+	opts         []Option
+	err          error
+}
+
+// NewTokenBuilder starts describing a primary token for the user of the current process
+func NewTokenBuilder() *TokenBuilder {
+	return &TokenBuilder{typ: TokenPrimary}
+}
+
+// User sets the token user, it defaults to the user of the base token
+func (b *TokenBuilder) User(sid *windows.SID) *TokenBuilder {
+	b.user = sid
+	return b
+}
+
+// UserName sets the token user by account name, such as DOMAIN\user
+func (b *TokenBuilder) UserName(name string) *TokenBuilder {
+	sid, _, _, err := windows.LookupSID("", name)
+	if err != nil && b.err == nil {
+		b.err = fmt.Errorf("error while looking up %s: %w", name, err)
+	}
+	b.user = sid
+	return b
+}
+
+// AddGroup adds an enabled, mandatory group to the token
+func (b *TokenBuilder) AddGroup(sid *windows.SID) *TokenBuilder {
+	return b.AddGroupWithAttributes(sid, windows.SE_GROUP_MANDATORY|windows.SE_GROUP_ENABLED_BY_DEFAULT|windows.SE_GROUP_ENABLED)
+}
+
+// AddGroupWithAttributes adds a group with explicit SE_GROUP_* attributes
+func (b *TokenBuilder) AddGroupWithAttributes(sid *windows.SID, attributes uint32) *TokenBuilder {
+	b.groups = append(b.groups, windows.SIDAndAttributes{Sid: sid, Attributes: attributes})
+	return b
+}
+
+// AddPrivilege adds an enabled privilege. Once a privilege is added the token holds only the added privileges
+func (b *TokenBuilder) AddPrivilege(privs ...Privilege) *TokenBuilder {
+	b.privileges = append(b.privileges, privs...)
+	return b
+}
+
+// Integrity sets the integrity level of the token. Levels above the one of the base token need SeTcbPrivilege
+// except with BackendCreateToken
+func (b *TokenBuilder) Integrity(level IntegrityLevel) *TokenBuilder {
+	b.integrity, b.hasIntegrity = level, true
+	return b
+}
+
+// MandatoryPolicy sets the mandatory integrity policy of the token, which needs SeTcbPrivilege
+func (b *TokenBuilder) MandatoryPolicy(p MandatoryPolicy) *TokenBuilder {
+	b.policy, b.hasPolicy = p, true
+	return b
+}
+
+// From sets the base token of BackendRestricted, it defaults to the token of the current process.
+// The base token is not closed by Build
+func (b *TokenBuilder) From(t *Token) *TokenBuilder {
+	b.base = t
+	return b
+}
+
+// Type sets the type of the built token, TokenPrimary by default
+func (b *TokenBuilder) Type(tokenType tokenType) *TokenBuilder {
+	b.typ = tokenType
+	return b
+}
+
+// Options sets the options applied to the built token, such as WithImpersonationLevel
+func (b *TokenBuilder) Options(opts ...Option) *TokenBuilder {
+	b.opts = opts
+	return b
+}
+
+// baseToken returns the base token and whether it was opened by the builder and must be closed
+func (b *TokenBuilder) baseToken() (*Token, bool, error) {
+	if b.base != nil {
+		return b.base, false, nil
+	}
+	t, err := OpenProcessToken(0, b.typ, b.opts...)
+	return t, true, err
+}
+
+// Backend returns the backend Build uses: BackendRestricted when the user is the one of the base token and every
+// group is already in it, otherwise BackendCreateToken if the caller holds SeCreateTokenPrivilege and BackendS4U if not
+func (b *TokenBuilder) Backend() (TokenBackend, error) {
+	base, owned, err := b.baseToken()
+	if err != nil {
+		return 0, err
+	}
+	if owned {
+		defer base.Close()
+	}
+	return b.backend(base)
+}
+
+func (b *TokenBuilder) backend(base *Token) (TokenBackend, error) {
+	if err := base.acquire(); err != nil {
+		return 0, err
+	}
+	defer base.release()
+
+	restricted := true
+	if b.user != nil && tokenUserSID(base.token) != b.user.String() {
+		restricted = false
+	}
+	if restricted && len(b.groups) > 0 {
+		groups, err := base.groupsInfo(windows.TokenGroups)
+		if err != nil {
+			return 0, fmt.Errorf("error while getting token groups: %w", err)
+		}
+		held := make(map[string]bool, len(groups))
+		for _, g := range groups {
+			held[g.SID] = true
+		}
+		for _, g := range b.groups {
+			if !held[g.Sid.String()] {
+				restricted = false
+				break
+			}
+		}
+	}
+	if restricted {
+		return BackendRestricted, nil
+	}
+
+	if luid, err := privilegeLUID(SeCreateTokenPrivilege); err == nil && holdsPrivilege(windows.GetCurrentProcessToken(), luid) {
+		return BackendCreateToken, nil
+	}
+	return BackendS4U, nil
+}
+
+// Build creates the token with the backend reported by Backend
+func (b *TokenBuilder) Build() (*Token, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+
+	base, owned, err := b.baseToken()
+	if err != nil {
+		return nil, err
+	}
+	if owned {
+		defer base.Close()
+	}
+
+	backend, err := b.backend(base)
+	if err != nil {
+		return nil, err
+	}
+
+	user := b.user
+	if user == nil {
+		tu, err := base.Token().GetTokenUser()
+		if err != nil {
+			return nil, fmt.Errorf("error while getting token user: %w", err)
+		}
+		if user, err = tu.User.Sid.Copy(); err != nil {
+			return nil, err
+		}
+	}
+
+	var t *Token
+	switch backend {
+	case BackendRestricted:
+		t, err = b.restrict(base)
+	case BackendCreateToken:
+		t, err = b.buildCreateToken(user)
+	default:
+		t, err = b.buildS4U(user)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if b.hasIntegrity && backend != BackendCreateToken {
+		if err := t.SetIntegrityLevel(b.integrity); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	if b.hasPolicy {
+		if err := t.SetMandatoryPolicy(b.policy); err != nil {
+			t.Close()
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// unwantedPrivileges lists the privileges of t which were not added to the builder
+func (b *TokenBuilder) unwantedPrivileges(t *Token) ([]Privilege, error) {
+	if len(b.privileges) == 0 {
+		return nil, nil
+	}
+
+	privs, err := t.GetPrivileges()
+	if err != nil {
+		return nil, err
+	}
+	var unwanted []Privilege
+	for _, p := range privs {
+		wanted := false
+		for _, w := range b.privileges {
+			if strings.EqualFold(string(p.Name), string(w)) {
+				wanted = true
+				break
+			}
+		}
+		if !wanted && !p.Removed {
+			unwanted = append(unwanted, p.Name)
+		}
+	}
+	return unwanted, nil
+}
+
+// restrict deletes the privileges which were not added and enables the added ones
+func (b *TokenBuilder) restrict(t *Token) (*Token, error) {
+	unwanted, err := b.unwantedPrivileges(t)
+	if err != nil {
+		return nil, err
+	}
+
+	rt, err := t.CreateRestrictedToken(RestrictedTokenOptions{DeletePrivileges: unwanted})
+	if err != nil {
+		return nil, err
+	}
+	rt = rt.withOptions(newOptions(b.opts))
+	if len(b.privileges) > 0 {
+		if _, err := rt.EnableTokenPrivileges(b.privileges...); err != nil {
+			rt.Close()
+			return nil, err
+		}
+	}
+	return rt, nil
+}
+
+func (b *TokenBuilder) buildS4U(sid *windows.SID) (*Token, error) {
+	user, domain, _, err := sid.LookupAccount("")
+	if err != nil {
+		return nil, fmt.Errorf("error while looking up %s: %w", sid, err)
+	}
+
+	t, err := S4ULogon(user, domain, b.groups, b.typ, b.opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	return b.restrict(t)
+}
+
+func (b *TokenBuilder) buildCreateToken(user *windows.SID) (*Token, error) {
+	groups := append([]windows.SIDAndAttributes{}, b.groups...)
+
+	level := IntegrityMedium
+	if b.hasIntegrity {
+		level = b.integrity
+	}
+	label, err := windows.StringToSid(level.SID())
+	if err != nil {
+		return nil, err
+	}
+	groups = append(groups, windows.SIDAndAttributes{Sid: label, Attributes: windows.SE_GROUP_INTEGRITY | windows.SE_GROUP_INTEGRITY_ENABLED})
+
+	return CreateToken(TokenSpec{
+		User:       user,
+		Groups:     groups,
+		Privileges: b.privileges,
+	}, b.typ, b.opts...)
+}
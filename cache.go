@@ -0,0 +1,162 @@
+package wintoken
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+var (
+	procWTSWaitSystemEvent = modwtsapi32.NewProc("WTSWaitSystemEvent")
+)
+
+const (
+	wtsEventCreate      = 0x00000001
+	wtsEventDelete      = 0x00000002
+	wtsEventConnect     = 0x00000008
+	wtsEventDisconnect  = 0x00000010
+	wtsEventLogon       = 0x00000020
+	wtsEventLogoff      = 0x00000040
+	wtsEventStateChange = 0x00000080
+	wtsEventFlush       = 0x80000000
+
+	// wtsSessionEvents are the session changes which can make a cached token stale
+	wtsSessionEvents = wtsEventCreate | wtsEventDelete | wtsEventConnect | wtsEventDisconnect | wtsEventLogon | wtsEventLogoff | wtsEventStateChange
+)
+
+type cacheEntry struct {
+	t       *Token
+	expires time.Time
+}
+
+// TokenCache caches the tokens returned by GetInteractiveToken and GetSessionToken for agents which need them
+// frequently, so sessions are not enumerated and tokens not duplicated on every call. Entries expire after the TTL
+// and the whole cache is dropped whenever a session is created, connected, disconnected, logged on or off.
+// Services which receive session change notifications themselves can also call Invalidate.
+// A TokenCache is safe for concurrent use and must be closed with Close
+type TokenCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	closed  bool
+}
+
+// NewTokenCache creates a TokenCache whose entries live for ttl and starts watching session changes with
+// WTSWaitSystemEvent. If session events cannot be watched the cache relies on the TTL alone
+func NewTokenCache(ttl time.Duration) *TokenCache {
+	c := &TokenCache{ttl: ttl, entries: make(map[string]cacheEntry)}
+	go c.watchSessions()
+	return c
+}
+
+// watchSessions invalidates the cache on every session change until the cache is closed
+func (c *TokenCache) watchSessions() {
+	for {
+		var flags uint32
+		r1, _, _ := procWTSWaitSystemEvent.Call(uintptr(WTS_CURRENT_SERVER_HANDLE), wtsSessionEvents, uintptr(unsafe.Pointer(&flags)))
+
+		c.mu.Lock()
+		closed := c.closed
+		c.mu.Unlock()
+		if closed || r1 == 0 {
+			return
+		}
+		if flags&wtsSessionEvents != 0 {
+			c.Invalidate()
+		}
+	}
+}
+
+// get returns a clone of the cached token for key, or acquires a new token with acquire and caches it
+func (c *TokenCache) get(key string, o options, acquire func() (*Token, error)) (*Token, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrTokenClosed
+	}
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.expires) {
+		delete(c.entries, key)
+		e.t.Close()
+		ok = false
+	}
+	if ok {
+		// the clone is made under the lock so Invalidate cannot close the cached token meanwhile
+		t, err := e.t.Clone()
+		c.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return t.withOptions(o), nil
+	}
+	c.mu.Unlock()
+
+	t, err := acquire()
+	if err != nil {
+		return nil, err
+	}
+	cached, err := t.Clone()
+	if err != nil {
+		// the token is still usable, it is just not cached
+		return t, nil
+	}
+
+	c.mu.Lock()
+	if old, ok := c.entries[key]; ok {
+		old.t.Close()
+	}
+	if c.closed {
+		cached.Close()
+	} else {
+		c.entries[key] = cacheEntry{t: cached, expires: time.Now().Add(c.ttl)}
+	}
+	c.mu.Unlock()
+	return t, nil
+}
+
+// cacheKey identifies a cached token by what was requested, options included since they change the result
+func cacheKey(kind string, sessionID uint32, tokenType tokenType, o options) string {
+	return fmt.Sprintf("%s/%d/%d/%+v", kind, sessionID, tokenType, o)
+}
+
+// GetInteractiveToken is GetInteractiveToken served from the cache. The returned Token is a clone owned by the caller
+func (c *TokenCache) GetInteractiveToken(tokenType tokenType, opts ...Option) (*Token, error) {
+	o := newOptions(opts)
+	return c.get(cacheKey("interactive", 0, tokenType, o), o, func() (*Token, error) {
+		return GetInteractiveToken(tokenType, opts...)
+	})
+}
+
+// GetSessionToken is GetSessionToken served from the cache. The returned Token is a clone owned by the caller
+func (c *TokenCache) GetSessionToken(sessionID uint32, tokenType tokenType, opts ...Option) (*Token, error) {
+	o := newOptions(opts)
+	return c.get(cacheKey("session", sessionID, tokenType, o), o, func() (*Token, error) {
+		return GetSessionToken(sessionID, tokenType, opts...)
+	})
+}
+
+// Invalidate closes and drops every cached token
+func (c *TokenCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, e := range c.entries {
+		e.t.Close()
+		delete(c.entries, key)
+	}
+}
+
+// Close drops the cached tokens and stops watching session changes, tokens returned earlier stay valid
+func (c *TokenCache) Close() {
+	c.Invalidate()
+
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+
+	// wake up the watcher blocked in WTSWaitSystemEvent
+	var flags uint32
+	procWTSWaitSystemEvent.Call(uintptr(WTS_CURRENT_SERVER_HANDLE), wtsEventFlush, uintptr(unsafe.Pointer(&flags)))
+}
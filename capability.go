@@ -0,0 +1,88 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernelbase                    = windows.NewLazySystemDLL("kernelbase.dll")
+	procDeriveCapabilitySidsFromName = modkernelbase.NewProc("DeriveCapabilitySidsFromName")
+)
+
+// copyLocalSIDs copies an array of LocalAlloc'ed SIDs into Go memory and frees the array and the SIDs
+func copyLocalSIDs(array **windows.SID, count uint32) ([]*windows.SID, error) {
+	if array == nil {
+		return nil, nil
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(array)))
+
+	local := (*[1 << 20]*windows.SID)(unsafe.Pointer(array))[:count:count]
+	sids := make([]*windows.SID, 0, count)
+
+	var err error
+	for _, sid := range local {
+		if err == nil {
+			var c *windows.SID
+			if c, err = sid.Copy(); err == nil {
+				sids = append(sids, c)
+			}
+		}
+		windows.LocalFree(windows.Handle(unsafe.Pointer(sid)))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sids, nil
+}
+
+// DeriveCapabilitySIDsFromName derives the group SIDs and capability SIDs of a capability name such as
+// "internetClient" using DeriveCapabilitySidsFromName. The capability SIDs are the ones used by AppContainers
+func DeriveCapabilitySIDsFromName(name string) (groupSIDs, capabilitySIDs []*windows.SID, err error) {
+	capName, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		groups, caps         **windows.SID
+		groupCount, capCount uint32
+	)
+
+	if r1, _, err := procDeriveCapabilitySidsFromName.Call(
+		uintptr(unsafe.Pointer(capName)),
+		uintptr(unsafe.Pointer(&groups)),
+		uintptr(unsafe.Pointer(&groupCount)),
+		uintptr(unsafe.Pointer(&caps)),
+		uintptr(unsafe.Pointer(&capCount)),
+	); r1 == 0 {
+		return nil, nil, fmt.Errorf("error while DeriveCapabilitySidsFromName for %s: %w", name, err)
+	}
+
+	groupSIDs, groupErr := copyLocalSIDs(groups, groupCount)
+	capabilitySIDs, capErr := copyLocalSIDs(caps, capCount)
+	if groupErr != nil {
+		return nil, nil, groupErr
+	}
+	if capErr != nil {
+		return nil, nil, capErr
+	}
+
+	return groupSIDs, capabilitySIDs, nil
+}
+
+// CapabilitySIDs derives the AppContainer capability SIDs of the given capability names,
+// ready to be used as AppContainer.Capabilities
+func CapabilitySIDs(names ...string) ([]*windows.SID, error) {
+	var sids []*windows.SID
+	for _, name := range names {
+		_, caps, err := DeriveCapabilitySIDsFromName(name)
+		if err != nil {
+			return nil, err
+		}
+		sids = append(sids, caps...)
+	}
+	return sids, nil
+}
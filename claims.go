@@ -0,0 +1,133 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Claim value types of CLAIM_SECURITY_ATTRIBUTE_V1
+const (
+	ClaimTypeInt64       = 0x01
+	ClaimTypeUint64      = 0x02
+	ClaimTypeString      = 0x03
+	ClaimTypeFQBN        = 0x04
+	ClaimTypeSID         = 0x05
+	ClaimTypeBoolean     = 0x06
+	ClaimTypeOctetString = 0x10
+)
+
+// Claim is a user or device claim attached to a token, used by Dynamic Access Control
+type Claim struct {
+	Name      string
+	ValueType uint16
+	Flags     uint32
+	// Values holds int64, uint64, string, bool or []byte values depending on ValueType
+	Values []interface{}
+}
+
+func (c Claim) String() string {
+	return fmt.Sprintf("%s: %v", c.Name, c.Values)
+}
+
+type claimSecurityAttributesInformation struct {
+	version        uint16
+	reserved       uint16
+	attributeCount uint32
+	attributeV1    *claimSecurityAttributeV1
+}
+
+type claimSecurityAttributeV1 struct {
+	name       *uint16
+	valueType  uint16
+	reserved   uint16
+	flags      uint32
+	valueCount uint32
+	values     unsafe.Pointer
+}
+
+type claimOctetStringValue struct {
+	value       *byte
+	valueLength uint32
+}
+
+type claimFQBNValue struct {
+	version uint64
+	name    *uint16
+}
+
+// parseClaims decodes a CLAIM_SECURITY_ATTRIBUTES_INFORMATION buffer returned by GetTokenInformation
+func parseClaims(b []byte) []Claim {
+	if len(b) < int(unsafe.Sizeof(claimSecurityAttributesInformation{})) {
+		return nil
+	}
+
+	info := (*claimSecurityAttributesInformation)(unsafe.Pointer(&b[0]))
+	if info.attributeCount == 0 || info.attributeV1 == nil {
+		return nil
+	}
+
+	attrs := (*[1 << 16]claimSecurityAttributeV1)(unsafe.Pointer(info.attributeV1))[:info.attributeCount:info.attributeCount]
+	claims := make([]Claim, len(attrs))
+	for i, a := range attrs {
+		c := Claim{
+			Name:      windows.UTF16PtrToString(a.name),
+			ValueType: a.valueType,
+			Flags:     a.flags,
+		}
+		n := int(a.valueCount)
+		for j := 0; j < n && a.values != nil; j++ {
+			switch a.valueType {
+			case ClaimTypeInt64:
+				c.Values = append(c.Values, (*[1 << 16]int64)(a.values)[j])
+			case ClaimTypeUint64:
+				c.Values = append(c.Values, (*[1 << 16]uint64)(a.values)[j])
+			case ClaimTypeBoolean:
+				c.Values = append(c.Values, (*[1 << 16]uint64)(a.values)[j] != 0)
+			case ClaimTypeString:
+				c.Values = append(c.Values, windows.UTF16PtrToString((*[1 << 16]*uint16)(a.values)[j]))
+			case ClaimTypeFQBN:
+				c.Values = append(c.Values, windows.UTF16PtrToString((*[1 << 16]claimFQBNValue)(a.values)[j].name))
+			case ClaimTypeSID, ClaimTypeOctetString:
+				v := (*[1 << 16]claimOctetStringValue)(a.values)[j]
+				data := make([]byte, v.valueLength)
+				if v.valueLength > 0 {
+					copy(data, (*[1 << 20]byte)(unsafe.Pointer(v.value))[:v.valueLength:v.valueLength])
+				}
+				c.Values = append(c.Values, data)
+			}
+		}
+		claims[i] = c
+	}
+	return claims
+}
+
+// UserClaims returns the user claims of the token (TokenUserClaimAttributes)
+func (t *Token) UserClaims() ([]Claim, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	b, err := t.info(tokenUserClaimAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting user claims: %w", err)
+	}
+	return parseClaims(b), nil
+}
+
+// DeviceClaims returns the device claims of the token (TokenDeviceClaimAttributes). They are only
+// present on domain-joined machines when compound identity is enabled for device-based conditional access
+func (t *Token) DeviceClaims() ([]Claim, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	b, err := t.info(tokenDeviceClaimAttributes)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting device claims: %w", err)
+	}
+	return parseClaims(b), nil
+}
@@ -0,0 +1,267 @@
+// Command wintoken exercises the wintoken library from the command line.
+//
+// Usage:
+//
+//	wintoken info [-pid N] [-linked]
+//	wintoken steal -pid N [-linked] [-wait] [-- program args...]
+//	wintoken interactive [-linked] [-info] [-wait] [-- program args...]
+//	wintoken run -as system|user|pid=N|service=NAME [-linked] [-enable privs] [-wait] [-- program args...]
+//
+// steal, interactive and run launch cmd.exe in a new console when no program is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fourcorelabs/wintoken"
+	"golang.org/x/sys/windows"
+)
+
+const usage = `usage: wintoken <command> [flags] [-- program args...]
+
+commands:
+  info         print a whoami /all style report of a process token
+  steal        steal the token of a process and launch a program with it
+  interactive  launch a program as the user logged on to the active session
+  run          acquire a token, enable privileges on it and launch a program in one step
+
+run "wintoken <command> -h" for the flags of a command
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "info":
+		err = info(args)
+	case "steal":
+		err = steal(args)
+	case "interactive":
+		err = interactive(args)
+	case "run":
+		err = run(args)
+	case "-h", "-help", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", cmd, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "wintoken:", err)
+		os.Exit(1)
+	}
+}
+
+// openProcessToken opens the primary token of a process, or its linked token if linked is set
+func openProcessToken(pid int, linked bool) (*wintoken.Token, error) {
+	if linked {
+		return wintoken.OpenProcessToken(pid, wintoken.TokenLinked)
+	}
+	return wintoken.OpenProcessToken(pid, wintoken.TokenPrimary)
+}
+
+// interactiveToken gets the primary token of the active session user, or its linked token if linked is set
+func interactiveToken(linked bool) (*wintoken.Token, error) {
+	if linked {
+		return wintoken.GetInteractiveToken(wintoken.TokenLinked)
+	}
+	return wintoken.GetInteractiveToken(wintoken.TokenPrimary)
+}
+
+// withDebugPrivilege runs open with SeDebugPrivilege enabled on the process token, so tokens of other users'
+// processes can be opened, and restores the privilege afterwards
+func withDebugPrivilege(open func() (*wintoken.Token, error)) (*wintoken.Token, error) {
+	var t *wintoken.Token
+	err := wintoken.WithPrivilege(wintoken.SeDebugPrivilege, func() error {
+		var err error
+		t, err = open()
+		return err
+	})
+	return t, err
+}
+
+func info(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	pid := fs.Int("pid", 0, "process to inspect, defaults to wintoken itself")
+	linked := fs.Bool("linked", false, "inspect the linked (elevated) token")
+	fs.Parse(args)
+
+	open := func() (*wintoken.Token, error) { return openProcessToken(*pid, *linked) }
+	var (
+		t   *wintoken.Token
+		err error
+	)
+	if *pid != 0 {
+		t, err = withDebugPrivilege(open)
+	} else {
+		t, err = open()
+	}
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	report, err := t.Report()
+	if err != nil {
+		return err
+	}
+	fmt.Print(report)
+	return nil
+}
+
+func steal(args []string) error {
+	fs := flag.NewFlagSet("steal", flag.ExitOnError)
+	pid := fs.Int("pid", 0, "process whose token is stolen")
+	linked := fs.Bool("linked", false, "use the linked (elevated) token")
+	wait := fs.Bool("wait", false, "wait for the launched program to exit")
+	fs.Parse(args)
+
+	if *pid == 0 {
+		return fmt.Errorf("steal: -pid is required")
+	}
+
+	t, err := withDebugPrivilege(func() (*wintoken.Token, error) { return openProcessToken(*pid, *linked) })
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	return launch(t, fs.Args(), *wait)
+}
+
+func interactive(args []string) error {
+	fs := flag.NewFlagSet("interactive", flag.ExitOnError)
+	linked := fs.Bool("linked", false, "use the linked (elevated) token of the user")
+	printInfo := fs.Bool("info", false, "print a report of the token instead of launching a program")
+	wait := fs.Bool("wait", false, "wait for the launched program to exit")
+	fs.Parse(args)
+
+	t, err := interactiveToken(*linked)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	if *printInfo {
+		report, err := t.Report()
+		if err != nil {
+			return err
+		}
+		fmt.Print(report)
+		return nil
+	}
+
+	return launch(t, fs.Args(), *wait)
+}
+
+// acquire gets the token described by as: system, user, pid=N or service=NAME
+func acquire(as string, linked bool) (*wintoken.Token, error) {
+	typ := wintoken.TokenPrimary
+	if linked {
+		typ = wintoken.TokenLinked
+	}
+
+	kind, value := as, ""
+	if i := strings.IndexByte(as, '='); i != -1 {
+		kind, value = as[:i], as[i+1:]
+	}
+
+	switch kind {
+	case "system":
+		return withDebugPrivilege(func() (*wintoken.Token, error) { return wintoken.GetSystemToken(typ) })
+	case "user":
+		return wintoken.GetInteractiveToken(typ)
+	case "pid":
+		pid, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pid %q", value)
+		}
+		return withDebugPrivilege(func() (*wintoken.Token, error) { return wintoken.OpenProcessToken(pid, typ) })
+	case "service":
+		if value == "" {
+			return nil, fmt.Errorf("service name is required")
+		}
+		return withDebugPrivilege(func() (*wintoken.Token, error) { return wintoken.GetServiceToken(value, typ) })
+	default:
+		return nil, fmt.Errorf("invalid -as %q, expected system, user, pid=N or service=NAME", as)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	as := fs.String("as", "", "token to run as: system, user, pid=N or service=NAME")
+	linked := fs.Bool("linked", false, "use the linked (elevated) token")
+	enable := fs.String("enable", "", `comma separated privileges to enable on the token, or "all"`)
+	wait := fs.Bool("wait", false, "wait for the launched program to exit")
+	fs.Parse(args)
+
+	if *as == "" {
+		return fmt.Errorf("run: -as is required")
+	}
+
+	t, err := acquire(*as, *linked)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	switch *enable {
+	case "":
+	case "all":
+		if err := t.EnableAllPrivileges(); err != nil {
+			fmt.Fprintln(os.Stderr, "warning:", err)
+		}
+	default:
+		var privs []wintoken.Privilege
+		for _, p := range strings.Split(*enable, ",") {
+			privs = append(privs, wintoken.Privilege(strings.TrimSpace(p)))
+		}
+		if _, err := t.EnableTokenPrivileges(privs...); err != nil {
+			fmt.Fprintln(os.Stderr, "warning:", err)
+		}
+	}
+
+	return launch(t, fs.Args(), *wait)
+}
+
+// launch starts the program in argv, or cmd.exe, in a new console with the token. Callers holding
+// SeAssignPrimaryTokenPrivilege, such as SYSTEM, use CreateProcessAsUser and others CreateProcessWithTokenW
+func launch(t *wintoken.Token, argv []string, wait bool) error {
+	if len(argv) == 0 {
+		argv = []string{`C:\Windows\System32\cmd.exe`}
+	}
+
+	fmt.Println("launching", argv, "with", t)
+
+	start := t.StartProcessWithToken
+	// CreateProcessAsUser needs SeAssignPrimaryTokenPrivilege, which elevated administrators do not hold
+	if ctx, err := wintoken.CurrentContext(); err == nil && ctx.HasPrivilege(wintoken.SeAssignPrimaryTokenPrivilege) {
+		start = t.StartProcess
+	}
+
+	p, err := start(argv[0], argv[1:], &wintoken.StartOptions{CreationFlags: windows.CREATE_NEW_CONSOLE})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("started process", p.Pid)
+	if !wait {
+		return p.Release()
+	}
+
+	code, err := p.Wait()
+	if err != nil {
+		return err
+	}
+	fmt.Println("process exited with code", code)
+	return nil
+}
@@ -0,0 +1,199 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modole32                = windows.NewLazySystemDLL("ole32.dll")
+	procCoImpersonateClient = modole32.NewProc("CoImpersonateClient")
+	procCoRevertToSelf      = modole32.NewProc("CoRevertToSelf")
+	procCoCreateInstance    = modole32.NewProc("CoCreateInstance")
+	procCoSetProxyBlanket   = modole32.NewProc("CoSetProxyBlanket")
+
+	modoleaut32        = windows.NewLazySystemDLL("oleaut32.dll")
+	procSysAllocString = modoleaut32.NewProc("SysAllocString")
+	procSysFreeString  = modoleaut32.NewProc("SysFreeString")
+	procVariantClear   = modoleaut32.NewProc("VariantClear")
+)
+
+const (
+	comSFalse          = 0x00000001
+	comRPCEChangedMode = 0x80010106
+)
+
+// comObject is a COM interface pointer, whose first field points to the method table
+type comObject struct {
+	vtbl *[64]uintptr
+}
+
+// call invokes the method at index of the method table, the interface pointer is passed as first argument
+func (c *comObject) call(method int, args ...uintptr) error {
+	if len(args) > 14 {
+		return fmt.Errorf("too many arguments for a COM method call: %d", len(args))
+	}
+	a := make([]uintptr, 15)
+	a[0] = uintptr(unsafe.Pointer(c))
+	n := copy(a[1:], args) + 1
+	r1, _, _ := syscall.Syscall15(c.vtbl[method], uintptr(n), a[0], a[1], a[2], a[3], a[4], a[5], a[6], a[7], a[8], a[9], a[10], a[11], a[12], a[13], a[14])
+	return hresultError(r1)
+}
+
+// Release calls IUnknown::Release, nil objects are ignored
+func (c *comObject) Release() {
+	if c != nil {
+		c.call(2)
+	}
+}
+
+// comInit initializes COM in the multithreaded apartment on the current OS thread, which must stay locked until
+// the returned function uninitializes it. A thread already initialized in another apartment is used as is
+func comInit() (func(), error) {
+	err := windows.CoInitializeEx(0, windows.COINIT_MULTITHREADED)
+	switch err {
+	case nil, syscall.Errno(comSFalse):
+		return windows.CoUninitialize, nil
+	case syscall.Errno(comRPCEChangedMode):
+		return func() {}, nil
+	default:
+		return nil, fmt.Errorf("error while CoInitializeEx: %w", err)
+	}
+}
+
+// coCreateInstance creates the in-process COM object clsid and returns its interface iid
+func coCreateInstance(clsid, iid string) (*comObject, error) {
+	cls, err := windows.GUIDFromString(clsid)
+	if err != nil {
+		return nil, err
+	}
+	id, err := windows.GUIDFromString(iid)
+	if err != nil {
+		return nil, err
+	}
+
+	var obj *comObject
+	r1, _, _ := procCoCreateInstance.Call(uintptr(unsafe.Pointer(&cls)), 0, windows.CLSCTX_INPROC_SERVER, uintptr(unsafe.Pointer(&id)), uintptr(unsafe.Pointer(&obj)))
+	if err := hresultError(r1); err != nil {
+		return nil, fmt.Errorf("error while CoCreateInstance %s: %w", clsid, err)
+	}
+	return obj, nil
+}
+
+// bstr is a BSTR string allocated with SysAllocString, it must be freed with free
+type bstr uintptr
+
+func newBSTR(s string) (bstr, error) {
+	p, err := windows.UTF16PtrFromString(s)
+	if err != nil {
+		return 0, err
+	}
+	r1, _, err := procSysAllocString.Call(uintptr(unsafe.Pointer(p)))
+	if r1 == 0 {
+		return 0, fmt.Errorf("error while SysAllocString: %w", err)
+	}
+	return bstr(r1), nil
+}
+
+func (b bstr) free() {
+	if b != 0 {
+		procSysFreeString.Call(uintptr(b))
+	}
+}
+
+const (
+	vtI4   = 3
+	vtBSTR = 8
+)
+
+// variant is the VARIANT structure, it is 16 bytes on 32-bit and 24 bytes on 64-bit Windows
+type variant struct {
+	vt       uint16
+	reserved [3]uint16
+	val      [2]uintptr
+}
+
+// clear releases what the variant holds with VariantClear
+func (v *variant) clear() {
+	procVariantClear.Call(uintptr(unsafe.Pointer(v)))
+}
+
+// variantArgs returns the call arguments of a VARIANT passed by value: 64-bit calling conventions pass it by
+// reference, 32-bit ones copy it on the stack
+func variantArgs(v *variant) []uintptr {
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		return []uintptr{uintptr(unsafe.Pointer(v))}
+	}
+	w := (*[4]uintptr)(unsafe.Pointer(v))
+	return w[:]
+}
+
+// withCOM runs fn on a locked OS thread initialized for COM
+func withCOM(fn func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	uninit, err := comInit()
+	if err != nil {
+		return err
+	}
+	defer uninit()
+	return fn()
+}
+
+// hresultError converts a failed HRESULT into an error, nil is returned for success codes
+func hresultError(hr uintptr) error {
+	if int32(hr) >= 0 {
+		return nil
+	}
+	return windows.Errno(hr)
+}
+
+// CoImpersonateClient impersonates the COM client of the call currently being serviced on this thread.
+// The calling goroutine is locked to its OS thread until CoRevertToSelf is called
+func CoImpersonateClient() error {
+	return guardEnter(coImpersonateClient)
+}
+
+func coImpersonateClient() error {
+	r1, _, _ := procCoImpersonateClient.Call()
+	if err := hresultError(r1); err != nil {
+		return fmt.Errorf("error while CoImpersonateClient: %w", err)
+	}
+	return nil
+}
+
+// CoRevertToSelf stops impersonating the COM client started with CoImpersonateClient and unlocks the goroutine from
+// its thread. It fails with ErrImpersonationThread when called from a thread CoImpersonateClient was not called on
+func CoRevertToSelf() error {
+	return guardExit("CoRevertToSelf", coRevertToSelf)
+}
+
+func coRevertToSelf() error {
+	r1, _, _ := procCoRevertToSelf.Call()
+	if err := hresultError(r1); err != nil {
+		return fmt.Errorf("error while CoRevertToSelf: %w", err)
+	}
+	return nil
+}
+
+// GetCOMClientToken captures the token of the COM client whose call is currently being serviced.
+// It must be called from the thread executing the COM method call
+func GetCOMClientToken(tokenType tokenType, opts ...Option) (*Token, error) {
+	return captureClientToken(coImpersonateClient, coRevertToSelf, tokenType, opts)
+}
+
+// RunAsCOMClient runs fn while impersonating the current COM client, see Token.RunAs
+func RunAsCOMClient(fn func() error) error {
+	t, err := GetCOMClientToken(TokenImpersonation)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	return t.RunAs(fn)
+}
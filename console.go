@@ -0,0 +1,70 @@
+package wintoken
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// noConsoleSession is returned by WTSGetActiveConsoleSessionId while the console is being attached or detached
+const noConsoleSession = 0xFFFFFFFF
+
+// ConsoleSessionID returns the ID of the session attached to the physical console
+func ConsoleSessionID() (uint32, error) {
+	return consoleSession(windows.WTSGetActiveConsoleSessionId())
+}
+
+// consoleSession maps the result of WTSGetActiveConsoleSessionId to a session ID or ErrNoConsoleSession
+func consoleSession(id uint32) (uint32, error) {
+	if id == noConsoleSession {
+		return 0, ErrNoConsoleSession
+	}
+	return id, nil
+}
+
+// RunInConsoleSession starts a GUI program on the interactive desktop of the user logged on to the console.
+//
+// It is meant to be called from the Execute method of a golang.org/x/sys/windows/svc.Handler
+// running as LocalSystem: services run in session 0 and cannot show windows to the user, so the
+// console session is located with WTSGetActiveConsoleSessionId, the user's primary token is queried
+// with WTSQueryUserToken, the user's own environment block is built from that token and exe is
+// launched on winsta0\default. opts may be nil, Env and Desktop are defaulted as in Token.StartProcess.
+// The returned Process must be released or waited on by the caller
+func RunInConsoleSession(exe string, args []string, opts *StartOptions) (*Process, error) {
+	sessionID, err := ConsoleSessionID()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := GetSessionToken(sessionID, TokenPrimary)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	return t.StartProcess(exe, args, opts)
+}
+
+// RunAsLoggedOnUser starts exe as the interactive user, it is the one-call version of RunInConsoleSession for SYSTEM services.
+// The user logged on to the console is preferred, otherwise the user of the first active session, such as a remote desktop session.
+// The program gets the user's environment, starts in the user's profile directory and is shown on winsta0\default.
+// The returned Process must be released or waited on by the caller
+func RunAsLoggedOnUser(exe string, args ...string) (*Process, error) {
+	var t *Token
+	if sessionID, err := ConsoleSessionID(); err == nil {
+		// nobody may be logged on to the console while a remote session is active
+		t, _ = GetSessionToken(sessionID, TokenPrimary)
+	}
+	if t == nil {
+		var err error
+		if t, err = GetInteractiveToken(TokenPrimary); err != nil {
+			return nil, err
+		}
+	}
+	defer t.Close()
+
+	opts := &StartOptions{}
+	if dir, err := t.token.GetUserProfileDirectory(); err == nil {
+		opts.Dir = dir
+	}
+
+	return t.StartProcess(exe, args, opts)
+}
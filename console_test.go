@@ -0,0 +1,84 @@
+package wintoken
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestConsoleSession(t *testing.T) {
+	tests := []struct {
+		id      uint32
+		want    uint32
+		wantErr error
+	}{
+		{id: 1, want: 1},
+		{id: 0, want: 0},
+		{id: noConsoleSession, wantErr: ErrNoConsoleSession},
+	}
+	for _, tt := range tests {
+		got, err := consoleSession(tt.id)
+		if err != tt.wantErr || got != tt.want {
+			t.Errorf("consoleSession(%#x) = %d, %v, want %d, %v", tt.id, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestInteractiveSessions(t *testing.T) {
+	sessions := []wtsSession{
+		{id: 0, station: "Services", state: windows.WTSDisconnected},
+		{id: 1, station: "Console", state: windows.WTSActive},
+		{id: 2, station: "RDP-Tcp#0", state: windows.WTSActive},
+		{id: 3, station: "", state: windows.WTSDisconnected},
+	}
+
+	tests := []struct {
+		name    string
+		console uint32
+		opts    []Option
+		want    []uint32
+	}{
+		{name: "active only", console: 1, want: []uint32{1, 2}},
+		{name: "prefer console", console: 1, opts: []Option{WithSessionPreference(PreferConsole)}, want: []uint32{1, 2}},
+		{name: "prefer remote", console: 1, opts: []Option{WithSessionPreference(PreferRemote)}, want: []uint32{2, 1}},
+		{name: "console detached", console: 0, opts: []Option{WithSessionPreference(PreferConsole)}, want: []uint32{1, 2}},
+		{
+			name:    "disconnected last",
+			console: 1,
+			opts:    []Option{WithSessionStates(windows.WTSDisconnected, windows.WTSActive)},
+			want:    []uint32{1, 2, 3},
+		},
+		{name: "no match", console: 1, opts: []Option{WithSessionStates(windows.WTSIdle)}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []uint32
+			for _, s := range interactiveSessions(sessions, tt.console, newOptions(tt.opts)) {
+				got = append(got, s.id)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("interactiveSessions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandLine(t *testing.T) {
+	tests := []struct {
+		exe  string
+		args []string
+		want string
+	}{
+		{exe: "notepad.exe", want: "notepad.exe"},
+		{exe: "cmd.exe", args: []string{"/c", "echo", "hi"}, want: "cmd.exe /c echo hi"},
+		{exe: `C:\Program Files\App\app.exe`, args: []string{"--flag"}, want: `"C:\Program Files\App\app.exe" --flag`},
+		{exe: "app.exe", args: []string{"two words", ""}, want: `app.exe "two words" ""`},
+		{exe: "app.exe", args: []string{`say "hi"`}, want: `app.exe "say \"hi\""`},
+	}
+	for _, tt := range tests {
+		if got := commandLine(tt.exe, tt.args); got != tt.want {
+			t.Errorf("commandLine(%q, %q) = %s, want %s", tt.exe, tt.args, got, tt.want)
+		}
+	}
+}
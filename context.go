@@ -0,0 +1,96 @@
+package wintoken
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+)
+
+// criticalPrivileges are the privileges which decide which token acquisition strategies are available
+var criticalPrivileges = []Privilege{
+	SeDebugPrivilege,
+	SeTcbPrivilege,
+	SeImpersonatePrivilege,
+	SeAssignPrimaryTokenPrivilege,
+	SeIncreaseQuotaPrivilege,
+	SeCreateTokenPrivilege,
+	SeBackupPrivilege,
+	SeRestorePrivilege,
+	SeTakeOwnershipPrivilege,
+	SeLoadDriverPrivilege,
+	SeSecurityPrivilege,
+}
+
+// ExecutionContext describes the security context the current process is running in
+type ExecutionContext struct {
+	IsSystem       bool
+	IsService      bool
+	IsElevated     bool
+	SessionID      uint32
+	IntegrityLevel string
+	// Privileges lists the critical privileges held by the process token, enabled or not
+	Privileges []PrivilegeDetail
+}
+
+// HasPrivilege reports whether the process token holds the named critical privilege, enabled or not
+func (c ExecutionContext) HasPrivilege(name Privilege) bool {
+	for _, p := range c.Privileges {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (c ExecutionContext) String() string {
+	return fmt.Sprintf("System: %t, Service: %t, Elevated: %t, Session: %d, Integrity: %s, Privileges: %v", c.IsSystem, c.IsService, c.IsElevated, c.SessionID, c.IntegrityLevel, c.Privileges)
+}
+
+// CurrentContext reports whether the current process runs as SYSTEM, as a service or elevated,
+// its session ID, integrity level and which critical privileges it holds, so callers can pick
+// between WTSQueryUserToken, process token theft and other acquisition strategies
+func CurrentContext() (ExecutionContext, error) {
+	var ctx ExecutionContext
+
+	t := CurrentProcessToken()
+	defer t.Close()
+
+	user, err := t.token.GetTokenUser()
+	if err != nil {
+		return ctx, fmt.Errorf("error while getting token user: %w", err)
+	}
+	ctx.IsSystem = user.User.Sid.IsWellKnown(windows.WinLocalSystemSid)
+
+	if ctx.IsService, err = svc.IsWindowsService(); err != nil {
+		return ctx, fmt.Errorf("error while detecting service: %w", err)
+	}
+
+	ctx.IsElevated = t.token.IsElevated()
+
+	if err := windows.ProcessIdToSessionId(windows.GetCurrentProcessId(), &ctx.SessionID); err != nil {
+		return ctx, fmt.Errorf("error while ProcessIdToSessionId: %w", err)
+	}
+
+	if ctx.IntegrityLevel, err = t.GetIntegrityLevel(); err != nil {
+		return ctx, err
+	}
+
+	privs, err := t.GetPrivileges()
+	if err != nil {
+		return ctx, err
+	}
+	for _, p := range privs {
+		if p.Removed {
+			continue
+		}
+		for _, c := range criticalPrivileges {
+			if p.Name == c {
+				ctx.Privileges = append(ctx.Privileges, p)
+				break
+			}
+		}
+	}
+
+	return ctx, nil
+}
@@ -0,0 +1,114 @@
+package wintoken
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ToPrimary duplicates the token into a new primary token, so tokens captured through pipe,
+// COM or RPC impersonation can be used with CreateProcessAsUser.
+// The source token must be at least at SecurityImpersonation level
+func (t *Token) ToPrimary() (*Token, error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	var dt windows.Token
+	if err := windows.DuplicateTokenEx(t.token, windows.MAXIMUM_ALLOWED, nil, windows.SecurityImpersonation, windows.TokenPrimary, &dt); err != nil {
+		err = fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		audit(AuditDuplicateToken, start, 0, 0, 0, err)
+		return nil, err
+	}
+	audit(AuditDuplicateToken, start, 0, 0, dt, nil)
+
+	return newToken(dt, TokenPrimary), nil
+}
+
+// ToImpersonation duplicates the token into a new impersonation token at the given level.
+// The level cannot be higher than the level of the source token if it is an impersonation token
+func (t *Token) ToImpersonation(level ImpersonationLevel) (*Token, error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	var dt windows.Token
+	if err := windows.DuplicateTokenEx(t.token, windows.MAXIMUM_ALLOWED, nil, uint32(level), windows.TokenImpersonation, &dt); err != nil {
+		err = fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		audit(AuditDuplicateToken, start, 0, 0, 0, err)
+		return nil, err
+	}
+	audit(AuditDuplicateToken, start, 0, 0, dt, nil)
+
+	return newToken(dt, TokenImpersonation), nil
+}
+
+// Clone duplicates the token into a new, independent token of the same Windows token type and impersonation level.
+// Closing or modifying the clone does not affect the original token and vice versa
+func (t *Token) Clone() (*Token, error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	windowsType, err := tokenTypeOf(t.token)
+	if err != nil {
+		return nil, err
+	}
+
+	level := uint32(windows.SecurityImpersonation)
+	if windowsType == windows.TokenImpersonation {
+		if level, err = tokenInfoUint32(t.token, windows.TokenImpersonationLevel); err != nil {
+			return nil, fmt.Errorf("error while getting impersonation level: %w", err)
+		}
+	}
+
+	var dt windows.Token
+	if err := windows.DuplicateTokenEx(t.token, windows.MAXIMUM_ALLOWED, nil, level, windowsType, &dt); err != nil {
+		err = fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		audit(AuditDuplicateToken, start, 0, 0, 0, err)
+		return nil, err
+	}
+	audit(AuditDuplicateToken, start, 0, 0, dt, nil)
+
+	return newToken(dt, t.typ), nil
+}
+
+// SysProcAttrToken returns a primary token for use in syscall.SysProcAttr.Token with os/exec.
+// Impersonation tokens are duplicated into a primary token, the duplicate is owned by the Token and must not be
+// closed by the caller. Modifying the Token, for example with EnableTokenPrivileges, SetIntegrityLevel or SetRaw,
+// closes the duplicate, so SysProcAttrToken must be called again after a change and before the process is started
+func (t *Token) SysProcAttrToken() (syscall.Token, error) {
+	if err := t.acquire(); err != nil {
+		return 0, err
+	}
+	defer t.release()
+
+	windowsType, err := tokenTypeOf(t.token)
+	if err != nil {
+		return 0, err
+	}
+	if windowsType == windows.TokenPrimary {
+		return syscall.Token(t.token), nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.primary == 0 {
+		if err := windows.DuplicateTokenEx(t.token, windows.MAXIMUM_ALLOWED, nil, windows.SecurityImpersonation, windows.TokenPrimary, &t.primary); err != nil {
+			return 0, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		}
+	}
+	return syscall.Token(t.primary), nil
+}
@@ -0,0 +1,188 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procNtCreateToken           = modntdll.NewProc("NtCreateToken")
+	procAllocateLocallyUniqueId = modadvapi32.NewProc("AllocateLocallyUniqueId")
+)
+
+// Well-known logon session LUIDs, for TokenSpec.AuthenticationID
+var (
+	SystemLogonID         = windows.LUID{LowPart: 0x3e7}
+	AnonymousLogonID      = windows.LUID{LowPart: 0x3e6}
+	LocalServiceLogonID   = windows.LUID{LowPart: 0x3e5}
+	NetworkServiceLogonID = windows.LUID{LowPart: 0x3e4}
+)
+
+// defaultTokenSource is the TOKEN_SOURCE name of tokens created without TokenSpec.Source
+const defaultTokenSource = "wintoken"
+
+// TokenSpec describes a token forged with CreateToken
+type TokenSpec struct {
+	User *windows.SID
+	// Groups are the group SIDs with their SE_GROUP_* attributes, including the mandatory label and the logon SID if wanted
+	Groups []windows.SIDAndAttributes
+	// Privileges are assigned enabled, DisabledPrivileges are assigned but disabled
+	Privileges         []Privilege
+	DisabledPrivileges []Privilege
+	// Owner and PrimaryGroup default to User
+	Owner        *windows.SID
+	PrimaryGroup *windows.SID
+	// DefaultDACL is applied to objects created with the token, nil leaves them without a default DACL
+	DefaultDACL *windows.ACL
+	// Source is the TOKEN_SOURCE name, at most 8 characters, it defaults to "wintoken"
+	Source string
+	// AuthenticationID is the logon session the token belongs to, it defaults to SystemLogonID.
+	// The logon session must exist
+	AuthenticationID windows.LUID
+	// Expiration is informational, Windows does not enforce it. Zero means the token never expires
+	Expiration time.Time
+}
+
+// tokenSource is the TOKEN_SOURCE structure
+type tokenSource struct {
+	name [8]byte
+	id   windows.LUID
+}
+
+// newTokenSource names a new token source with a fresh identifier
+func newTokenSource(name string) (*tokenSource, error) {
+	if len(name) > 8 {
+		return nil, fmt.Errorf("token source %q is longer than 8 characters", name)
+	}
+
+	var source tokenSource
+	copy(source.name[:], name)
+	if r1, _, err := procAllocateLocallyUniqueId.Call(uintptr(unsafe.Pointer(&source.id))); r1 == 0 {
+		return nil, fmt.Errorf("error while AllocateLocallyUniqueId: %w", err)
+	}
+	return &source, nil
+}
+
+// newTokenGroups builds a variable sized TOKEN_GROUPS structure
+func newTokenGroups(groups []windows.SIDAndAttributes) *windows.Tokengroups {
+	n := len(groups)
+	if n < 1 {
+		n = 1
+	}
+	size := unsafe.Offsetof(windows.Tokengroups{}.Groups) + uintptr(n)*unsafe.Sizeof(windows.SIDAndAttributes{})
+	b := make([]byte, size)
+	tg := (*windows.Tokengroups)(unsafe.Pointer(&b[0]))
+	tg.GroupCount = uint32(len(groups))
+	copy((*[1 << 16]windows.SIDAndAttributes)(unsafe.Pointer(&tg.Groups[0]))[:len(groups):len(groups)], groups)
+	return tg
+}
+
+// specPrivileges builds the TOKEN_PRIVILEGES of a TokenSpec
+func specPrivileges(spec TokenSpec) (*windows.Tokenprivileges, error) {
+	n := len(spec.Privileges) + len(spec.DisabledPrivileges)
+	if n == 0 {
+		tp := newTokenPrivileges(1)
+		tp.PrivilegeCount = 0
+		return tp, nil
+	}
+
+	tp := newTokenPrivileges(n)
+	privs := (*[1 << 16]windows.LUIDAndAttributes)(unsafe.Pointer(&tp.Privileges[0]))[:n:n]
+	for i, priv := range append(append([]Privilege{}, spec.Privileges...), spec.DisabledPrivileges...) {
+		luid, err := privilegeLUID(priv)
+		if err != nil {
+			return nil, fmt.Errorf("error while looking up %s: %w", priv, err)
+		}
+		privs[i].Luid = luid
+		if i < len(spec.Privileges) {
+			privs[i].Attributes = windows.SE_PRIVILEGE_ENABLED | windows.SE_PRIVILEGE_ENABLED_BY_DEFAULT
+		}
+	}
+	return tp, nil
+}
+
+// CreateToken forges a token from scratch with NtCreateToken: any user, groups, privileges, owner and source, without
+// a logon. It is meant for credential providers and test harnesses. The calling thread or process must have
+// SeCreateTokenPrivilege enabled, which only lsass.exe holds by default, so callers usually run while impersonating
+// a token duplicated from lsass.exe. Impersonation tokens are created at the level of WithImpersonationLevel
+func CreateToken(spec TokenSpec, tokenType tokenType, opts ...Option) (*Token, error) {
+	o := newOptions(opts)
+
+	var windowsType uint32
+	switch tokenType {
+	case TokenPrimary:
+		windowsType = windows.TokenPrimary
+	case TokenImpersonation:
+		windowsType = windows.TokenImpersonation
+	default:
+		return nil, ErrOnlyPrimaryImpersonationTokenAllowed
+	}
+
+	if spec.Owner == nil {
+		spec.Owner = spec.User
+	}
+	if spec.PrimaryGroup == nil {
+		spec.PrimaryGroup = spec.User
+	}
+	if spec.Source == "" {
+		spec.Source = defaultTokenSource
+	}
+	if spec.AuthenticationID == (windows.LUID{}) {
+		spec.AuthenticationID = SystemLogonID
+	}
+
+	privileges, err := specPrivileges(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := newTokenSource(spec.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	expiration := int64(0x7FFFFFFFFFFFFFFF)
+	if !spec.Expiration.IsZero() {
+		ft := windows.NsecToFiletime(spec.Expiration.UnixNano())
+		expiration = int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+	}
+
+	qos := windows.SECURITY_QUALITY_OF_SERVICE{ImpersonationLevel: uint32(o.level)}
+	qos.Length = uint32(unsafe.Sizeof(qos))
+	oa := windows.OBJECT_ATTRIBUTES{SecurityQoS: &qos}
+	oa.Length = uint32(unsafe.Sizeof(oa))
+
+	user := windows.Tokenuser{User: windows.SIDAndAttributes{Sid: spec.User}}
+	groups := newTokenGroups(spec.Groups)
+	owner := struct{ owner *windows.SID }{spec.Owner}
+	primaryGroup := windows.Tokenprimarygroup{PrimaryGroup: spec.PrimaryGroup}
+	dacl := struct{ dacl *windows.ACL }{spec.DefaultDACL}
+
+	var t windows.Token
+	r1, _, _ := procNtCreateToken.Call(
+		uintptr(unsafe.Pointer(&t)),
+		windows.TOKEN_ALL_ACCESS,
+		uintptr(unsafe.Pointer(&oa)),
+		uintptr(windowsType),
+		uintptr(unsafe.Pointer(&spec.AuthenticationID)),
+		uintptr(unsafe.Pointer(&expiration)),
+		uintptr(unsafe.Pointer(&user)),
+		uintptr(unsafe.Pointer(groups)),
+		uintptr(unsafe.Pointer(privileges)),
+		uintptr(unsafe.Pointer(&owner)),
+		uintptr(unsafe.Pointer(&primaryGroup)),
+		uintptr(unsafe.Pointer(&dacl)),
+		uintptr(unsafe.Pointer(source)),
+	)
+	// the SIDs referenced from the group buffer are not visible to the garbage collector
+	runtime.KeepAlive(spec.Groups)
+	if r1 != 0 {
+		return nil, fmt.Errorf("error while NtCreateToken: %w", windows.NTStatus(r1))
+	}
+
+	return newToken(t, tokenType).withOptions(o), nil
+}
@@ -1,4 +1,8 @@
 // wintoken abstracts away windows token manipulation functions with functions you are more likely to use.
 // The library exposes easy-to-use functions to steal tokens,
 // enable/disable privileges, and grab interactive and linked tokens.
+//
+// A Token is safe for concurrent use by multiple goroutines: one goroutine can query
+// token information while another one enables privileges, and Close waits for the
+// operations in flight before releasing the underlying handle.
 package wintoken
@@ -0,0 +1,98 @@
+package wintoken
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+const fileDeleteChild = 0x00000040
+
+// FileAccess is the access a token is granted on a file or directory, see Token.EffectiveAccess
+type FileAccess struct {
+	// Mask is the access mask granted by the security descriptor of the file
+	Mask uint32
+	// Read, Write and Execute are set when every right of FILE_GENERIC_READ, FILE_GENERIC_WRITE or FILE_GENERIC_EXECUTE,
+	// SYNCHRONIZE aside, is granted
+	Read    bool
+	Write   bool
+	Execute bool
+	// Delete is set if the file grants DELETE or its parent directory grants FILE_DELETE_CHILD
+	Delete bool
+}
+
+func (a FileAccess) String() string {
+	var rights []string
+	if a.Read {
+		rights = append(rights, "Read")
+	}
+	if a.Write {
+		rights = append(rights, "Write")
+	}
+	if a.Execute {
+		rights = append(rights, "Execute")
+	}
+	if a.Delete {
+		rights = append(rights, "Delete")
+	}
+	if len(rights) == 0 {
+		rights = append(rights, "None")
+	}
+	return fmt.Sprintf("%s (0x%08x)", strings.Join(rights, ", "), a.Mask)
+}
+
+// grants reports whether mask holds every right of want, SYNCHRONIZE aside
+func grants(mask, want uint32) bool {
+	want &^= windows.SYNCHRONIZE
+	return mask&want == want
+}
+
+// fileSecurity reads the owner, group, DACL and mandatory label of a file, which is all an access check needs
+func fileSecurity(path string) (*windows.SECURITY_DESCRIPTOR, error) {
+	sd, err := windows.GetNamedSecurityInfo(path, windows.SE_FILE_OBJECT, windows.OWNER_SECURITY_INFORMATION|windows.GROUP_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION|windows.LABEL_SECURITY_INFORMATION)
+	if err != nil {
+		return nil, fmt.Errorf("error while GetNamedSecurityInfo %s: %w", path, err)
+	}
+	return sd, nil
+}
+
+// EffectiveAccess computes the rights the token would be granted on the file or directory path with an Authz access check,
+// accounting for its groups, deny ACEs and integrity level without opening the file as the token. Privileges which bypass
+// the DACL, such as SeBackupPrivilege, are not taken into account. Reading the security descriptor requires READ_CONTROL
+// for the calling process, not for the token
+func (t *Token) EffectiveAccess(path string) (FileAccess, error) {
+	ctx, err := t.AuthzContext()
+	if err != nil {
+		return FileAccess{}, err
+	}
+	defer ctx.Close()
+
+	sd, err := fileSecurity(path)
+	if err != nil {
+		return FileAccess{}, err
+	}
+	mask, err := ctx.MaximumAccess(sd)
+	if err != nil {
+		return FileAccess{}, err
+	}
+
+	access := FileAccess{
+		Mask:    mask,
+		Read:    grants(mask, windows.FILE_GENERIC_READ),
+		Write:   grants(mask, windows.FILE_GENERIC_WRITE),
+		Execute: grants(mask, windows.FILE_GENERIC_EXECUTE),
+		Delete:  mask&windows.DELETE != 0,
+	}
+
+	if parent := filepath.Dir(path); !access.Delete && parent != path {
+		// a missing or unreadable parent only means FILE_DELETE_CHILD cannot be checked
+		if psd, err := fileSecurity(parent); err == nil {
+			if pmask, err := ctx.MaximumAccess(psd); err == nil {
+				access.Delete = pmask&fileDeleteChild != 0
+			}
+		}
+	}
+	return access, nil
+}
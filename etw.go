@@ -0,0 +1,124 @@
+package wintoken
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Microsoft/go-winio/pkg/etw"
+)
+
+// DefaultETWProviderName is the provider name used by EnableETW when no name is given.
+// The provider GUID is derived from the name the same way as .NET EventSource, so it can be enabled by name
+const DefaultETWProviderName = "FourCoreLabs-WinToken"
+
+var (
+	etwMu       sync.RWMutex
+	etwProvider *etw.Provider
+)
+
+// EnableETW registers an ETW provider and writes an event to it for every operation reported to the audit hook:
+// token steals and duplications, impersonations, privilege changes and launches. Events are only written while a trace session
+// has enabled the provider. Calling EnableETW again replaces the previous provider
+func EnableETW(providerName string) error {
+	if providerName == "" {
+		providerName = DefaultETWProviderName
+	}
+
+	p, err := etw.NewProvider(providerName, nil)
+	if err != nil {
+		return fmt.Errorf("error while registering ETW provider: %w", err)
+	}
+
+	etwMu.Lock()
+	old := etwProvider
+	etwProvider = p
+	etwMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// DisableETW unregisters the provider registered by EnableETW
+func DisableETW() error {
+	etwMu.Lock()
+	p := etwProvider
+	etwProvider = nil
+	etwMu.Unlock()
+
+	if p == nil {
+		return nil
+	}
+	if err := p.Close(); err != nil {
+		return fmt.Errorf("error while unregistering ETW provider: %w", err)
+	}
+	return nil
+}
+
+// etwEnabled reports whether a trace session is listening to the registered provider
+func etwEnabled() bool {
+	etwMu.RLock()
+	defer etwMu.RUnlock()
+
+	return etwProvider != nil && etwProvider.IsEnabled()
+}
+
+var etwEventNames = map[AuditOperation]string{
+	AuditStealToken:      "TokenSteal",
+	AuditSessionToken:    "SessionToken",
+	AuditDuplicateToken:  "TokenDuplicate",
+	AuditLaunch:          "ProcessLaunch",
+	AuditImpersonate:     "Impersonate",
+	AuditPrivilegeChange: "PrivilegeChange",
+	AuditLogon:           "Logon",
+}
+
+// writeETWEvent writes rec to the registered provider, failures are ignored
+func writeETWEvent(rec AuditRecord) {
+	etwMu.RLock()
+	defer etwMu.RUnlock()
+
+	if etwProvider == nil || !etwProvider.IsEnabled() {
+		return
+	}
+
+	name, ok := etwEventNames[rec.Operation]
+	if !ok {
+		name = string(rec.Operation)
+	}
+
+	level := etw.LevelInfo
+	var errMsg string
+	if rec.Err != nil {
+		level = etw.LevelError
+		errMsg = rec.Err.Error()
+	}
+
+	privs := make([]string, len(rec.Privileges))
+	for i, p := range rec.Privileges {
+		privs[i] = string(p)
+	}
+
+	var logon LogonRecord
+	if rec.Logon != nil {
+		logon = *rec.Logon
+	}
+
+	etwProvider.WriteEvent(name, etw.WithEventOpts(etw.WithLevel(level)), etw.WithFields(
+		etw.Uint32Field("PID", uint32(rec.PID)),
+		etw.Uint32Field("SessionID", rec.SessionID),
+		etw.StringField("UserSID", rec.UserSID),
+		etw.StringArray("Privileges", privs),
+		etw.StringField("Detail", rec.Detail),
+		etw.StringField("TargetUserName", logon.TargetUserName),
+		etw.StringField("TargetDomainName", logon.TargetDomainName),
+		etw.Uint32Field("LogonType", uint32(logon.LogonType)),
+		etw.StringField("AuthenticationPackageName", logon.AuthenticationPackage),
+		etw.Uint64Field("TargetLogonId", luidUint64(logon.LogonID)),
+		etw.StringField("LogonProcessName", logon.LogonProcess),
+		etw.BoolField("ElevatedToken", logon.ElevatedToken),
+		etw.StringField("Caller", rec.Caller),
+		etw.StringField("Error", errMsg),
+	))
+}
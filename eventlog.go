@@ -0,0 +1,162 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+// Event IDs written to the event log for each audited operation
+const (
+	EventIDStealToken      uint32 = 100
+	EventIDSessionToken    uint32 = 101
+	EventIDDuplicateToken  uint32 = 102
+	EventIDLaunch          uint32 = 103
+	EventIDImpersonate     uint32 = 104
+	EventIDPrivilegeChange uint32 = 105
+	EventIDLogon           uint32 = 106
+	EventIDOther           uint32 = 199
+)
+
+var eventLogIDs = map[AuditOperation]uint32{
+	AuditStealToken:      EventIDStealToken,
+	AuditSessionToken:    EventIDSessionToken,
+	AuditDuplicateToken:  EventIDDuplicateToken,
+	AuditLaunch:          EventIDLaunch,
+	AuditImpersonate:     EventIDImpersonate,
+	AuditPrivilegeChange: EventIDPrivilegeChange,
+	AuditLogon:           EventIDLogon,
+}
+
+var (
+	eventLogMu  sync.RWMutex
+	eventLogDst *eventlog.Log
+)
+
+// InstallEventLogSource registers source in the Application log using EventCreate.exe as message file,
+// so the events written by EnableEventLog are rendered by Event Viewer. It requires administrator rights
+// and only needs to be done once, typically by an installer
+func InstallEventLogSource(source string) error {
+	if err := eventlog.InstallAsEventCreate(source, eventlog.Error|eventlog.Warning|eventlog.Info); err != nil {
+		return fmt.Errorf("error while installing event log source %s: %w", source, err)
+	}
+	return nil
+}
+
+// RemoveEventLogSource deletes a source registered with InstallEventLogSource
+func RemoveEventLogSource(source string) error {
+	if err := eventlog.Remove(source); err != nil {
+		return fmt.Errorf("error while removing event log source %s: %w", source, err)
+	}
+	return nil
+}
+
+// EnableEventLog writes an Application log entry from source for every operation reported to the audit hook.
+// Failed operations are written as errors, successful ones as information. Calling EnableEventLog again replaces the previous source
+func EnableEventLog(source string) error {
+	l, err := eventlog.Open(source)
+	if err != nil {
+		return fmt.Errorf("error while opening event log source %s: %w", source, err)
+	}
+
+	eventLogMu.Lock()
+	old := eventLogDst
+	eventLogDst = l
+	eventLogMu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// DisableEventLog stops writing to the event log
+func DisableEventLog() error {
+	eventLogMu.Lock()
+	l := eventLogDst
+	eventLogDst = nil
+	eventLogMu.Unlock()
+
+	if l == nil {
+		return nil
+	}
+	if err := l.Close(); err != nil {
+		return fmt.Errorf("error while closing event log: %w", err)
+	}
+	return nil
+}
+
+func eventLogEnabled() bool {
+	eventLogMu.RLock()
+	defer eventLogMu.RUnlock()
+
+	return eventLogDst != nil
+}
+
+// eventLogMessage formats rec as the text of an event log entry
+func eventLogMessage(rec AuditRecord) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "wintoken %s", rec.Operation)
+	if rec.Detail != "" {
+		fmt.Fprintf(&sb, " (%s)", rec.Detail)
+	}
+	if rec.Err != nil {
+		sb.WriteString(" failed")
+	}
+	sb.WriteString("\r\n")
+
+	if rec.PID != 0 {
+		fmt.Fprintf(&sb, "PID: %d\r\n", rec.PID)
+	}
+	fmt.Fprintf(&sb, "Session: %d\r\n", rec.SessionID)
+	if rec.UserSID != "" {
+		fmt.Fprintf(&sb, "User: %s\r\n", rec.UserSID)
+	}
+	if len(rec.Privileges) != 0 {
+		privs := make([]string, len(rec.Privileges))
+		for i, p := range rec.Privileges {
+			privs[i] = string(p)
+		}
+		fmt.Fprintf(&sb, "Privileges: %s\r\n", strings.Join(privs, ", "))
+	}
+	if l := rec.Logon; l != nil {
+		fmt.Fprintf(&sb, "Account: %s\\%s\r\n", l.TargetDomainName, l.TargetUserName)
+		fmt.Fprintf(&sb, "Logon Type: %d\r\n", l.LogonType)
+		fmt.Fprintf(&sb, "Logon ID: 0x%X\r\n", luidUint64(l.LogonID))
+		fmt.Fprintf(&sb, "Logon Process: %s\r\n", l.LogonProcess)
+		fmt.Fprintf(&sb, "Authentication Package: %s\r\n", l.AuthenticationPackage)
+		fmt.Fprintf(&sb, "Elevated Token: %t\r\n", l.ElevatedToken)
+	}
+	if rec.Caller != "" {
+		fmt.Fprintf(&sb, "Caller: %s\r\n", rec.Caller)
+	}
+	if rec.Err != nil {
+		fmt.Fprintf(&sb, "Error: %s\r\n", rec.Err)
+	}
+
+	return sb.String()
+}
+
+// writeEventLog writes rec to the event log source opened by EnableEventLog, failures are ignored
+func writeEventLog(rec AuditRecord) {
+	eventLogMu.RLock()
+	defer eventLogMu.RUnlock()
+
+	if eventLogDst == nil {
+		return
+	}
+
+	id, ok := eventLogIDs[rec.Operation]
+	if !ok {
+		id = EventIDOther
+	}
+
+	if rec.Err != nil {
+		eventLogDst.Error(id, eventLogMessage(rec))
+	} else {
+		eventLogDst.Info(id, eventLogMessage(rec))
+	}
+}
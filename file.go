@@ -0,0 +1,37 @@
+package wintoken
+
+import (
+	"os"
+)
+
+// OpenFile opens a file like os.OpenFile while impersonating the token, so the access check of CreateFile is done
+// against the token's user and groups. Services can read and write user-owned files with exactly the user's own
+// permissions. The returned file stays usable after the impersonation is reverted
+func (t *Token) OpenFile(path string, flag int, perm os.FileMode) (*os.File, error) {
+	var f *os.File
+	err := t.RunAs(func() error {
+		var err error
+		f, err = os.OpenFile(path, flag, perm)
+		return err
+	})
+	return f, err
+}
+
+// ReadFile reads a whole file while impersonating the token, see OpenFile
+func (t *Token) ReadFile(path string) ([]byte, error) {
+	var data []byte
+	err := t.RunAs(func() error {
+		var err error
+		data, err = os.ReadFile(path)
+		return err
+	})
+	return data, err
+}
+
+// WriteFile writes data to a file while impersonating the token, creating it with perm if needed, see OpenFile.
+// A file created this way is owned by the token's user
+func (t *Token) WriteFile(path string, data []byte, perm os.FileMode) error {
+	return t.RunAs(func() error {
+		return os.WriteFile(path, data, perm)
+	})
+}
@@ -0,0 +1,114 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+func (t tokenType) String() string {
+	switch t {
+	case TokenPrimary:
+		return "Primary"
+	case TokenImpersonation:
+		return "Impersonation"
+	case TokenLinked:
+		return "Linked"
+	case TokenIdentification:
+		return "Identification"
+	default:
+		return "Unknown"
+	}
+}
+
+// accountName resolves a SID to DOMAIN\user, falling back to the SID string
+func accountName(sid *windows.SID) string {
+	account, err := LookupAccountSID(sid)
+	if err != nil {
+		return sid.String()
+	}
+	return account.String()
+}
+
+// String returns a one-line summary of the token: user, type, integrity level, elevation and session
+func (t *Token) String() string {
+	if err := t.acquire(); err != nil {
+		return "Token(closed)"
+	}
+	defer t.release()
+
+	user := "?"
+	if tu, err := t.token.GetTokenUser(); err == nil {
+		user = accountName(tu.User.Sid)
+	}
+
+	integrity, err := t.GetIntegrityLevel()
+	if err != nil {
+		integrity = "?"
+	}
+
+	session := "?"
+	if id, err := tokenInfoUint32(t.token, windows.TokenSessionId); err == nil {
+		session = fmt.Sprint(id)
+	}
+
+	return fmt.Sprintf("Token(user: %s, type: %s, integrity: %s, elevated: %t, session: %s)", user, t.typ, integrity, t.token.IsElevated(), session)
+}
+
+// Dump returns a verbose multi-line description of the token for debugging: its user, type,
+// impersonation level, integrity level, elevation, session, groups and privileges
+func (t *Token) Dump() string {
+	if err := t.acquire(); err != nil {
+		return "Token(closed)\n"
+	}
+	defer t.release()
+
+	var sb strings.Builder
+	line := func(format string, a ...interface{}) {
+		fmt.Fprintf(&sb, format+"\n", a...)
+	}
+
+	if tu, err := t.token.GetTokenUser(); err == nil {
+		line("User:        %s (%s)", accountName(tu.User.Sid), tu.User.Sid)
+	} else {
+		line("User:        error: %v", err)
+	}
+
+	line("Type:        %s", t.typ)
+	if windowsType, err := tokenTypeOf(t.token); err == nil && windowsType == windows.TokenImpersonation {
+		if level, err := tokenInfoUint32(t.token, windows.TokenImpersonationLevel); err == nil {
+			line("Level:       %s", ImpersonationLevel(level))
+		}
+	}
+
+	if integrity, err := t.GetIntegrityLevel(); err == nil {
+		line("Integrity:   %s", integrity)
+	} else {
+		line("Integrity:   error: %v", err)
+	}
+	line("Elevated:    %t", t.token.IsElevated())
+	if id, err := tokenInfoUint32(t.token, windows.TokenSessionId); err == nil {
+		line("Session:     %d", id)
+	}
+
+	if groups, err := t.token.GetTokenGroups(); err == nil {
+		line("Groups:")
+		for _, g := range groups.AllGroups() {
+			line("  %s (%s) attributes: 0x%08x", accountName(g.Sid), g.Sid, g.Attributes)
+		}
+	} else {
+		line("Groups:      error: %v", err)
+	}
+
+	if privs, err := t.GetPrivileges(); err == nil {
+		line("Privileges:")
+		for _, p := range privs {
+			line("  %s", p)
+		}
+	} else {
+		line("Privileges:  error: %v", err)
+	}
+
+	return sb.String()
+}
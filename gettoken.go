@@ -1,7 +1,10 @@
 package wintoken
 
 import (
+	"errors"
 	"fmt"
+	"sort"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -11,126 +14,279 @@ const (
 	WTS_CURRENT_SERVER_HANDLE windows.Handle = 0
 )
 
-//OpenProcessToken opens a process token using PID, pass 0 as PID for self token
-func OpenProcessToken(pid int, tokenType tokenType) (*Token, error) {
-	var (
-		t               windows.Token
-		duplicatedToken windows.Token
-		procHandle      windows.Handle
-		err             error
-	)
-
-	if pid == 0 {
-		procHandle = windows.CurrentProcess()
-	} else {
-		procHandle, err = windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION, false, uint32(pid))
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	if err = windows.OpenProcessToken(procHandle, windows.TOKEN_ALL_ACCESS, &t); err != nil {
-		return nil, err
-	}
-
-	defer windows.CloseHandle(windows.Handle(t))
+// duplicateToken duplicates t into a new token of the requested wintoken type.
+// The caller owns the returned handle, t is left untouched
+func duplicateToken(t windows.Token, tokenType tokenType, o options) (windows.Token, error) {
+	var duplicatedToken windows.Token
 
 	switch tokenType {
 	case TokenPrimary:
-		if err := windows.DuplicateTokenEx(t, windows.MAXIMUM_ALLOWED, nil, windows.SecurityDelegation, windows.TokenPrimary, &duplicatedToken); err != nil {
-			return nil, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		if err := windows.DuplicateTokenEx(t, windows.MAXIMUM_ALLOWED, nil, uint32(o.level), windows.TokenPrimary, &duplicatedToken); err != nil {
+			return 0, fmt.Errorf("error while DuplicateTokenEx: %w", err)
 		}
 	case TokenImpersonation:
-		if err := windows.DuplicateTokenEx(t, windows.MAXIMUM_ALLOWED, nil, windows.SecurityImpersonation, windows.TokenImpersonation, &duplicatedToken); err != nil {
-			return nil, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		if err := windows.DuplicateTokenEx(t, windows.MAXIMUM_ALLOWED, nil, uint32(o.level), windows.TokenImpersonation, &duplicatedToken); err != nil {
+			return 0, fmt.Errorf("error while DuplicateTokenEx: %w", err)
 		}
-
 	case TokenLinked:
-		if err := windows.DuplicateTokenEx(t, windows.MAXIMUM_ALLOWED, nil, windows.SecurityDelegation, windows.TokenPrimary, &duplicatedToken); err != nil {
-			return nil, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		if err := windows.DuplicateTokenEx(t, windows.MAXIMUM_ALLOWED, nil, uint32(o.level), windows.TokenPrimary, &duplicatedToken); err != nil {
+			return 0, fmt.Errorf("error while DuplicateTokenEx: %w", err)
 		}
 		dt, err := duplicatedToken.GetLinkedToken()
 		windows.CloseHandle(windows.Handle(duplicatedToken))
 		if err != nil {
-			return nil, fmt.Errorf("error while getting LinkedToken: %w", err)
+			return 0, fmt.Errorf("error while getting LinkedToken: %w", err)
 		}
 		duplicatedToken = dt
+	case TokenIdentification:
+		if err := windows.DuplicateTokenEx(t, windows.TOKEN_QUERY|windows.TOKEN_QUERY_SOURCE, nil, windows.SecurityIdentification, windows.TokenImpersonation, &duplicatedToken); err != nil {
+			return 0, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		}
+	default:
+		return 0, ErrOnlyPrimaryImpersonationTokenAllowed
 	}
 
-	return &Token{token: duplicatedToken, typ: tokenType}, nil
+	return duplicatedToken, nil
 }
 
-//GetInteractiveToken gets the interactive token associated with current logged in user
-//It uses windows API WTSEnumerateSessions, WTSQueryUserToken and DuplicateTokenEx to return a valid wintoken
-func GetInteractiveToken(tokenType tokenType) (*Token, error) {
+// openProcessForToken opens a process with the right needed by OpenProcessToken. PROCESS_QUERY_INFORMATION is
+// tried first, then PROCESS_QUERY_LIMITED_INFORMATION which is also granted on many system processes to non-admin callers
+func openProcessForToken(pid int) (windows.Handle, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		h, err = windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	}
+	return h, err
+}
 
-	switch tokenType {
-	case TokenPrimary, TokenImpersonation, TokenLinked:
-	default:
-		return nil, ErrOnlyPrimaryImpersonationTokenAllowed
+// openProcessToken opens the token of a process by PID with the given access, pass 0 as PID for self token.
+// Access denied errors caused by the process being a PP or PPL are reported as ErrProtectedProcess
+func openProcessToken(pid int, access uint32) (windows.Token, error) {
+	var t windows.Token
+
+	procHandle := windows.CurrentProcess()
+	if pid != 0 {
+		h, err := openProcessForToken(pid)
+		if err != nil {
+			return 0, protectedProcessError(pid, err)
+		}
+		defer windows.CloseHandle(h)
+		procHandle = h
 	}
 
-	var (
-		sessionPointer   uintptr
-		sessionCount     uint32
-		interactiveToken windows.Token
-		duplicatedToken  windows.Token
-		sessionID        uint32
-	)
+	if err := windows.OpenProcessToken(procHandle, access, &t); err != nil {
+		return 0, protectedProcessError(pid, err)
+	}
+	return t, nil
+}
 
-	err := windows.WTSEnumerateSessions(WTS_CURRENT_SERVER_HANDLE, 0, 1, (**windows.WTS_SESSION_INFO)(unsafe.Pointer(&sessionPointer)), &sessionCount)
+// tokenAccessFallbacks are the smaller access masks tried in order when the requested access to a process token is denied
+var tokenAccessFallbacks = []uint32{windows.TOKEN_DUPLICATE | windows.TOKEN_QUERY, windows.TOKEN_QUERY}
+
+// openProcessTokenFallback opens the token of a process with access, retrying with the smaller masks
+// of tokenAccessFallbacks on ACCESS_DENIED. It returns the access which was obtained
+func openProcessTokenFallback(pid int, access uint32) (windows.Token, uint32, error) {
+	t, err := openProcessToken(pid, access)
+	for _, fallback := range tokenAccessFallbacks {
+		if err == nil || !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+			break
+		}
+		if fallback == access || fallback&access != fallback {
+			continue
+		}
+		t, err = openProcessToken(pid, fallback)
+		access = fallback
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error while enumerating sessions: %v", err)
+		return 0, 0, err
 	}
-	defer windows.WTSFreeMemory(sessionPointer)
+	return t, access, nil
+}
 
-	sessions := make([]*windows.WTS_SESSION_INFO, sessionCount)
-	size := unsafe.Sizeof(windows.WTS_SESSION_INFO{})
+//OpenProcessToken opens a process token using PID, pass 0 as PID for self token
+//TokenIdentification only requests query access to the process token.
+//If the requested access is denied, TOKEN_DUPLICATE|TOKEN_QUERY and then TOKEN_QUERY are tried, see Token.ObtainedAccess.
+//Without TOKEN_DUPLICATE the token cannot be duplicated, the returned Token wraps the process token itself and only supports queries.
+//The requested tokenType is then ignored: the Token is a TokenPrimary, or a TokenImpersonation for the linked token of TokenLinked
+func OpenProcessToken(pid int, tokenType tokenType, opts ...Option) (*Token, error) {
+	start := time.Now()
 
-	for i := range sessions {
-		sessions[i] = (*windows.WTS_SESSION_INFO)(unsafe.Pointer(sessionPointer + (size * uintptr(i))))
+	access := uint32(windows.TOKEN_ALL_ACCESS)
+	if tokenType == TokenIdentification {
+		access = windows.TOKEN_QUERY | windows.TOKEN_DUPLICATE
 	}
 
-	for i := range sessions {
-		if sessions[i].State == windows.WTSActive {
-			sessionID = sessions[i].SessionID
-			break
+	t, access, err := openProcessTokenFallback(pid, access)
+	if err != nil {
+		audit(AuditStealToken, start, pid, 0, 0, err)
+		return nil, err
+	}
+
+	o := newOptions(opts)
+	if access&windows.TOKEN_DUPLICATE == 0 {
+		if tokenType == TokenLinked {
+			lt, err := t.GetLinkedToken()
+			windows.CloseHandle(windows.Handle(t))
+			if err != nil {
+				err = fmt.Errorf("error while getting LinkedToken: %w", err)
+				audit(AuditStealToken, start, pid, 0, 0, err)
+				return nil, err
+			}
+			t = lt
 		}
+		audit(AuditStealToken, start, pid, 0, t, nil)
+
+		// the handle is not converted to the requested type, report the type it actually has
+		actualType := TokenPrimary
+		if windowsType, err := tokenTypeOf(t); err == nil && windowsType == windows.TokenImpersonation {
+			actualType = TokenImpersonation
+		}
+		token := newToken(t, actualType).withOptions(o)
+		token.access = access
+		return token, nil
 	}
-	if sessionID == 0 {
-		return nil, ErrNoActiveSession
+
+	defer windows.CloseHandle(windows.Handle(t))
+
+	duplicatedToken, err := duplicateToken(t, tokenType, o)
+	audit(AuditStealToken, start, pid, 0, duplicatedToken, err)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := windows.WTSQueryUserToken(sessionID, &interactiveToken); err != nil {
-		return nil, fmt.Errorf("error while WTSQueryUserToken: %w", err)
+	token := newToken(duplicatedToken, tokenType).withOptions(o)
+	token.access = access
+	return token, nil
+}
+
+// wtsSession is a copy of the fields of a WTS_SESSION_INFO entry which outlive WTSFreeMemory
+type wtsSession struct {
+	id      uint32
+	station string
+	state   uint32
+}
+
+// enumerateSessions lists the sessions on the local server using WTSEnumerateSessions
+func enumerateSessions() ([]wtsSession, error) {
+	var (
+		sessionInfo  *windows.WTS_SESSION_INFO
+		sessionCount uint32
+	)
+
+	if err := windows.WTSEnumerateSessions(WTS_CURRENT_SERVER_HANDLE, 0, 1, &sessionInfo, &sessionCount); err != nil {
+		return nil, fmt.Errorf("error while enumerating sessions: %w", err)
 	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(sessionInfo)))
 
-	defer windows.CloseHandle(windows.Handle(interactiveToken))
+	infos := (*[1 << 20]windows.WTS_SESSION_INFO)(unsafe.Pointer(sessionInfo))[:sessionCount:sessionCount]
+
+	sessions := make([]wtsSession, len(infos))
+	for i, info := range infos {
+		sessions[i] = wtsSession{
+			id:      info.SessionID,
+			station: windows.UTF16PtrToString(info.WindowStationName),
+			state:   info.State,
+		}
+	}
+
+	return sessions, nil
+}
+
+//GetInteractiveToken gets the interactive token associated with current logged in user
+//It uses windows API WTSEnumerateSessions, WTSQueryUserToken and DuplicateTokenEx to return a valid wintoken.
+//Only active sessions are considered unless WithSessionStates is used, WithSessionPreference chooses between
+//the console and remote sessions. Sessions without a logged on user are skipped
+func GetInteractiveToken(tokenType tokenType, opts ...Option) (*Token, error) {
 
 	switch tokenType {
-	case TokenPrimary:
-		if err := windows.DuplicateTokenEx(interactiveToken, windows.MAXIMUM_ALLOWED, nil, windows.SecurityDelegation, windows.TokenPrimary, &duplicatedToken); err != nil {
-			return nil, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+	case TokenPrimary, TokenImpersonation, TokenLinked, TokenIdentification:
+	default:
+		return nil, ErrOnlyPrimaryImpersonationTokenAllowed
+	}
+
+	sessions, err := enumerateSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	console, _ := ConsoleSessionID()
+	candidates := interactiveSessions(sessions, console, newOptions(opts))
+	if len(candidates) == 0 {
+		return nil, ErrNoActiveSession
+	}
+
+	for i, s := range candidates {
+		t, err := GetSessionToken(s.id, tokenType, opts...)
+		if err == nil || i == len(candidates)-1 {
+			return t, err
 		}
-	case TokenImpersonation:
-		if err := windows.DuplicateTokenEx(interactiveToken, windows.MAXIMUM_ALLOWED, nil, windows.SecurityImpersonation, windows.TokenImpersonation, &duplicatedToken); err != nil {
-			return nil, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+	}
+	return nil, ErrNoActiveSession
+}
+
+// interactiveSessions filters the user sessions in one of the requested states and orders them by preference,
+// console is the ID of the session attached to the physical console
+func interactiveSessions(sessions []wtsSession, console uint32, o options) []wtsSession {
+	rank := func(s wtsSession) int {
+		r := 0
+		if s.state != windows.WTSActive {
+			r += 2
 		}
-	case TokenLinked:
-		if err := windows.DuplicateTokenEx(interactiveToken, windows.MAXIMUM_ALLOWED, nil, windows.SecurityDelegation, windows.TokenPrimary, &duplicatedToken); err != nil {
-			return nil, fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		isConsole := s.id == console
+		if (o.sessionPreference == PreferConsole && !isConsole) || (o.sessionPreference == PreferRemote && isConsole) {
+			r++
 		}
-		dt, err := duplicatedToken.GetLinkedToken()
-		windows.CloseHandle(windows.Handle(duplicatedToken))
-		if err != nil {
-			return nil, fmt.Errorf("error while getting LinkedToken: %w", err)
+		return r
+	}
+
+	var candidates []wtsSession
+	for _, s := range sessions {
+		if s.id == 0 {
+			continue
 		}
-		duplicatedToken = dt
+		for _, state := range o.sessionStates {
+			if s.state == state {
+				candidates = append(candidates, s)
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return rank(candidates[i]) < rank(candidates[j])
+	})
+	return candidates
+}
+
+// GetSessionToken gets the token of the user logged on to the given session using WTSQueryUserToken.
+// The caller must be running as LocalSystem with SeTcbPrivilege, typically as a service
+func GetSessionToken(sessionID uint32, tokenType tokenType, opts ...Option) (*Token, error) {
+	start := time.Now()
+
+	var (
+		sessionToken    windows.Token
+		duplicatedToken windows.Token
+		err             error
+	)
+
+	if err := windows.WTSQueryUserToken(sessionID, &sessionToken); err != nil {
+		err = fmt.Errorf("error while WTSQueryUserToken: %w", err)
+		audit(AuditSessionToken, start, 0, sessionID, 0, err)
+		return nil, err
+	}
+
+	defer windows.CloseHandle(windows.Handle(sessionToken))
+
+	o := newOptions(opts)
+	duplicatedToken, err = duplicateToken(sessionToken, tokenType, o)
+	audit(AuditSessionToken, start, 0, sessionID, duplicatedToken, err)
+	if err != nil {
+		return nil, err
 	}
 
 	if windows.Handle(duplicatedToken) == windows.InvalidHandle {
 		return nil, ErrInvalidDuplicatedToken
 	}
 
-	return &Token{typ: tokenType, token: duplicatedToken}, nil
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
 }
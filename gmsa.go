@@ -0,0 +1,29 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ManagedServiceAccountLogon gets a token for a group managed service account (gMSA) with an S4U logon, so no
+// password is handled: account is DOMAIN\name or name@domain, the trailing $ of the SAM account name may be
+// omitted. The machine must be allowed to retrieve the gMSA password and, as for S4ULogon, a token usable for
+// impersonation or launching processes requires SeTcbPrivilege
+func ManagedServiceAccountLogon(account string, tokenType tokenType, opts ...Option) (*Token, error) {
+	var name, domain string
+	if i := strings.IndexByte(account, '\\'); i >= 0 {
+		domain, name = account[:i], account[i+1:]
+	} else if i := strings.LastIndexByte(account, '@'); i >= 0 {
+		name, domain = account[:i], account[i+1:]
+	} else {
+		return nil, fmt.Errorf("managed service account %q is not in the DOMAIN\\name or name@domain form", account)
+	}
+	if isLocalDomain(domain) {
+		return nil, fmt.Errorf("managed service account %q is not a domain account", account)
+	}
+	if !strings.HasSuffix(name, "$") {
+		name += "$"
+	}
+
+	return S4ULogon(name, domain, nil, tokenType, opts...)
+}
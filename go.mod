@@ -2,4 +2,7 @@ module github.com/fourcorelabs/wintoken
 
 go 1.16
 
-require golang.org/x/sys v0.0.0-20220728004956-3c1f35247d10 // indirect
+require (
+	github.com/Microsoft/go-winio v0.5.2
+	golang.org/x/sys v0.0.0-20220728004956-3c1f35247d10
+)
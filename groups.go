@@ -0,0 +1,145 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// GroupDetail is a group SID of a token with its SE_GROUP_* attributes decoded
+type GroupDetail struct {
+	SID string
+	// Name is the account name of the group in DOMAIN\name form, or the SID if it cannot be resolved
+	Name             string
+	Mandatory        bool
+	EnabledByDefault bool
+	Enabled          bool
+	Owner            bool
+	DenyOnly         bool
+	// Integrity is set on the mandatory label of the token, IntegrityEnabled if the label is enforced
+	Integrity        bool
+	IntegrityEnabled bool
+	// LogonID is set on the logon SID of the logon session, S-1-5-5-X-Y
+	LogonID bool
+	// Resource is set on domain-local groups
+	Resource bool
+}
+
+func (g GroupDetail) String() string {
+	var attrs []string
+	if g.Mandatory {
+		attrs = append(attrs, "Mandatory")
+	}
+	if g.EnabledByDefault {
+		attrs = append(attrs, "EnabledByDefault")
+	}
+	if g.Enabled {
+		attrs = append(attrs, "Enabled")
+	}
+	if g.Owner {
+		attrs = append(attrs, "Owner")
+	}
+	if g.DenyOnly {
+		attrs = append(attrs, "DenyOnly")
+	}
+	if g.Integrity {
+		attrs = append(attrs, "Integrity")
+	}
+	if g.IntegrityEnabled {
+		attrs = append(attrs, "IntegrityEnabled")
+	}
+	if g.LogonID {
+		attrs = append(attrs, "LogonID")
+	}
+	if g.Resource {
+		attrs = append(attrs, "Resource")
+	}
+	return fmt.Sprintf("%s (%s): %s", g.Name, g.SID, strings.Join(attrs, ", "))
+}
+
+// decodeGroup converts a SID_AND_ATTRIBUTES entry of a token group list
+func decodeGroup(sa windows.SIDAndAttributes) GroupDetail {
+	a := sa.Attributes
+	return GroupDetail{
+		SID:              sa.Sid.String(),
+		Name:             accountName(sa.Sid),
+		Mandatory:        a&windows.SE_GROUP_MANDATORY != 0,
+		EnabledByDefault: a&windows.SE_GROUP_ENABLED_BY_DEFAULT != 0,
+		Enabled:          a&windows.SE_GROUP_ENABLED != 0,
+		Owner:            a&windows.SE_GROUP_OWNER != 0,
+		DenyOnly:         a&windows.SE_GROUP_USE_FOR_DENY_ONLY != 0,
+		Integrity:        a&windows.SE_GROUP_INTEGRITY != 0,
+		IntegrityEnabled: a&windows.SE_GROUP_INTEGRITY_ENABLED != 0,
+		LogonID:          a&windows.SE_GROUP_LOGON_ID == windows.SE_GROUP_LOGON_ID,
+		Resource:         a&windows.SE_GROUP_RESOURCE != 0,
+	}
+}
+
+// decodeGroups converts every entry of a token group list
+func decodeGroups(groups *windows.Tokengroups) []GroupDetail {
+	all := groups.AllGroups()
+	details := make([]GroupDetail, len(all))
+	for i, g := range all {
+		details[i] = decodeGroup(g)
+	}
+	return details
+}
+
+// GetGroups lists the groups of the token with their attributes decoded
+func (t *Token) GetGroups() ([]GroupDetail, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	groups, err := t.groupsInfo(windows.TokenGroups)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting token groups: %w", err)
+	}
+	return groups, nil
+}
+
+// groupsInfo queries a token information class returning a TOKEN_GROUPS structure
+func (t *Token) groupsInfo(class uint32) ([]GroupDetail, error) {
+	b, err := t.info(class)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < int(unsafe.Sizeof(uint32(0))) {
+		return nil, nil
+	}
+	return decodeGroups((*windows.Tokengroups)(unsafe.Pointer(&b[0]))), nil
+}
+
+// GetRestrictingSIDs lists the restricting SIDs of the token, see CreateRestrictedToken.
+// Access checks against a restricted token must pass both for its groups and for these SIDs,
+// the list is empty for tokens which are not restricted
+func (t *Token) GetRestrictingSIDs() ([]GroupDetail, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	groups, err := t.groupsInfo(windows.TokenRestrictedSids)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting restricting SIDs: %w", err)
+	}
+	return groups, nil
+}
+
+// GetDeviceGroups lists the groups of the computer account carried by the token (TokenDeviceGroups),
+// they are only present with compound identity on domain-joined machines, see DeviceClaims
+func (t *Token) GetDeviceGroups() ([]GroupDetail, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	groups, err := t.groupsInfo(tokenDeviceGroups)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting device groups: %w", err)
+	}
+	return groups, nil
+}
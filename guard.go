@@ -0,0 +1,84 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+)
+
+// Impersonation applies to an OS thread while goroutines migrate between threads, so an impersonation started on
+// an unlocked goroutine silently stops applying, or leaks to other goroutines, once the scheduler moves it. The
+// guard locks the goroutine to its thread for the exported impersonate and revert pairs which cannot take a
+// callback, such as CoImpersonateClient and CoRevertToSelf, and checks that the revert runs on the thread which
+// started the impersonation.
+
+var (
+	guardMu sync.Mutex
+	// guardedThreads counts the impersonations started through the guard per OS thread
+	guardedThreads = make(map[uint32]int)
+
+	strictGuard int32
+)
+
+// SetStrictImpersonationGuard makes impersonation misuse panic rather than return an error wrapping
+// ErrImpersonationThread, so tests fail at the faulty call. It also covers Token.RunAs callbacks which unlock the
+// thread they were given and ImpersonationContext.Exit calls from another goroutine
+func SetStrictImpersonationGuard(strict bool) {
+	v := int32(0)
+	if strict {
+		v = 1
+	}
+	atomic.StoreInt32(&strictGuard, v)
+}
+
+// guardViolation reports a revert attempted from the wrong thread, panicking with the strict guard
+func guardViolation(function string) error {
+	err := fmt.Errorf("%s called on thread %d: %w", function, windows.GetCurrentThreadId(), ErrImpersonationThread)
+	if atomic.LoadInt32(&strictGuard) != 0 {
+		panic(err)
+	}
+	return err
+}
+
+// guardEnter locks the goroutine to its thread before impersonate runs on it, the lock is kept if impersonate succeeds
+func guardEnter(impersonate func() error) error {
+	runtime.LockOSThread()
+	if err := impersonate(); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	guardMu.Lock()
+	guardedThreads[windows.GetCurrentThreadId()]++
+	guardMu.Unlock()
+	return nil
+}
+
+// guardExit runs revert if the current thread started an impersonation through guardEnter and unlocks the goroutine
+// once it succeeded. Otherwise the goroutine migrated or never impersonated, revert is not run
+func guardExit(function string, revert func() error) error {
+	tid := windows.GetCurrentThreadId()
+
+	guardMu.Lock()
+	n := guardedThreads[tid]
+	guardMu.Unlock()
+	if n == 0 {
+		return guardViolation(function)
+	}
+
+	if err := revert(); err != nil {
+		// the thread stays locked so it is not handed to other goroutines while impersonating
+		return err
+	}
+
+	guardMu.Lock()
+	if guardedThreads[tid]--; guardedThreads[tid] == 0 {
+		delete(guardedThreads, tid)
+	}
+	guardMu.Unlock()
+	runtime.UnlockOSThread()
+	return nil
+}
@@ -0,0 +1,34 @@
+package wintoken
+
+import (
+	"net/http"
+)
+
+// impersonatingTransport runs every request of base while impersonating a token
+type impersonatingTransport struct {
+	t    *Token
+	base http.RoundTripper
+}
+
+// RoundTripper wraps base so that each request runs while impersonating the token on a locked OS thread. base must be
+// a transport doing Negotiate or NTLM integrated authentication through SSPI on the calling goroutine, such as
+// github.com/alexbrainman/sspi/negotiate, so the authentication to intranet services happens as the token's user.
+// Only the work done by base on the calling goroutine is impersonated, net/http dials connections on other
+// goroutines, so wrapping http.DefaultTransport would send nothing as the token's user and a nil base returns
+// ErrNilRoundTripper. The Token must stay open while the RoundTripper is in use
+func (t *Token) RoundTripper(base http.RoundTripper) (http.RoundTripper, error) {
+	if base == nil {
+		return nil, ErrNilRoundTripper
+	}
+	return &impersonatingTransport{t: t, base: base}, nil
+}
+
+func (rt *impersonatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var resp *http.Response
+	err := rt.t.RunAs(func() error {
+		var err error
+		resp, err = rt.base.RoundTrip(req)
+		return err
+	})
+	return resp, err
+}
@@ -0,0 +1,219 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procImpersonateLoggedOnUser = modadvapi32.NewProc("ImpersonateLoggedOnUser")
+)
+
+func impersonateLoggedOnUser(t windows.Token) error {
+	if r1, _, err := procImpersonateLoggedOnUser.Call(uintptr(t)); r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+// impersonate impersonates t on the current thread and checks the thread token is the user of t at the expected
+// level: ImpersonateLoggedOnUser succeeds with an Identification level thread token when the caller lacks
+// SeImpersonatePrivilege, which then fails every access made while impersonating. The thread is reverted on failure
+func impersonate(t windows.Token) error {
+	if err := impersonateLoggedOnUser(t); err != nil {
+		return fmt.Errorf("error while ImpersonateLoggedOnUser: %w", err)
+	}
+	if err := verifyImpersonation(t); err != nil {
+		windows.RevertToSelf()
+		return err
+	}
+	return nil
+}
+
+// verifyImpersonation compares the thread token with the token t the thread impersonates
+func verifyImpersonation(t windows.Token) error {
+	var thread windows.Token
+	if err := windows.OpenThreadToken(windows.CurrentThread(), windows.TOKEN_QUERY, true, &thread); err != nil {
+		return fmt.Errorf("error while OpenThreadToken after impersonation: %w", err)
+	}
+	defer thread.Close()
+
+	expected := SecurityImpersonation
+	if typ, err := tokenTypeOf(t); err == nil && typ == windows.TokenImpersonation {
+		if level, err := tokenInfoUint32(t, windows.TokenImpersonationLevel); err == nil {
+			expected = ImpersonationLevel(level)
+		}
+	}
+	level, err := tokenInfoUint32(thread, windows.TokenImpersonationLevel)
+	if err != nil {
+		return fmt.Errorf("error while getting the thread impersonation level: %w", err)
+	}
+	if ImpersonationLevel(level) < expected {
+		return fmt.Errorf("%w: %s instead of %s, SeImpersonatePrivilege may be missing", ErrImpersonationDegraded, ImpersonationLevel(level), expected)
+	}
+
+	if user, threadUser := tokenUserSID(t), tokenUserSID(thread); user != "" && user != threadUser {
+		return fmt.Errorf("%w: %s instead of %s", ErrImpersonationUserMismatch, threadUser, user)
+	}
+	return nil
+}
+
+// RunAs locks the calling goroutine to its OS thread, impersonates the token on that thread and runs fn.
+// The impersonation is reverted once fn returns. If reverting fails the goroutine is left locked
+// so the runtime discards the impersonating thread instead of handing it to other goroutines.
+// If fn unlocks the thread it was given and returns on another one, RunAs fails with ErrImpersonationThread.
+// fn is not run if the thread token does not match the token after impersonation, see ErrImpersonationDegraded
+func (t *Token) RunAs(fn func() error) (err error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return err
+	}
+	defer t.release()
+
+	runtime.LockOSThread()
+
+	if err := impersonate(t.token); err != nil {
+		runtime.UnlockOSThread()
+		audit(AuditImpersonate, start, 0, 0, t.token, err)
+		return err
+	}
+	audit(AuditImpersonate, start, 0, 0, t.token, nil)
+	tid := windows.GetCurrentThreadId()
+	defer func() {
+		if windows.GetCurrentThreadId() != tid {
+			// reverting here would not revert the impersonating thread
+			if guardErr := guardViolation("RunAs"); err == nil {
+				err = guardErr
+			}
+			return
+		}
+		if windows.RevertToSelf() == nil {
+			runtime.UnlockOSThread()
+		}
+	}()
+
+	return fn()
+}
+
+// ImpersonationContext is an impersonation started with EnterImpersonation. It tracks the OS thread it locked and
+// reverts it exactly once, when Exit is called from that thread
+type ImpersonationContext struct {
+	typ    tokenType
+	tid    uint32
+	stack  string
+	exited int32
+}
+
+// EnterImpersonation locks the calling goroutine to its OS thread and impersonates the token on it, for code which
+// cannot be wrapped in a RunAs callback:
+//
+//	ctx, err := t.EnterImpersonation()
+//	if err != nil {
+//		return err
+//	}
+//	defer ctx.Exit()
+//
+// If the context is garbage collected without Exit being called it is reported to the leak handler, see SetLeakHandler.
+// The Token may be closed while the context is active
+func (t *Token) EnterImpersonation() (*ImpersonationContext, error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	runtime.LockOSThread()
+
+	if err := impersonate(t.token); err != nil {
+		runtime.UnlockOSThread()
+		audit(AuditImpersonate, start, 0, 0, t.token, err)
+		return nil, err
+	}
+	audit(AuditImpersonate, start, 0, 0, t.token, nil)
+
+	ctx := &ImpersonationContext{typ: t.typ, tid: windows.GetCurrentThreadId()}
+	if atomic.LoadInt32(&leakDetection) != 0 {
+		ctx.stack = string(debug.Stack())
+	}
+	runtime.SetFinalizer(ctx, finalizeImpersonation)
+	return ctx, nil
+}
+
+func finalizeImpersonation(ctx *ImpersonationContext) {
+	if atomic.LoadInt32(&ctx.exited) != 0 {
+		return
+	}
+	if fn, ok := leakHandler.Load().(func(LeakReport)); ok && fn != nil {
+		fn(LeakReport{Type: ctx.typ, Stack: ctx.stack, Impersonation: true})
+	}
+}
+
+// Exit reverts the impersonation and unlocks the goroutine from its thread. Only the first call reverts, later ones
+// return nil. It must be called from the goroutine which entered the impersonation, an error wrapping ErrImpersonationThread is
+// returned otherwise and the impersonation stays active. If reverting fails the goroutine is left locked so the
+// runtime discards the impersonating thread
+func (ctx *ImpersonationContext) Exit() error {
+	if atomic.LoadInt32(&ctx.exited) != 0 {
+		return nil
+	}
+	if windows.GetCurrentThreadId() != ctx.tid {
+		return guardViolation("ImpersonationContext.Exit")
+	}
+	if !atomic.CompareAndSwapInt32(&ctx.exited, 0, 1) {
+		return nil
+	}
+	runtime.SetFinalizer(ctx, nil)
+
+	if err := windows.RevertToSelf(); err != nil {
+		return fmt.Errorf("error while RevertToSelf: %w", err)
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+// captureClientToken impersonates a client on a locked OS thread using impersonate,
+// copies the resulting thread token and reverts using revert. It is shared by the
+// pipe, COM and RPC helpers which only differ in how the client is impersonated
+func captureClientToken(impersonate, revert func() error, tokenType tokenType, opts []Option) (*Token, error) {
+	var (
+		threadToken windows.Token
+		err         error
+	)
+
+	runtime.LockOSThread()
+
+	if err = impersonate(); err != nil {
+		runtime.UnlockOSThread()
+		return nil, err
+	}
+
+	openErr := windows.OpenThreadToken(windows.CurrentThread(), windows.TOKEN_DUPLICATE|windows.TOKEN_QUERY, true, &threadToken)
+
+	if err = revert(); err != nil {
+		if openErr == nil {
+			windows.CloseHandle(windows.Handle(threadToken))
+		}
+		return nil, fmt.Errorf("error while reverting client impersonation: %w", err)
+	}
+	runtime.UnlockOSThread()
+
+	if openErr != nil {
+		return nil, fmt.Errorf("error while OpenThreadToken: %w", openErr)
+	}
+	defer windows.CloseHandle(windows.Handle(threadToken))
+
+	o := newOptions(opts)
+	duplicatedToken, err := duplicateToken(threadToken, tokenType, o)
+	if err != nil {
+		return nil, err
+	}
+
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
+}
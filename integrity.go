@@ -0,0 +1,179 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// IntegrityLevel is a mandatory integrity level, its value is the RID of the S-1-16-* label SID
+type IntegrityLevel uint32
+
+const (
+	IntegrityUntrusted  IntegrityLevel = 0x0000
+	IntegrityLow        IntegrityLevel = 0x1000
+	IntegrityMedium     IntegrityLevel = 0x2000
+	IntegrityMediumPlus IntegrityLevel = 0x2100
+	IntegrityHigh       IntegrityLevel = 0x3000
+	IntegritySystem     IntegrityLevel = 0x4000
+	IntegrityProtected  IntegrityLevel = 0x5000
+)
+
+// SID returns the label SID of the integrity level in string form
+func (l IntegrityLevel) SID() string {
+	return fmt.Sprintf("S-1-16-%d", uint32(l))
+}
+
+func (l IntegrityLevel) String() string {
+	switch l {
+	case IntegrityUntrusted:
+		return "Untrusted"
+	case IntegrityLow:
+		return "Low"
+	case IntegrityMedium:
+		return "Medium"
+	case IntegrityMediumPlus:
+		return "Medium Plus"
+	case IntegrityHigh:
+		return "High"
+	case IntegritySystem:
+		return "System"
+	case IntegrityProtected:
+		return "Protected"
+	default:
+		return "Unknown"
+	}
+}
+
+// SetIntegrityLevel sets the mandatory integrity label of the token.
+// Lowering the level only requires TOKEN_ADJUST_DEFAULT, raising it requires SeTcbPrivilege
+func (t *Token) SetIntegrityLevel(level IntegrityLevel) error {
+	if err := t.acquire(); err != nil {
+		return err
+	}
+	defer t.release()
+
+	sid, err := windows.StringToSid(level.SID())
+	if err != nil {
+		return err
+	}
+
+	tml := windows.Tokenmandatorylabel{
+		Label: windows.SIDAndAttributes{
+			Sid:        sid,
+			Attributes: windows.SE_GROUP_INTEGRITY,
+		},
+	}
+
+	err = windows.SetTokenInformation(t.token, windows.TokenIntegrityLevel, (*byte)(unsafe.Pointer(&tml)), tml.Size())
+	t.invalidate()
+	if err != nil {
+		return fmt.Errorf("error while setting integrity level: %w", err)
+	}
+	return nil
+}
+
+// integrityLevel reads the mandatory label of the token through the information cache and returns its RID
+func (t *Token) integrityLevel() (IntegrityLevel, error) {
+	b, err := t.info(windows.TokenIntegrityLevel)
+	if err != nil {
+		return 0, fmt.Errorf("error while getting integrity level: %w", err)
+	}
+	return decodeIntegrityLevel(b), nil
+}
+
+// tokenIntegrity reads the integrity level of a raw token handle
+func tokenIntegrity(t windows.Token) (IntegrityLevel, error) {
+	var il IntegrityLevel
+	err := withTokenInfo(t, windows.TokenIntegrityLevel, func(b []byte) error {
+		il = decodeIntegrityLevel(b)
+		return nil
+	})
+	return il, err
+}
+
+// decodeIntegrityLevel returns the RID of the label SID of a TOKEN_MANDATORY_LABEL
+func decodeIntegrityLevel(b []byte) IntegrityLevel {
+	tml := (*windows.Tokenmandatorylabel)(unsafe.Pointer(&b[0]))
+	sid := tml.Label.Sid
+	if n := sid.SubAuthorityCount(); n > 0 {
+		return IntegrityLevel(sid.SubAuthority(uint32(n - 1)))
+	}
+	return 0
+}
+
+// IntegrityLevel returns the mandatory integrity level of the token as an IntegrityLevel,
+// levels between the well-known ones are returned as is and print as Unknown
+func (t *Token) IntegrityLevel() (IntegrityLevel, error) {
+	if err := t.acquire(); err != nil {
+		return 0, err
+	}
+	defer t.release()
+
+	return t.integrityLevel()
+}
+
+// MandatoryPolicy is the TOKEN_MANDATORY_POLICY of a token, a combination of the MandatoryPolicy* flags
+type MandatoryPolicy uint32
+
+const (
+	// MandatoryPolicyOff disables mandatory integrity checks for the token
+	MandatoryPolicyOff MandatoryPolicy = 0x0
+	// MandatoryPolicyNoWriteUp denies write access to objects with a higher integrity label
+	MandatoryPolicyNoWriteUp MandatoryPolicy = 0x1
+	// MandatoryPolicyNewProcessMin gives child processes the lower of the token and executable integrity levels
+	MandatoryPolicyNewProcessMin MandatoryPolicy = 0x2
+	// MandatoryPolicyValidMask is the default policy of logon tokens
+	MandatoryPolicyValidMask = MandatoryPolicyNoWriteUp | MandatoryPolicyNewProcessMin
+)
+
+func (p MandatoryPolicy) String() string {
+	if p == MandatoryPolicyOff {
+		return "Off"
+	}
+	var names []string
+	if p&MandatoryPolicyNoWriteUp != 0 {
+		names = append(names, "NoWriteUp")
+	}
+	if p&MandatoryPolicyNewProcessMin != 0 {
+		names = append(names, "NewProcessMin")
+	}
+	if p&^MandatoryPolicyValidMask != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(p&^MandatoryPolicyValidMask)))
+	}
+	return strings.Join(names, "|")
+}
+
+// SetMandatoryPolicy sets the mandatory integrity policy of the token, for example MandatoryPolicyNewProcessMin
+// starts children at the lower of the token level and the label of their executable, so a medium token launching a
+// low labelled executable creates a low integrity process. It requires SeTcbPrivilege
+func (t *Token) SetMandatoryPolicy(p MandatoryPolicy) error {
+	if err := t.acquire(); err != nil {
+		return err
+	}
+	defer t.release()
+
+	policy := uint32(p)
+	err := windows.SetTokenInformation(t.token, windows.TokenMandatoryPolicy, (*byte)(unsafe.Pointer(&policy)), uint32(unsafe.Sizeof(policy)))
+	t.invalidate()
+	if err != nil {
+		return fmt.Errorf("error while setting mandatory policy: %w", err)
+	}
+	return nil
+}
+
+// MandatoryPolicy returns the mandatory integrity policy of the token
+func (t *Token) MandatoryPolicy() (MandatoryPolicy, error) {
+	if err := t.acquire(); err != nil {
+		return 0, err
+	}
+	defer t.release()
+
+	b, err := t.info(windows.TokenMandatoryPolicy)
+	if err != nil {
+		return 0, fmt.Errorf("error while getting mandatory policy: %w", err)
+	}
+	return MandatoryPolicy(*(*uint32)(unsafe.Pointer(&b[0]))), nil
+}
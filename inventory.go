@@ -0,0 +1,134 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ProcessInfo describes a running process and its token, see Processes. Fields which cannot be queried,
+// for example the token of a protected process, are left zero
+type ProcessInfo struct {
+	PID  uint32
+	PPID uint32
+	// Name is the executable name from the process snapshot, ImagePath its full Win32 path
+	Name      string
+	ImagePath string
+	// WOW64 is set for 32-bit processes running on a 64-bit host
+	WOW64      bool
+	SessionID  uint32
+	User       string
+	UserSID    string
+	Integrity  IntegrityLevel
+	Elevated   bool
+	Protection ProcessProtection
+	// Privileges are held by the token, EnabledPrivileges the subset currently enabled
+	Privileges        []Privilege
+	EnabledPrivileges []Privilege
+}
+
+func (p ProcessInfo) String() string {
+	arch := ""
+	if p.WOW64 {
+		arch = " (32-bit)"
+	}
+	return fmt.Sprintf("%d %s%s, session %d, %s, %s integrity, elevated: %t, protection: %s", p.PID, p.Name, arch, p.SessionID, p.User, p.Integrity, p.Elevated, p.Protection)
+}
+
+// isWow64 reports whether the process runs under WOW64. IsWow64Process2 is used where available since it also
+// handles ARM64 hosts, IsWow64Process otherwise
+func isWow64(h windows.Handle) (bool, error) {
+	var processMachine, nativeMachine uint16
+	if err := windows.IsWow64Process2(h, &processMachine, &nativeMachine); err == nil {
+		return processMachine != 0, nil
+	}
+
+	var wow64 bool
+	if err := windows.IsWow64Process(h, &wow64); err != nil {
+		return false, fmt.Errorf("error while IsWow64Process: %w", err)
+	}
+	return wow64, nil
+}
+
+// processImagePath returns the full Win32 path of the executable of a process. Unlike module snapshots it works
+// for 32-bit processes from a 64-bit caller and the other way round, and needs only PROCESS_QUERY_LIMITED_INFORMATION
+func processImagePath(h windows.Handle) (string, error) {
+	buf := make([]uint16, windows.MAX_LONG_PATH)
+	n := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(h, 0, &buf[0], &n); err != nil {
+		return "", fmt.Errorf("error while QueryFullProcessImageName: %w", err)
+	}
+	return windows.UTF16ToString(buf[:n]), nil
+}
+
+// tokenPrivileges returns the names of the privileges held by the token and of those enabled
+func tokenPrivileges(t windows.Token) (held, enabled []Privilege) {
+	withTokenInfo(t, windows.TokenPrivileges, func(b []byte) error {
+		for _, p := range (*windows.Tokenprivileges)(unsafe.Pointer(&b[0])).AllPrivileges() {
+			if p.Attributes&windows.SE_PRIVILEGE_REMOVED != 0 {
+				continue
+			}
+			name, _, err := lookupPrivilegeNameByLUID(luidUint64(p.Luid))
+			if err != nil {
+				continue
+			}
+			held = append(held, Privilege(name))
+			if p.Attributes&windows.SE_PRIVILEGE_ENABLED != 0 {
+				enabled = append(enabled, Privilege(name))
+			}
+		}
+		return nil
+	})
+	return held, enabled
+}
+
+// describeProcess fills ProcessInfo for a snapshot entry, ignoring what cannot be queried
+func describeProcess(p processEntry) ProcessInfo {
+	info := ProcessInfo{PID: p.pid, PPID: p.ppid, Name: p.exe}
+
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, p.pid)
+	if err != nil {
+		return info
+	}
+	defer windows.CloseHandle(h)
+
+	info.ImagePath, _ = processImagePath(h)
+	info.WOW64, _ = isWow64(h)
+	info.Protection, _ = processProtection(h)
+	windows.ProcessIdToSessionId(p.pid, &info.SessionID)
+
+	var t windows.Token
+	if err := windows.OpenProcessToken(h, windows.TOKEN_QUERY, &t); err != nil {
+		return info
+	}
+	defer t.Close()
+
+	info.UserSID = tokenUserSID(t)
+	if user, err := t.GetTokenUser(); err == nil {
+		info.User = accountName(user.User.Sid)
+	}
+	info.Integrity, _ = tokenIntegrity(t)
+	info.Elevated = t.IsElevated()
+	info.Privileges, info.EnabledPrivileges = tokenPrivileges(t)
+	return info
+}
+
+// Processes lists the running processes with their image path, architecture, session, user, integrity level,
+// elevation, privileges and protection. 32-bit and 64-bit processes are reported alike whatever the architecture of the caller.
+// SeDebugPrivilege is needed to query the tokens of other users' processes
+func Processes() ([]ProcessInfo, error) {
+	processes, err := enumerateProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]ProcessInfo, 0, len(processes))
+	for _, p := range processes {
+		if p.pid == 0 {
+			continue
+		}
+		infos = append(infos, describeProcess(p))
+	}
+	return infos, nil
+}
@@ -0,0 +1,388 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var procCreateProcessWithTokenW = modadvapi32.NewProc("CreateProcessWithTokenW")
+
+const defaultDesktop = `winsta0\default`
+
+// logonWithProfile is LOGON_WITH_PROFILE, CreateProcessWithTokenW loads the profile of the token user
+const logonWithProfile = 0x1
+
+// procThreadAttributeChildProcessPolicy is PROC_THREAD_ATTRIBUTE_CHILD_PROCESS_POLICY
+const procThreadAttributeChildProcessPolicy = 0x0002000E
+
+// ChildProcessPolicy is a PROCESS_CREATION_CHILD_PROCESS_* flag controlling whether a process may create children
+type ChildProcessPolicy uint32
+
+const (
+	// ChildProcessRestricted prevents the process from creating child processes
+	ChildProcessRestricted ChildProcessPolicy = 0x01
+	// ChildProcessOverride lets the process create children even if its parent is restricted, it needs the
+	// launching token to be allowed to create children
+	ChildProcessOverride ChildProcessPolicy = 0x02
+	// ChildProcessRestrictedUnlessSecure prevents the process from creating child processes except secure ones
+	ChildProcessRestrictedUnlessSecure ChildProcessPolicy = 0x04
+)
+
+// StartOptions configures processes started with Token.StartProcess, the zero value is ready to use
+type StartOptions struct {
+	// Dir is the working directory of the process, the caller's working directory is used if empty
+	Dir string
+	// Env is the environment of the process in key=value form.
+	// If nil, the default environment of the token's user is built with CreateEnvironmentBlock
+	Env []string
+	// Desktop is the window station and desktop the process is attached to, defaults to winsta0\default
+	Desktop string
+	// HideWindow starts the main window of the process hidden
+	HideWindow bool
+	// CreationFlags are additional CreateProcess creation flags such as CREATE_NEW_CONSOLE
+	CreationFlags uint32
+	// AppContainer launches the process inside the given AppContainer
+	AppContainer *AppContainer
+	// ProcessSecurity and ThreadSecurity are applied to the new process and thread objects, so the
+	// parent can grant monitoring tools access or keep the launched user from opening the child with full access.
+	// If nil, ProcessSDDL and ThreadSDDL are parsed instead, otherwise the token's default DACL is used
+	ProcessSecurity *windows.SECURITY_DESCRIPTOR
+	ThreadSecurity  *windows.SECURITY_DESCRIPTOR
+	ProcessSDDL     string
+	ThreadSDDL      string
+	// Stdin, Stdout and Stderr become the standard handles of the process when set. They are the only handles
+	// the process inherits, StartProcess duplicates them so the caller keeps ownership
+	Stdin  windows.Handle
+	Stdout windows.Handle
+	Stderr windows.Handle
+	// ChildProcessPolicy is applied with PROC_THREAD_ATTRIBUTE_CHILD_PROCESS_POLICY when set, ChildProcessRestricted
+	// keeps a sandboxed process launched with a restricted token from starting further processes.
+	// It needs Windows 10 version 1511 or later
+	ChildProcessPolicy ChildProcessPolicy
+}
+
+// securityAttributes builds the SECURITY_ATTRIBUTES for a process or thread object from a descriptor or SDDL
+func securityAttributes(sd *windows.SECURITY_DESCRIPTOR, sddl string) (*windows.SecurityAttributes, error) {
+	if sd == nil {
+		if sddl == "" {
+			return nil, nil
+		}
+
+		var err error
+		if sd, err = windows.SecurityDescriptorFromString(sddl); err != nil {
+			return nil, fmt.Errorf("error while parsing security descriptor %q: %w", sddl, err)
+		}
+	}
+
+	sa := &windows.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+	return sa, nil
+}
+
+// procThreadAttribute is an attribute added to the PROC_THREAD_ATTRIBUTE_LIST of a launch
+type procThreadAttribute struct {
+	attribute uintptr
+	value     unsafe.Pointer
+	size      uintptr
+}
+
+// inheritStdHandles sets the standard handles of si to inheritable duplicates of the ones in opts and returns the
+// duplicates, which the caller closes once the process is created
+func (opts *StartOptions) inheritStdHandles(si *windows.StartupInfo) ([]windows.Handle, error) {
+	if opts.Stdin == 0 && opts.Stdout == 0 && opts.Stderr == 0 {
+		return nil, nil
+	}
+
+	var inherited []windows.Handle
+	for _, std := range []struct {
+		h   windows.Handle
+		dst *windows.Handle
+	}{{opts.Stdin, &si.StdInput}, {opts.Stdout, &si.StdOutput}, {opts.Stderr, &si.StdErr}} {
+		if std.h == 0 {
+			continue
+		}
+		p := windows.CurrentProcess()
+		if err := windows.DuplicateHandle(p, std.h, p, std.dst, 0, true, windows.DUPLICATE_SAME_ACCESS); err != nil {
+			for _, h := range inherited {
+				windows.CloseHandle(h)
+			}
+			return nil, fmt.Errorf("error while DuplicateHandle: %w", err)
+		}
+		inherited = append(inherited, *std.dst)
+	}
+	si.Flags |= windows.STARTF_USESTDHANDLES
+	return inherited, nil
+}
+
+// attributes returns the process thread attributes requested by the options
+func (opts *StartOptions) attributes() []procThreadAttribute {
+	var attrs []procThreadAttribute
+	if opts.AppContainer != nil {
+		attrs = append(attrs, opts.AppContainer.attributes()...)
+	}
+	if opts.ChildProcessPolicy != 0 {
+		policy := new(uint32)
+		*policy = uint32(opts.ChildProcessPolicy)
+		attrs = append(attrs, procThreadAttribute{
+			attribute: procThreadAttributeChildProcessPolicy,
+			value:     unsafe.Pointer(policy),
+			size:      unsafe.Sizeof(*policy),
+		})
+	}
+	return attrs
+}
+
+// Process is a process started with Token.StartProcess
+type Process struct {
+	Pid    int
+	handle windows.Handle
+}
+
+// Handle returns the process handle, it stays valid until Release or Wait is called
+func (p *Process) Handle() windows.Handle {
+	return p.handle
+}
+
+// Wait waits for the process to exit, releases the process handle and returns the exit code
+func (p *Process) Wait() (uint32, error) {
+	defer p.Release()
+
+	if _, err := windows.WaitForSingleObject(p.handle, windows.INFINITE); err != nil {
+		return 0, fmt.Errorf("error while WaitForSingleObject: %w", err)
+	}
+
+	var exitCode uint32
+	if err := windows.GetExitCodeProcess(p.handle, &exitCode); err != nil {
+		return 0, fmt.Errorf("error while GetExitCodeProcess: %w", err)
+	}
+	return exitCode, nil
+}
+
+// Release releases the process handle without waiting for the process to exit
+func (p *Process) Release() error {
+	if p.handle == 0 {
+		return nil
+	}
+	err := windows.CloseHandle(p.handle)
+	p.handle = 0
+	return err
+}
+
+// environmentBlock builds a CREATE_UNICODE_ENVIRONMENT block from key=value pairs
+func environmentBlock(env []string) *uint16 {
+	if len(env) == 0 {
+		return &[]uint16{0, 0}[0]
+	}
+
+	block := make([]uint16, 0, len(env)*32)
+	for _, kv := range env {
+		if strings.IndexByte(kv, 0) != -1 {
+			continue
+		}
+		block = append(block, windows.StringToUTF16(kv)...)
+	}
+	block = append(block, 0)
+
+	return &block[0]
+}
+
+// commandLine quotes exe and args into a CreateProcess command line, exe being the first argument
+func commandLine(exe string, args []string) string {
+	return windows.ComposeCommandLine(append([]string{exe}, args...))
+}
+
+// launchParams are the CreateProcess arguments built from StartOptions that every launch API shares
+type launchParams struct {
+	cmdLine *uint16
+	dir     *uint16
+	env     *uint16
+	si      windows.StartupInfo
+}
+
+// launchParams builds the command line, working directory, environment and startup information of a launch as t
+func (t *Token) launchParams(exe string, args []string, opts *StartOptions) (*launchParams, error) {
+	env := opts.Env
+	if env == nil {
+		var err error
+		if env, err = t.token.Environ(false); err != nil {
+			return nil, fmt.Errorf("error while CreateEnvironmentBlock: %w", err)
+		}
+	}
+
+	desktop := opts.Desktop
+	if desktop == "" {
+		desktop = defaultDesktop
+	}
+
+	lp := &launchParams{env: environmentBlock(env)}
+	var err error
+	// no application name is passed, so exe is resolved from the quoted first argument of the command line with the
+	// CreateProcess search: the directory of the caller's executable, the current directory, the system directories
+	// and the caller's PATH, with .exe appended when exe has no extension
+	if lp.cmdLine, err = windows.UTF16PtrFromString(commandLine(exe, args)); err != nil {
+		return nil, err
+	}
+	if opts.Dir != "" {
+		if lp.dir, err = windows.UTF16PtrFromString(opts.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	lp.si.Cb = uint32(unsafe.Sizeof(lp.si))
+	if lp.si.Desktop, err = windows.UTF16PtrFromString(desktop); err != nil {
+		return nil, err
+	}
+	if opts.HideWindow {
+		lp.si.Flags |= windows.STARTF_USESHOWWINDOW
+		lp.si.ShowWindow = windows.SW_HIDE
+	}
+	return lp, nil
+}
+
+// StartProcess starts exe with args using CreateProcessAsUser, the process runs as the user of the token.
+// exe may be a bare name such as cmd.exe or powershell, it is searched like CreateProcess does when no application
+// name is given, in the caller's PATH rather than the one of the token user. The token must be a primary token, opts
+// may be nil. CreateProcessAsUser needs SeAssignPrimaryTokenPrivilege for tokens which are not a restricted copy of
+// the caller's, which elevated administrators do not hold, see StartProcessWithToken
+func (t *Token) StartProcess(exe string, args []string, opts *StartOptions) (*Process, error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	if opts == nil {
+		opts = &StartOptions{}
+	}
+
+	lp, err := t.launchParams(exe, args, opts)
+	if err != nil {
+		return nil, err
+	}
+	si := &lp.si
+
+	processSA, err := securityAttributes(opts.ProcessSecurity, opts.ProcessSDDL)
+	if err != nil {
+		return nil, err
+	}
+	threadSA, err := securityAttributes(opts.ThreadSecurity, opts.ThreadSDDL)
+	if err != nil {
+		return nil, err
+	}
+
+	inherited, err := opts.inheritStdHandles(si)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for _, h := range inherited {
+			windows.CloseHandle(h)
+		}
+	}()
+
+	var pi windows.ProcessInformation
+	flags := opts.CreationFlags | windows.CREATE_UNICODE_ENVIRONMENT
+
+	siEx := &windows.StartupInfoEx{StartupInfo: *si}
+	attrs := opts.attributes()
+	if len(inherited) > 0 {
+		// restrict inheritance to the standard handles
+		attrs = append(attrs, procThreadAttribute{
+			attribute: windows.PROC_THREAD_ATTRIBUTE_HANDLE_LIST,
+			value:     unsafe.Pointer(&inherited[0]),
+			size:      uintptr(len(inherited)) * unsafe.Sizeof(inherited[0]),
+		})
+	}
+	if len(attrs) > 0 {
+		attrList, err := windows.NewProcThreadAttributeList(uint32(len(attrs)))
+		if err != nil {
+			return nil, fmt.Errorf("error while InitializeProcThreadAttributeList: %w", err)
+		}
+		defer attrList.Delete()
+
+		for _, a := range attrs {
+			if err := attrList.Update(a.attribute, a.value, a.size); err != nil {
+				return nil, fmt.Errorf("error while UpdateProcThreadAttribute: %w", err)
+			}
+		}
+
+		siEx.Cb = uint32(unsafe.Sizeof(*siEx))
+		siEx.ProcThreadAttributeList = attrList.List()
+		flags |= windows.EXTENDED_STARTUPINFO_PRESENT
+	}
+
+	err = windows.CreateProcessAsUser(t.token, nil, lp.cmdLine, processSA, threadSA, len(inherited) > 0, flags, lp.env, lp.dir, &siEx.StartupInfo, &pi)
+	runtime.KeepAlive(attrs)
+	runtime.KeepAlive(inherited)
+	if err != nil {
+		err = fmt.Errorf("error while CreateProcessAsUser: %w", err)
+		audit(AuditLaunch, start, 0, 0, t.token, err)
+		return nil, err
+	}
+	windows.CloseHandle(pi.Thread)
+	audit(AuditLaunch, start, int(pi.ProcessId), 0, t.token, nil)
+
+	return &Process{Pid: int(pi.ProcessId), handle: pi.Process}, nil
+}
+
+// StartProcessWithToken starts exe with args using CreateProcessWithTokenW, which only needs SeImpersonatePrivilege
+// and so works for elevated administrators launching with a token stolen from another user or SYSTEM. The process
+// is created by the Secondary Logon service with the profile of the token user loaded. exe is searched like
+// StartProcess does. Only Dir, Env, Desktop,
+// HideWindow, CreationFlags and the standard handles of opts are supported, other options return
+// ErrStartOptionUnsupported. opts may be nil
+func (t *Token) StartProcessWithToken(exe string, args []string, opts *StartOptions) (*Process, error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	if opts == nil {
+		opts = &StartOptions{}
+	}
+	if opts.AppContainer != nil || opts.ProcessSecurity != nil || opts.ThreadSecurity != nil ||
+		opts.ProcessSDDL != "" || opts.ThreadSDDL != "" || opts.ChildProcessPolicy != 0 {
+		return nil, ErrStartOptionUnsupported
+	}
+
+	lp, err := t.launchParams(exe, args, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Stdin != 0 || opts.Stdout != 0 || opts.Stderr != 0 {
+		// the Secondary Logon service duplicates the standard handles into the new process itself
+		lp.si.StdInput, lp.si.StdOutput, lp.si.StdErr = opts.Stdin, opts.Stdout, opts.Stderr
+		lp.si.Flags |= windows.STARTF_USESTDHANDLES
+	}
+
+	var pi windows.ProcessInformation
+	r1, _, e1 := procCreateProcessWithTokenW.Call(
+		uintptr(t.token),
+		logonWithProfile,
+		0,
+		uintptr(unsafe.Pointer(lp.cmdLine)),
+		uintptr(opts.CreationFlags|windows.CREATE_UNICODE_ENVIRONMENT),
+		uintptr(unsafe.Pointer(lp.env)),
+		uintptr(unsafe.Pointer(lp.dir)),
+		uintptr(unsafe.Pointer(&lp.si)),
+		uintptr(unsafe.Pointer(&pi)),
+	)
+	runtime.KeepAlive(lp)
+	if r1 == 0 {
+		err := fmt.Errorf("error while CreateProcessWithTokenW: %w", e1)
+		audit(AuditLaunch, start, 0, 0, t.token, err)
+		return nil, err
+	}
+	windows.CloseHandle(pi.Thread)
+	audit(AuditLaunch, start, int(pi.ProcessId), 0, t.token, nil)
+
+	return &Process{Pid: int(pi.ProcessId), handle: pi.Process}, nil
+}
@@ -0,0 +1,122 @@
+package wintoken
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/sys/windows"
+)
+
+// LeakReport describes a token which has not been closed
+type LeakReport struct {
+	Type   tokenType
+	Handle windows.Token
+	// Stack is the stack trace of the call which created the token, it is only recorded while leak detection is enabled
+	Stack string
+	// Impersonation is set when the report is about an ImpersonationContext which was never exited, rather than
+	// a token which was not closed. The thread it locked keeps impersonating until its goroutine exits
+	Impersonation bool
+}
+
+var (
+	leakHandler atomic.Value
+
+	leakDetection int32
+	leakMu        sync.Mutex
+	leakNextID    uint64
+	leakOpen      = map[uint64]LeakReport{}
+)
+
+// newToken wraps a token handle owned by the package. A finalizer closes the handle if the Token
+// is garbage collected without being closed and reports it to the leak handler
+func newToken(token windows.Token, typ tokenType) *Token {
+	t := &Token{token: token, typ: typ}
+	trackToken(t)
+	runtime.SetFinalizer(t, finalizeToken)
+	return t
+}
+
+func finalizeToken(t *Token) {
+	if t.token == 0 {
+		return
+	}
+
+	report := LeakReport{Type: t.typ, Handle: t.token}
+	if t.id != 0 {
+		leakMu.Lock()
+		report.Stack = leakOpen[t.id].Stack
+		leakMu.Unlock()
+	}
+
+	t.closeHandle()
+
+	if fn, ok := leakHandler.Load().(func(LeakReport)); ok && fn != nil {
+		fn(report)
+	}
+}
+
+// SetLeakHandler registers fn to be called whenever a token is garbage collected without being closed.
+// The handle has already been closed by the time fn runs. fn runs on the finalizer goroutine and must not block
+func SetLeakHandler(fn func(LeakReport)) {
+	leakHandler.Store(fn)
+}
+
+// EnableLeakDetection records the creation stack of every token created from now on and keeps
+// track of the tokens which have not been closed yet, see OpenTokens. It is meant for tests
+func EnableLeakDetection() {
+	atomic.StoreInt32(&leakDetection, 1)
+}
+
+// DisableLeakDetection stops recording new tokens and forgets the tokens recorded so far
+func DisableLeakDetection() {
+	atomic.StoreInt32(&leakDetection, 0)
+
+	leakMu.Lock()
+	leakOpen = map[uint64]LeakReport{}
+	leakMu.Unlock()
+}
+
+// OpenTokens returns the tokens created while leak detection was enabled which have not been closed,
+// including the ones already garbage collected, in creation order
+func OpenTokens() []LeakReport {
+	leakMu.Lock()
+	defer leakMu.Unlock()
+
+	ids := make([]uint64, 0, len(leakOpen))
+	for id := range leakOpen {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	reports := make([]LeakReport, len(ids))
+	for i, id := range ids {
+		reports[i] = leakOpen[id]
+	}
+	return reports
+}
+
+func trackToken(t *Token) {
+	if atomic.LoadInt32(&leakDetection) == 0 {
+		return
+	}
+
+	leakMu.Lock()
+	defer leakMu.Unlock()
+
+	leakNextID++
+	t.id = leakNextID
+	leakOpen[t.id] = LeakReport{Type: t.typ, Handle: t.token, Stack: string(debug.Stack())}
+}
+
+func untrackToken(t *Token) {
+	if t.id == 0 {
+		return
+	}
+
+	leakMu.Lock()
+	delete(leakOpen, t.id)
+	leakMu.Unlock()
+}
@@ -0,0 +1,84 @@
+package wintoken
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+// collectLeaks runs the garbage collector until the leak handler reports a token or the timeout expires
+func collectLeaks(t *testing.T, leaks <-chan LeakReport, timeout time.Duration) (LeakReport, bool) {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		runtime.GC()
+		select {
+		case r := <-leaks:
+			return r, true
+		case <-deadline:
+			return LeakReport{}, false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestLeakHandler(t *testing.T) {
+	leaks := make(chan LeakReport, 1)
+	SetLeakHandler(func(r LeakReport) {
+		select {
+		case leaks <- r:
+		default:
+		}
+	})
+	defer SetLeakHandler(nil)
+
+	EnableLeakDetection()
+	defer DisableLeakDetection()
+
+	func() {
+		// the Token is dropped without Close
+		if _, err := OpenProcessToken(0, TokenPrimary); err != nil {
+			t.Fatal(err)
+		}
+		if len(OpenTokens()) != 1 {
+			t.Fatalf("OpenTokens = %v, want the unclosed token", OpenTokens())
+		}
+	}()
+
+	r, ok := collectLeaks(t, leaks, 5*time.Second)
+	if !ok {
+		t.Fatal("leak handler was not called for an unclosed token")
+	}
+	if r.Type != TokenPrimary || r.Handle == 0 || r.Stack == "" {
+		t.Errorf("unexpected leak report %+v", r)
+	}
+}
+
+func TestLeakHandlerClosed(t *testing.T) {
+	leaks := make(chan LeakReport, 1)
+	SetLeakHandler(func(r LeakReport) {
+		select {
+		case leaks <- r:
+		default:
+		}
+	})
+	defer SetLeakHandler(nil)
+
+	EnableLeakDetection()
+	defer DisableLeakDetection()
+
+	func() {
+		tok, err := OpenProcessToken(0, TokenPrimary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok.Close()
+	}()
+
+	if r, ok := collectLeaks(t, leaks, 200*time.Millisecond); ok {
+		t.Errorf("leak handler was called for a closed token: %+v", r)
+	}
+	if open := OpenTokens(); len(open) != 0 {
+		t.Errorf("OpenTokens = %v, want none", open)
+	}
+}
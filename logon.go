@@ -0,0 +1,145 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procLogonUserW     = modadvapi32.NewProc("LogonUserW")
+	procLogonUserExExW = modadvapi32.NewProc("LogonUserExExW")
+)
+
+const (
+	logon32ProviderDefault = 0
+	logon32ProviderVirtual = 4
+)
+
+// logonRights maps the logon types of LogonUser to the user right the account needs for them
+var logonRights = map[LogonType]string{
+	LogonInteractive:       "SeInteractiveLogonRight",
+	LogonNetwork:           "SeNetworkLogonRight",
+	LogonNetworkCleartext:  "SeNetworkLogonRight",
+	LogonBatch:             "SeBatchLogonRight",
+	LogonService:           "SeServiceLogonRight",
+	LogonRemoteInteractive: "SeRemoteInteractiveLogonRight",
+}
+
+// logonError wraps an error of LogonUserW or LogonUserExExW, naming the missing user right when the account is
+// not allowed the logon type
+func logonError(function, username, domain string, logonType LogonType, err error) error {
+	if err == windows.ERROR_LOGON_TYPE_NOT_GRANTED {
+		if right, ok := logonRights[logonType]; ok {
+			return fmt.Errorf("%w: %s\\%s lacks %s for %s logons", ErrLogonTypeNotGranted, domain, username, right, logonType)
+		}
+		return fmt.Errorf("%w: %s\\%s for %s logons", ErrLogonTypeNotGranted, domain, username, logonType)
+	}
+	return fmt.Errorf("error while %s: %w", function, err)
+}
+
+// logonUser wraps LogonUserW, an empty password is passed as NULL. The logon is audited as AuditLogon
+func logonUser(username, domain, password string, logonType LogonType, logonProvider uint32) (windows.Token, error) {
+	start := time.Now()
+	var (
+		t    windows.Token
+		pass *uint16
+	)
+
+	user, err := windows.UTF16PtrFromString(username)
+	if err != nil {
+		return 0, err
+	}
+	dom, err := windows.UTF16PtrFromString(domain)
+	if err != nil {
+		return 0, err
+	}
+	if password != "" {
+		if pass, err = windows.UTF16PtrFromString(password); err != nil {
+			return 0, err
+		}
+	}
+
+	if r1, _, err := procLogonUserW.Call(uintptr(unsafe.Pointer(user)), uintptr(unsafe.Pointer(dom)), uintptr(unsafe.Pointer(pass)), uintptr(logonType), uintptr(logonProvider), uintptr(unsafe.Pointer(&t))); r1 == 0 {
+		err = logonError("LogonUserW", username, domain, logonType, err)
+		auditLogon(start, username, domain, logonType, 0, err)
+		return 0, err
+	}
+	auditLogon(start, username, domain, logonType, t, nil)
+	return t, nil
+}
+
+// logonUserGroups wraps LogonUserExExW, which adds groups to the token of the logon. The logon is audited as AuditLogon
+func logonUserGroups(username, domain, password string, logonType LogonType, logonProvider uint32, groups []windows.SIDAndAttributes) (windows.Token, error) {
+	start := time.Now()
+	var (
+		t    windows.Token
+		pass *uint16
+	)
+
+	user, err := windows.UTF16PtrFromString(username)
+	if err != nil {
+		return 0, err
+	}
+	dom, err := windows.UTF16PtrFromString(domain)
+	if err != nil {
+		return 0, err
+	}
+	if password != "" {
+		if pass, err = windows.UTF16PtrFromString(password); err != nil {
+			return 0, err
+		}
+	}
+
+	tg := newTokenGroups(groups)
+	r1, _, err := procLogonUserExExW.Call(uintptr(unsafe.Pointer(user)), uintptr(unsafe.Pointer(dom)), uintptr(unsafe.Pointer(pass)), uintptr(logonType), uintptr(logonProvider), uintptr(unsafe.Pointer(tg)), uintptr(unsafe.Pointer(&t)), 0, 0, 0, 0)
+	// the SIDs referenced from the group buffer are not visible to the garbage collector
+	runtime.KeepAlive(groups)
+	if r1 == 0 {
+		err = logonError("LogonUserExExW", username, domain, logonType, err)
+		auditLogon(start, username, domain, logonType, 0, err)
+		return 0, err
+	}
+	auditLogon(start, username, domain, logonType, t, nil)
+	return t, nil
+}
+
+// LogonUser logs on an account with its password using LogonUser and returns its token, such as
+// LogonInteractive for a full interactive token, LogonBatch for schedulers, LogonService for service wrappers or
+// LogonNewCredentials to only use the credentials on the network. When the account is not granted the user right
+// of the logon type, such as SeBatchLogonRight or SeServiceLogonRight, the error wraps ErrLogonTypeNotGranted
+func LogonUser(username, domain, password string, logonType LogonType, tokenType tokenType, opts ...Option) (*Token, error) {
+	lt, err := logonUser(username, domain, password, logonType, logon32ProviderDefault)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(windows.Handle(lt))
+
+	o := newOptions(opts)
+	duplicatedToken, err := duplicateToken(lt, tokenType, o)
+	if err != nil {
+		return nil, err
+	}
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
+}
+
+// LogonUserWithGroups logs on an account like LogonUser and adds groups to its token with LogonUserExExW, for example
+// a group created for the logon and used to ACL the resources of that logon only. Adding groups requires SeTcbPrivilege,
+// so the caller usually runs as SYSTEM
+func LogonUserWithGroups(username, domain, password string, logonType LogonType, groups []windows.SIDAndAttributes, tokenType tokenType, opts ...Option) (*Token, error) {
+	lt, err := logonUserGroups(username, domain, password, logonType, logon32ProviderDefault, groups)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(windows.Handle(lt))
+
+	o := newOptions(opts)
+	duplicatedToken, err := duplicateToken(lt, tokenType, o)
+	if err != nil {
+		return nil, err
+	}
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
+}
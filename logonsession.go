@@ -0,0 +1,218 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procLsaGetLogonSessionData = modsecur32.NewProc("LsaGetLogonSessionData")
+	procLsaFreeReturnBuffer    = modsecur32.NewProc("LsaFreeReturnBuffer")
+)
+
+// LogonType is the SECURITY_LOGON_TYPE of a logon session
+type LogonType uint32
+
+const (
+	LogonInteractive       LogonType = 2
+	LogonNetwork           LogonType = 3
+	LogonBatch             LogonType = 4
+	LogonService           LogonType = 5
+	LogonProxy             LogonType = 6
+	LogonUnlock            LogonType = 7
+	LogonNetworkCleartext  LogonType = 8
+	LogonNewCredentials    LogonType = 9
+	LogonRemoteInteractive LogonType = 10
+	LogonCachedInteractive LogonType = 11
+	LogonCachedRemote      LogonType = 12
+	LogonCachedUnlock      LogonType = 13
+)
+
+var logonTypeNames = map[LogonType]string{
+	LogonInteractive:       "Interactive",
+	LogonNetwork:           "Network",
+	LogonBatch:             "Batch",
+	LogonService:           "Service",
+	LogonProxy:             "Proxy",
+	LogonUnlock:            "Unlock",
+	LogonNetworkCleartext:  "NetworkCleartext",
+	LogonNewCredentials:    "NewCredentials",
+	LogonRemoteInteractive: "RemoteInteractive",
+	LogonCachedInteractive: "CachedInteractive",
+	LogonCachedRemote:      "CachedRemoteInteractive",
+	LogonCachedUnlock:      "CachedUnlock",
+}
+
+func (l LogonType) String() string {
+	if name, ok := logonTypeNames[l]; ok {
+		return name
+	}
+	if l == 0 {
+		return "System"
+	}
+	return fmt.Sprintf("LogonType(%d)", uint32(l))
+}
+
+// tokenStatistics is the TOKEN_STATISTICS structure
+type tokenStatistics struct {
+	tokenID            windows.LUID
+	authenticationID   windows.LUID
+	expirationTime     int64
+	tokenType          uint32
+	impersonationLevel uint32
+	dynamicCharged     uint32
+	dynamicAvailable   uint32
+	groupCount         uint32
+	privilegeCount     uint32
+	modifiedID         windows.LUID
+}
+
+// securityLogonSessionData is the beginning of the SECURITY_LOGON_SESSION_DATA structure, up to the fields we read
+type securityLogonSessionData struct {
+	size                  uint32
+	logonID               windows.LUID
+	userName              windows.NTUnicodeString
+	logonDomain           windows.NTUnicodeString
+	authenticationPackage windows.NTUnicodeString
+	logonType             uint32
+	session               uint32
+	sid                   *windows.SID
+	logonTime             int64
+	logonServer           windows.NTUnicodeString
+	dnsDomainName         windows.NTUnicodeString
+	upn                   windows.NTUnicodeString
+}
+
+// LogonSession describes the logon session of a token, as reported by LsaGetLogonSessionData
+type LogonSession struct {
+	// LogonID is the authentication LUID of the token which identifies the logon session
+	LogonID windows.LUID
+	// UserName and Domain are the account which logged on, they can differ from the token user for NewCredentials logons
+	UserName string
+	Domain   string
+	// AuthenticationPackage is the package which authenticated the logon, such as Kerberos, NTLM or Negotiate
+	AuthenticationPackage string
+	LogonType             LogonType
+	SessionID             uint32
+	SID                   string
+	LogonTime             time.Time
+	LogonServer           string
+	// DNSDomainName and UPN are empty for local accounts
+	DNSDomainName string
+	UPN           string
+}
+
+// unicodeString copies an LSA_UNICODE_STRING, whose Length is in bytes and which is not always NUL terminated
+func unicodeString(s windows.NTUnicodeString) string {
+	if s.Buffer == nil || s.Length == 0 {
+		return ""
+	}
+	n := int(s.Length / 2)
+	return string(utf16.Decode((*[1 << 20]uint16)(unsafe.Pointer(s.Buffer))[:n:n]))
+}
+
+// luidUint64 packs a LUID in the 64-bit form used by event logs and LookupPrivilegeName
+func luidUint64(luid windows.LUID) uint64 {
+	return uint64(luid.LowPart) | uint64(luid.HighPart)<<32
+}
+
+// authenticationID returns the LUID of the logon session of the token from TokenStatistics
+func (t *Token) authenticationID() (windows.LUID, error) {
+	return tokenAuthenticationID(t.token)
+}
+
+func tokenAuthenticationID(t windows.Token) (windows.LUID, error) {
+	var (
+		stats tokenStatistics
+		n     uint32
+	)
+	if err := windows.GetTokenInformation(t, windows.TokenStatistics, (*byte)(unsafe.Pointer(&stats)), uint32(unsafe.Sizeof(stats)), &n); err != nil {
+		return windows.LUID{}, fmt.Errorf("error while getting token statistics: %w", err)
+	}
+	return stats.authenticationID, nil
+}
+
+// LogonSession returns the logon session data of the token's authentication LUID, linking the token to its logon:
+// the authentication package, logon type and time, and the UPN and DNS domain of domain accounts.
+// Querying the logon sessions of other users requires administrator rights
+func (t *Token) LogonSession() (*LogonSession, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	return t.logonSession()
+}
+
+// logonSession queries the logon session data, the caller must have acquired t
+func (t *Token) logonSession() (*LogonSession, error) {
+	return tokenLogonSession(t.token)
+}
+
+func tokenLogonSession(t windows.Token) (*LogonSession, error) {
+	luid, err := tokenAuthenticationID(t)
+	if err != nil {
+		return nil, err
+	}
+
+	var data *securityLogonSessionData
+	if r1, _, _ := procLsaGetLogonSessionData.Call(uintptr(unsafe.Pointer(&luid)), uintptr(unsafe.Pointer(&data))); r1 != 0 {
+		return nil, fmt.Errorf("error while LsaGetLogonSessionData: %w", windows.NTStatus(r1))
+	}
+	defer procLsaFreeReturnBuffer.Call(uintptr(unsafe.Pointer(data)))
+
+	session := LogonSession{
+		LogonID:               luid,
+		UserName:              unicodeString(data.userName),
+		Domain:                unicodeString(data.logonDomain),
+		AuthenticationPackage: unicodeString(data.authenticationPackage),
+		LogonType:             LogonType(data.logonType),
+		SessionID:             data.session,
+		LogonServer:           unicodeString(data.logonServer),
+		DNSDomainName:         unicodeString(data.dnsDomainName),
+		UPN:                   unicodeString(data.upn),
+	}
+	if data.sid != nil {
+		session.SID = data.sid.String()
+	}
+	if data.logonTime > 0 {
+		ft := windows.Filetime{LowDateTime: uint32(data.logonTime), HighDateTime: uint32(data.logonTime >> 32)}
+		session.LogonTime = time.Unix(0, ft.Nanoseconds())
+	}
+	return &session, nil
+}
+
+// tokenSourceName returns the TOKEN_SOURCE name of the token, which needs TOKEN_QUERY_SOURCE access
+func tokenSourceName(t windows.Token) string {
+	var (
+		source tokenSource
+		n      uint32
+	)
+	if err := windows.GetTokenInformation(t, windows.TokenSource, (*byte)(unsafe.Pointer(&source)), uint32(unsafe.Sizeof(source)), &n); err != nil {
+		return ""
+	}
+	return strings.TrimRight(string(source.name[:]), "\x00 ")
+}
+
+// newLogonRecord describes the logon of domain\user which created token, token may be 0 if the logon failed
+func newLogonRecord(user, domain string, logonType LogonType, token windows.Token) *LogonRecord {
+	rec := &LogonRecord{TargetUserName: user, TargetDomainName: domain, LogonType: logonType}
+	if token == 0 {
+		return rec
+	}
+
+	rec.LogonProcess = tokenSourceName(token)
+	rec.ElevatedToken = token.IsElevated()
+	if session, err := tokenLogonSession(token); err == nil {
+		rec.LogonID = session.LogonID
+		rec.AuthenticationPackage = session.AuthenticationPackage
+	} else if luid, err := tokenAuthenticationID(token); err == nil {
+		rec.LogonID = luid
+	}
+	return rec
+}
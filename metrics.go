@@ -0,0 +1,80 @@
+package wintoken
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ErrorClass groups the errors of failed operations into a small set of values suitable as metric labels
+type ErrorClass string
+
+const (
+	ErrorClassAccessDenied     ErrorClass = "access_denied"
+	ErrorClassProtectedProcess ErrorClass = "protected_process"
+	ErrorClassPrivilegeNotHeld ErrorClass = "privilege_not_held"
+	ErrorClassNotFound         ErrorClass = "not_found"
+	ErrorClassInvalidParameter ErrorClass = "invalid_parameter"
+	ErrorClassTokenClosed      ErrorClass = "token_closed"
+	ErrorClassOther            ErrorClass = "other"
+)
+
+// ClassifyError returns the ErrorClass of an error returned by this package
+func ClassifyError(err error) ErrorClass {
+	switch {
+	case errors.Is(err, ErrProtectedProcess):
+		return ErrorClassProtectedProcess
+	case errors.Is(err, windows.ERROR_ACCESS_DENIED):
+		return ErrorClassAccessDenied
+	case errors.Is(err, ErrPrivilegeNotHeld), errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD):
+		return ErrorClassPrivilegeNotHeld
+	case errors.Is(err, ErrNoMatchingProcess), errors.Is(err, ErrNoActiveSession), errors.Is(err, ErrNoConsoleSession),
+		errors.Is(err, ErrServiceNotRunning), errors.Is(err, ErrNoMatchingSession), errors.Is(err, windows.ERROR_NOT_FOUND), errors.Is(err, windows.ERROR_NO_TOKEN):
+		return ErrorClassNotFound
+	case errors.Is(err, windows.ERROR_INVALID_PARAMETER), errors.Is(err, ErrOnlyPrimaryImpersonationTokenAllowed):
+		return ErrorClassInvalidParameter
+	case errors.Is(err, ErrTokenClosed):
+		return ErrorClassTokenClosed
+	default:
+		return ErrorClassOther
+	}
+}
+
+// Metrics receives counters and timings for every audited operation, see SetMetrics.
+// Implementations must be safe for concurrent use and should not block
+type Metrics interface {
+	// IncOperation counts an operation, successful or not
+	IncOperation(op AuditOperation)
+	// IncFailure counts a failed operation by the class of its error
+	IncFailure(op AuditOperation, class ErrorClass)
+	// ObserveDuration records how long an operation took, such as the latency of launches
+	ObserveDuration(op AuditOperation, d time.Duration)
+}
+
+// metricsHolder lets SetMetrics store nil and Metrics implementations of different types in the same atomic.Value
+type metricsHolder struct {
+	m Metrics
+}
+
+var metrics atomic.Value
+
+// SetMetrics registers m to receive the metrics of token steals, duplications, impersonations,
+// privilege changes and launches. Pass nil to stop reporting
+func SetMetrics(m Metrics) {
+	metrics.Store(metricsHolder{m})
+}
+
+func recordMetrics(rec AuditRecord) {
+	h, _ := metrics.Load().(metricsHolder)
+	if h.m == nil {
+		return
+	}
+
+	h.m.IncOperation(rec.Operation)
+	if rec.Err != nil {
+		h.m.IncFailure(rec.Operation, ClassifyError(rec.Err))
+	}
+	h.m.ObserveDuration(rec.Operation, rec.Duration)
+}
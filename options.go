@@ -0,0 +1,101 @@
+package wintoken
+
+import "golang.org/x/sys/windows"
+
+// Option configures how a token is acquired and duplicated, and how the resulting Token behaves
+type Option func(*options)
+
+type options struct {
+	level             ImpersonationLevel
+	restorePrivileges bool
+	infoCache         bool
+
+	sessionStates     []uint32
+	sessionPreference SessionPreference
+
+	minIntegrity IntegrityLevel
+	maxIntegrity IntegrityLevel
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		level:         SecurityImpersonation,
+		sessionStates: []uint32{windows.WTSActive},
+		maxIntegrity:  ^IntegrityLevel(0),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithImpersonationLevel sets the impersonation level passed to DuplicateTokenEx for primary,
+// impersonation and linked tokens. It defaults to SecurityImpersonation, SecurityDelegation is only
+// needed when the token's credentials must be forwarded to remote hosts. TokenIdentification tokens
+// are always duplicated at SecurityIdentification
+func WithImpersonationLevel(level ImpersonationLevel) Option {
+	return func(o *options) {
+		o.level = level
+	}
+}
+
+// WithRestorePrivilegesOnClose records the original state of every privilege enabled or disabled through the Token
+// and restores it when the Token is closed. This matters for tokens whose handle is shared, such as the process
+// token wrapped with FromHandle, so a long-lived process does not stay over-privileged by accident.
+// Removed privileges cannot be restored
+func WithRestorePrivilegesOnClose() Option {
+	return func(o *options) {
+		o.restorePrivileges = true
+	}
+}
+
+// WithInfoCache caches the token information queried through the Token, such as its privileges, groups,
+// integrity level and claims. The cache is invalidated whenever the token is modified through the Token
+func WithInfoCache() Option {
+	return func(o *options) {
+		o.infoCache = true
+	}
+}
+
+// SessionPreference orders the sessions considered by GetInteractiveToken
+type SessionPreference int
+
+const (
+	// PreferAny considers sessions in enumeration order
+	PreferAny SessionPreference = iota
+	// PreferConsole considers the session attached to the physical console first
+	PreferConsole
+	// PreferRemote considers remote sessions, such as RDP sessions, first
+	PreferRemote
+)
+
+// WithSessionStates sets the session states GetInteractiveToken considers, such as windows.WTSActive and
+// windows.WTSDisconnected for disconnected RDP users whose processes must still be serviced. It defaults to WTSActive only
+func WithSessionStates(states ...uint32) Option {
+	return func(o *options) {
+		o.sessionStates = states
+	}
+}
+
+// WithSessionPreference sets which sessions GetInteractiveToken tries first, active sessions are always tried
+// before sessions in other states
+func WithSessionPreference(p SessionPreference) Option {
+	return func(o *options) {
+		o.sessionPreference = p
+	}
+}
+
+// WithIntegrityRange restricts the processes considered as token donors, by GetSystemToken, FindTokenSource and the
+// other helpers searching processes, to the ones whose token integrity level is between min and max inclusive.
+// For example WithIntegrityRange(IntegrityHigh, IntegritySystem) skips low integrity sandboxed processes
+func WithIntegrityRange(min, max IntegrityLevel) Option {
+	return func(o *options) {
+		o.minIntegrity = min
+		o.maxIntegrity = max
+	}
+}
+
+// acceptsIntegrity reports whether a donor token is within the integrity range of the options
+func (o options) acceptsIntegrity(il IntegrityLevel) bool {
+	return il >= o.minIntegrity && il <= o.maxIntegrity
+}
@@ -0,0 +1,79 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Microsoft/go-winio"
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procImpersonateNamedPipeClient = modadvapi32.NewProc("ImpersonateNamedPipeClient")
+)
+
+// fdConn is implemented by the connections returned from go-winio pipe listeners and by *os.File
+type fdConn interface {
+	Fd() uintptr
+}
+
+func pipeHandle(conn interface{}) (windows.Handle, error) {
+	c, ok := conn.(fdConn)
+	if !ok {
+		return windows.InvalidHandle, ErrNotNamedPipe
+	}
+	return windows.Handle(c.Fd()), nil
+}
+
+func impersonateNamedPipeClient(h windows.Handle) error {
+	if r1, _, err := procImpersonateNamedPipeClient.Call(uintptr(h)); r1 == 0 {
+		return fmt.Errorf("error while ImpersonateNamedPipeClient: %w", err)
+	}
+	return nil
+}
+
+// GetNamedPipeClientToken captures the token of the client connected to a named pipe server.
+// conn can be a connection accepted from a go-winio pipe listener or an *os.File wrapping the pipe handle.
+// The client must have read from or written to the pipe before its token can be captured
+func GetNamedPipeClientToken(conn interface{}, tokenType tokenType, opts ...Option) (*Token, error) {
+	h, err := pipeHandle(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return captureClientToken(func() error {
+		return impersonateNamedPipeClient(h)
+	}, windows.RevertToSelf, tokenType, opts)
+}
+
+// RunAsNamedPipeClient runs fn while impersonating the client connected to the named pipe, see Token.RunAs
+func RunAsNamedPipeClient(conn interface{}, fn func() error) error {
+	t, err := GetNamedPipeClientToken(conn, TokenImpersonation)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	return t.RunAs(fn)
+}
+
+// PipeSecurityDescriptor builds an SDDL security descriptor for a pipe server which grants
+// full access to SYSTEM and the pipe owner, and read/write access to each of the given clients
+func PipeSecurityDescriptor(clients ...*windows.SID) string {
+	var sb strings.Builder
+
+	sb.WriteString("D:P(A;;GA;;;SY)(A;;GA;;;OW)")
+	for _, sid := range clients {
+		fmt.Fprintf(&sb, "(A;;GRGW;;;%s)", sid.String())
+	}
+
+	return sb.String()
+}
+
+// NewPipeConfig returns a go-winio PipeConfig whose security descriptor only permits
+// the given clients to connect, see PipeSecurityDescriptor
+func NewPipeConfig(clients ...*windows.SID) *winio.PipeConfig {
+	return &winio.PipeConfig{
+		SecurityDescriptor: PipeSecurityDescriptor(clients...),
+	}
+}
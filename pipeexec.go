@@ -0,0 +1,143 @@
+package wintoken
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// maxPipeCommandSize bounds the JSON line a client sends to describe its command
+const maxPipeCommandSize = 64 << 10
+
+// PipeCommand is the command a client of a PipeCommandServer asks to run, sent as one line of JSON. Everything the
+// client writes after that line is the standard input of the command, whose output is written back to the client
+type PipeCommand struct {
+	Exe  string   `json:"exe"`
+	Args []string `json:"args,omitempty"`
+	Dir  string   `json:"dir,omitempty"`
+}
+
+// PipeCommandServer is the privileged helper pattern over named pipes: for every connection it captures the token of
+// the client, launches the requested command as the client and relays the standard input, output and error of the
+// command over the pipe. The connection is closed once the command exited. The server usually runs as a service,
+// since launching processes with the token of another user requires SeAssignPrimaryTokenPrivilege, and should
+// listen with a pipe DACL admitting only the expected clients, see NewPipeConfig:
+//
+//	l, err := winio.ListenPipe(`\\.\pipe\helper`, wintoken.NewPipeConfig(users))
+//	err = (&wintoken.PipeCommandServer{Authorize: authorize}).Serve(l)
+type PipeCommandServer struct {
+	// Authorize decides whether the client may run the command, nil lets every client which could connect run any
+	// command. The token is closed once the command exited
+	Authorize func(client *Token, cmd PipeCommand) error
+	// Options are used to start the commands, their standard handles are replaced by the relay
+	Options StartOptions
+	// Done is called once a command exited with its exit code, or with the error which kept it from running. It may be nil
+	Done func(cmd PipeCommand, exitCode uint32, err error)
+}
+
+// Serve accepts connections from l, a go-winio pipe listener, and serves each on its own goroutine until l is closed
+func (s *PipeCommandServer) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn runs the command requested on conn and closes it
+func (s *PipeCommandServer) ServeConn(conn net.Conn) {
+	defer conn.Close()
+
+	cmd, code, err := s.serve(conn)
+	if s.Done != nil {
+		s.Done(cmd, code, err)
+	}
+}
+
+// readPipeCommand reads the command line byte by byte, so nothing of the standard input that follows is consumed
+func readPipeCommand(r io.Reader) (PipeCommand, error) {
+	var (
+		cmd  PipeCommand
+		line []byte
+		b    [1]byte
+	)
+	for {
+		if _, err := r.Read(b[:]); err != nil {
+			return cmd, fmt.Errorf("error while reading the pipe command: %w", err)
+		}
+		if b[0] == '\n' {
+			break
+		}
+		if line = append(line, b[0]); len(line) > maxPipeCommandSize {
+			return cmd, ErrPipeCommandTooLong
+		}
+	}
+	if err := json.Unmarshal(line, &cmd); err != nil {
+		return cmd, fmt.Errorf("error while decoding the pipe command: %w", err)
+	}
+	return cmd, nil
+}
+
+func (s *PipeCommandServer) serve(conn net.Conn) (PipeCommand, uint32, error) {
+	cmd, err := readPipeCommand(conn)
+	if err != nil {
+		return cmd, 0, err
+	}
+
+	// the client has written to the pipe, so it can be impersonated
+	t, err := GetNamedPipeClientToken(conn, TokenPrimary)
+	if err != nil {
+		return cmd, 0, err
+	}
+	defer t.Close()
+
+	if s.Authorize != nil {
+		if err := s.Authorize(t, cmd); err != nil {
+			return cmd, 0, err
+		}
+	}
+
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return cmd, 0, err
+	}
+	defer stdinR.Close()
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinW.Close()
+		return cmd, 0, err
+	}
+	defer stdoutR.Close()
+
+	opts := s.Options
+	opts.Stdin = windows.Handle(stdinR.Fd())
+	opts.Stdout = windows.Handle(stdoutW.Fd())
+	opts.Stderr = windows.Handle(stdoutW.Fd())
+	if opts.Dir == "" {
+		opts.Dir = cmd.Dir
+	}
+	p, err := t.StartProcess(cmd.Exe, cmd.Args, &opts)
+	// the process holds its own copies of the pipe ends
+	stdoutW.Close()
+	if err != nil {
+		stdinW.Close()
+		return cmd, 0, err
+	}
+
+	go func() {
+		io.Copy(stdinW, conn)
+		stdinW.Close()
+	}()
+	io.Copy(conn, stdoutR)
+
+	code, err := p.Wait()
+	// unblocks the standard input relay if the client keeps the pipe open
+	stdinW.Close()
+	return cmd, code, err
+}
@@ -0,0 +1,192 @@
+package wintoken
+
+import "strings"
+
+// Privilege is the name of a Windows privilege, such as SeDebugPrivilege
+type Privilege string
+
+const (
+	SeAssignPrimaryTokenPrivilege             Privilege = "SeAssignPrimaryTokenPrivilege"
+	SeAuditPrivilege                          Privilege = "SeAuditPrivilege"
+	SeBackupPrivilege                         Privilege = "SeBackupPrivilege"
+	SeChangeNotifyPrivilege                   Privilege = "SeChangeNotifyPrivilege"
+	SeCreateGlobalPrivilege                   Privilege = "SeCreateGlobalPrivilege"
+	SeCreatePagefilePrivilege                 Privilege = "SeCreatePagefilePrivilege"
+	SeCreatePermanentPrivilege                Privilege = "SeCreatePermanentPrivilege"
+	SeCreateSymbolicLinkPrivilege             Privilege = "SeCreateSymbolicLinkPrivilege"
+	SeCreateTokenPrivilege                    Privilege = "SeCreateTokenPrivilege"
+	SeDebugPrivilege                          Privilege = "SeDebugPrivilege"
+	SeDelegateSessionUserImpersonatePrivilege Privilege = "SeDelegateSessionUserImpersonatePrivilege"
+	SeEnableDelegationPrivilege               Privilege = "SeEnableDelegationPrivilege"
+	SeImpersonatePrivilege                    Privilege = "SeImpersonatePrivilege"
+	SeIncreaseBasePriorityPrivilege           Privilege = "SeIncreaseBasePriorityPrivilege"
+	SeIncreaseQuotaPrivilege                  Privilege = "SeIncreaseQuotaPrivilege"
+	SeIncreaseWorkingSetPrivilege             Privilege = "SeIncreaseWorkingSetPrivilege"
+	SeLoadDriverPrivilege                     Privilege = "SeLoadDriverPrivilege"
+	SeLockMemoryPrivilege                     Privilege = "SeLockMemoryPrivilege"
+	SeMachineAccountPrivilege                 Privilege = "SeMachineAccountPrivilege"
+	SeManageVolumePrivilege                   Privilege = "SeManageVolumePrivilege"
+	SeProfileSingleProcessPrivilege           Privilege = "SeProfileSingleProcessPrivilege"
+	SeRelabelPrivilege                        Privilege = "SeRelabelPrivilege"
+	SeRemoteShutdownPrivilege                 Privilege = "SeRemoteShutdownPrivilege"
+	SeRestorePrivilege                        Privilege = "SeRestorePrivilege"
+	SeSecurityPrivilege                       Privilege = "SeSecurityPrivilege"
+	SeShutdownPrivilege                       Privilege = "SeShutdownPrivilege"
+	SeSyncAgentPrivilege                      Privilege = "SeSyncAgentPrivilege"
+	SeSystemEnvironmentPrivilege              Privilege = "SeSystemEnvironmentPrivilege"
+	SeSystemProfilePrivilege                  Privilege = "SeSystemProfilePrivilege"
+	SeSystemtimePrivilege                     Privilege = "SeSystemtimePrivilege"
+	SeTakeOwnershipPrivilege                  Privilege = "SeTakeOwnershipPrivilege"
+	SeTcbPrivilege                            Privilege = "SeTcbPrivilege"
+	SeTimeZonePrivilege                       Privilege = "SeTimeZonePrivilege"
+	SeTrustedCredManAccessPrivilege           Privilege = "SeTrustedCredManAccessPrivilege"
+	SeUndockPrivilege                         Privilege = "SeUndockPrivilege"
+)
+
+// AllPrivileges lists every privilege known to the package
+var AllPrivileges = []Privilege{
+	SeAssignPrimaryTokenPrivilege,
+	SeAuditPrivilege,
+	SeBackupPrivilege,
+	SeChangeNotifyPrivilege,
+	SeCreateGlobalPrivilege,
+	SeCreatePagefilePrivilege,
+	SeCreatePermanentPrivilege,
+	SeCreateSymbolicLinkPrivilege,
+	SeCreateTokenPrivilege,
+	SeDebugPrivilege,
+	SeDelegateSessionUserImpersonatePrivilege,
+	SeEnableDelegationPrivilege,
+	SeImpersonatePrivilege,
+	SeIncreaseBasePriorityPrivilege,
+	SeIncreaseQuotaPrivilege,
+	SeIncreaseWorkingSetPrivilege,
+	SeLoadDriverPrivilege,
+	SeLockMemoryPrivilege,
+	SeMachineAccountPrivilege,
+	SeManageVolumePrivilege,
+	SeProfileSingleProcessPrivilege,
+	SeRelabelPrivilege,
+	SeRemoteShutdownPrivilege,
+	SeRestorePrivilege,
+	SeSecurityPrivilege,
+	SeShutdownPrivilege,
+	SeSyncAgentPrivilege,
+	SeSystemEnvironmentPrivilege,
+	SeSystemProfilePrivilege,
+	SeSystemtimePrivilege,
+	SeTakeOwnershipPrivilege,
+	SeTcbPrivilege,
+	SeTimeZonePrivilege,
+	SeTrustedCredManAccessPrivilege,
+	SeUndockPrivilege,
+}
+
+func (p Privilege) String() string {
+	return string(p)
+}
+
+// DisplayName returns the localized display name of the privilege, see PrivilegeDisplayName
+func (p Privilege) DisplayName() (string, error) {
+	return PrivilegeDisplayName(string(p))
+}
+
+// PrivilegeState is the state of a privilege in a token
+type PrivilegeState int
+
+const (
+	// PrivilegeAbsent means the privilege is not assigned to the token and cannot be enabled
+	PrivilegeAbsent PrivilegeState = iota
+	// PrivilegeDisabled means the privilege is assigned to the token but must be enabled before use
+	PrivilegeDisabled
+	PrivilegeEnabled
+	PrivilegeRemoved
+)
+
+func (s PrivilegeState) String() string {
+	switch s {
+	case PrivilegeAbsent:
+		return "Absent"
+	case PrivilegeDisabled:
+		return "Disabled"
+	case PrivilegeEnabled:
+		return "Enabled"
+	case PrivilegeRemoved:
+		return "Removed"
+	default:
+		return "Unknown"
+	}
+}
+
+// State returns the state of the privilege decoded from its attributes
+func (p PrivilegeDetail) State() PrivilegeState {
+	switch {
+	case p.Removed:
+		return PrivilegeRemoved
+	case p.Enabled:
+		return PrivilegeEnabled
+	default:
+		return PrivilegeDisabled
+	}
+}
+
+// PrivilegeState returns the state of a single privilege, PrivilegeAbsent if the token does not hold it
+func (t *Token) PrivilegeState(priv Privilege) (PrivilegeState, error) {
+	privs, err := t.GetPrivileges()
+	if err != nil {
+		return PrivilegeAbsent, err
+	}
+
+	for _, p := range privs {
+		if strings.EqualFold(string(p.Name), string(priv)) {
+			return p.State(), nil
+		}
+	}
+	return PrivilegeAbsent, nil
+}
+
+// DisabledPrivileges lists the privileges assigned to the token but currently disabled. A process running with the token
+// can enable them itself with AdjustTokenPrivileges, so they show how far it could raise its own capabilities
+func (t *Token) DisabledPrivileges() ([]PrivilegeDetail, error) {
+	privs, err := t.GetPrivileges()
+	if err != nil {
+		return nil, err
+	}
+
+	var disabled []PrivilegeDetail
+	for _, p := range privs {
+		if p.State() == PrivilegeDisabled {
+			disabled = append(disabled, p)
+		}
+	}
+	return disabled, nil
+}
+
+// EnableDisabledPrivileges enables the disabled privileges of the token, or only those of them listed in privs.
+// Listed privileges which are not assigned to the token are reported in PrivilegeResult.NotHeld,
+// ones which are already enabled are left alone
+func (t *Token) EnableDisabledPrivileges(privs ...Privilege) (*PrivilegeResult, error) {
+	held, err := t.GetPrivileges()
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]PrivilegeState, len(held))
+	var enable []Privilege
+	for _, p := range held {
+		states[strings.ToLower(string(p.Name))] = p.State()
+		if len(privs) == 0 && p.State() == PrivilegeDisabled {
+			enable = append(enable, p.Name)
+		}
+	}
+	for _, priv := range privs {
+		if states[strings.ToLower(string(priv))] != PrivilegeEnabled {
+			enable = append(enable, priv)
+		}
+	}
+
+	if len(enable) == 0 {
+		return &PrivilegeResult{mode: PrivEnable}, nil
+	}
+	return t.EnableTokenPrivileges(enable...)
+}
@@ -0,0 +1,99 @@
+package wintoken
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// processEntry is a process found in a toolhelp snapshot
+type processEntry struct {
+	pid  uint32
+	ppid uint32
+	exe  string
+}
+
+// enumerateProcesses lists running processes using CreateToolhelp32Snapshot
+func enumerateProcesses() ([]processEntry, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPPROCESS, 0)
+	if err != nil {
+		return nil, fmt.Errorf("error while CreateToolhelp32Snapshot: %w", err)
+	}
+	defer windows.CloseHandle(snapshot)
+
+	var (
+		entry     windows.ProcessEntry32
+		processes []processEntry
+	)
+	entry.Size = uint32(unsafe.Sizeof(entry))
+
+	if err := windows.Process32First(snapshot, &entry); err != nil {
+		return nil, fmt.Errorf("error while Process32First: %w", err)
+	}
+	for {
+		processes = append(processes, processEntry{
+			pid:  entry.ProcessID,
+			ppid: entry.ParentProcessID,
+			exe:  windows.UTF16ToString(entry.ExeFile[:]),
+		})
+
+		if err := windows.Process32Next(snapshot, &entry); err != nil {
+			if err == windows.ERROR_NO_MORE_FILES {
+				break
+			}
+			return nil, fmt.Errorf("error while Process32Next: %w", err)
+		}
+	}
+
+	return processes, nil
+}
+
+// findProcessToken opens the token of the first process accepted by match and duplicates it.
+// Processes whose token cannot be opened or duplicated, or is outside the integrity range of o, are skipped
+func findProcessToken(match func(p processEntry, t windows.Token) bool, tokenType tokenType, o options) (*Token, error) {
+	start := time.Now()
+
+	processes, err := enumerateProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range processes {
+		if p.pid == 0 {
+			continue
+		}
+
+		t, err := openProcessToken(int(p.pid), windows.TOKEN_QUERY|windows.TOKEN_DUPLICATE)
+		if err != nil {
+			continue
+		}
+
+		if il, err := tokenIntegrity(t); err != nil || !o.acceptsIntegrity(il) || !match(p, t) {
+			windows.CloseHandle(windows.Handle(t))
+			continue
+		}
+
+		duplicatedToken, err := duplicateToken(t, tokenType, o)
+		windows.CloseHandle(windows.Handle(t))
+		if err != nil {
+			continue
+		}
+		audit(AuditStealToken, start, int(p.pid), 0, duplicatedToken, nil)
+		return newToken(duplicatedToken, tokenType).withOptions(o), nil
+	}
+
+	audit(AuditStealToken, start, 0, 0, 0, ErrNoMatchingProcess)
+	return nil, ErrNoMatchingProcess
+}
+
+// tokenUserSID returns the string SID of the token user, or an empty string if it cannot be queried
+func tokenUserSID(t windows.Token) string {
+	var sid string
+	withTokenInfo(t, windows.TokenUser, func(b []byte) error {
+		sid = (*windows.Tokenuser)(unsafe.Pointer(&b[0])).User.Sid.String()
+		return nil
+	})
+	return sid
+}
@@ -0,0 +1,71 @@
+// Package prometheus exposes the metrics of wintoken operations as Prometheus collectors.
+//
+//	m := prometheus.New("agent")
+//	prom.MustRegister(m)
+//	wintoken.SetMetrics(m)
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+
+	"github.com/fourcorelabs/wintoken"
+)
+
+// Metrics implements wintoken.Metrics and prometheus.Collector
+type Metrics struct {
+	operations *prom.CounterVec
+	failures   *prom.CounterVec
+	duration   *prom.HistogramVec
+}
+
+// New creates the collectors, their names are prefixed with namespace if it is not empty:
+// wintoken_operations_total, wintoken_failures_total and wintoken_operation_duration_seconds
+func New(namespace string) *Metrics {
+	return &Metrics{
+		operations: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "wintoken",
+			Name:      "operations_total",
+			Help:      "Number of token operations by operation.",
+		}, []string{"operation"}),
+		failures: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "wintoken",
+			Name:      "failures_total",
+			Help:      "Number of failed token operations by operation and error class.",
+		}, []string{"operation", "class"}),
+		duration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "wintoken",
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of token operations, including process launches, by operation.",
+			Buckets:   []float64{.0005, .001, .005, .01, .05, .1, .5, 1, 5},
+		}, []string{"operation"}),
+	}
+}
+
+func (m *Metrics) IncOperation(op wintoken.AuditOperation) {
+	m.operations.WithLabelValues(string(op)).Inc()
+}
+
+func (m *Metrics) IncFailure(op wintoken.AuditOperation, class wintoken.ErrorClass) {
+	m.failures.WithLabelValues(string(op), string(class)).Inc()
+}
+
+func (m *Metrics) ObserveDuration(op wintoken.AuditOperation, d time.Duration) {
+	m.duration.WithLabelValues(string(op)).Observe(d.Seconds())
+}
+
+func (m *Metrics) Describe(ch chan<- *prom.Desc) {
+	m.operations.Describe(ch)
+	m.failures.Describe(ch)
+	m.duration.Describe(ch)
+}
+
+func (m *Metrics) Collect(ch chan<- prom.Metric) {
+	m.operations.Collect(ch)
+	m.failures.Collect(ch)
+	m.duration.Collect(ch)
+}
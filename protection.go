@@ -0,0 +1,114 @@
+package wintoken
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ProtectionType is the kind of protection of a process, from the PS_PROTECTION structure
+type ProtectionType uint8
+
+const (
+	ProtectionNone ProtectionType = iota
+	// ProtectionLight is a Protected Process Light (PPL) such as antimalware services or lsass.exe with RunAsPPL
+	ProtectionLight
+	// ProtectionFull is a Protected Process (PP) such as the media and system processes
+	ProtectionFull
+)
+
+func (p ProtectionType) String() string {
+	switch p {
+	case ProtectionNone:
+		return "None"
+	case ProtectionLight:
+		return "PPL"
+	case ProtectionFull:
+		return "PP"
+	default:
+		return "Unknown"
+	}
+}
+
+// ProtectionSigner is the signer level required from code loaded in a protected process
+type ProtectionSigner uint8
+
+const (
+	SignerNone ProtectionSigner = iota
+	SignerAuthenticode
+	SignerCodeGen
+	SignerAntimalware
+	SignerLsa
+	SignerWindows
+	SignerWinTcb
+	SignerWinSystem
+	SignerApp
+)
+
+var signerNames = [...]string{"None", "Authenticode", "CodeGen", "Antimalware", "Lsa", "Windows", "WinTcb", "WinSystem", "App"}
+
+func (s ProtectionSigner) String() string {
+	if int(s) < len(signerNames) {
+		return signerNames[s]
+	}
+	return "Unknown"
+}
+
+// ProcessProtection is the protection level of a process
+type ProcessProtection struct {
+	Type   ProtectionType
+	Signer ProtectionSigner
+}
+
+// Protected reports whether the process is a PP or PPL
+func (p ProcessProtection) Protected() bool {
+	return p.Type != ProtectionNone
+}
+
+func (p ProcessProtection) String() string {
+	if !p.Protected() {
+		return "None"
+	}
+	return fmt.Sprintf("%s-%s", p.Type, p.Signer)
+}
+
+// GetProcessProtection returns the protection level of a process using NtQueryInformationProcess.
+// It only needs PROCESS_QUERY_LIMITED_INFORMATION, which is granted on protected processes
+func GetProcessProtection(pid int) (ProcessProtection, error) {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return ProcessProtection{}, fmt.Errorf("error while OpenProcess: %w", err)
+	}
+	defer windows.CloseHandle(h)
+
+	return processProtection(h)
+}
+
+// processProtection queries the protection level of an open process handle
+func processProtection(h windows.Handle) (ProcessProtection, error) {
+	var protection uint8
+	if err := windows.NtQueryInformationProcess(h, windows.ProcessProtectionInformation, unsafe.Pointer(&protection), 1, nil); err != nil {
+		return ProcessProtection{}, fmt.Errorf("error while NtQueryInformationProcess: %w", err)
+	}
+
+	return ProcessProtection{
+		Type:   ProtectionType(protection & 0x7),
+		Signer: ProtectionSigner(protection >> 4),
+	}, nil
+}
+
+// protectedProcessError explains an ACCESS_DENIED raised while opening a process or its token,
+// it returns err unchanged if the process is not protected or its protection cannot be queried
+func protectedProcessError(pid int, err error) error {
+	if !errors.Is(err, windows.ERROR_ACCESS_DENIED) {
+		return err
+	}
+
+	protection, perr := GetProcessProtection(pid)
+	if perr != nil || !protection.Protected() {
+		return err
+	}
+	return fmt.Errorf("process %d is a %s protected process: %w", pid, protection, ErrProtectedProcess)
+}
@@ -0,0 +1,25 @@
+package wintoken
+
+import "golang.org/x/sys/windows"
+
+// CurrentProcessToken returns the token of the current process through the GetCurrentProcessToken pseudo-handle,
+// without the OpenProcessToken and DuplicateTokenEx round-trips of OpenProcessToken(0, ...). The pseudo-handle is
+// only good for queries, such as GetPrivileges, IntegrityLevel or QueryRaw, anything that modifies or duplicates
+// the token fails with ERROR_INVALID_HANDLE or ERROR_ACCESS_DENIED. UserDetails, which builds the environment block
+// and reads the profile directory, needs the token from OpenProcessToken(0, ...). It needs Windows 8 or later.
+// Closing the Token is not required but allowed
+func CurrentProcessToken() *Token {
+	return FromHandle(windows.GetCurrentProcessToken(), TokenPrimary, false)
+}
+
+// CurrentThreadEffectiveToken returns the token the current thread runs with through the
+// GetCurrentThreadEffectiveToken pseudo-handle: the impersonation token while the thread impersonates, the process
+// token otherwise. The pseudo-handle is resolved on every call, so the Token must be used on the thread it applies to,
+// see CurrentProcessToken for its restrictions
+func CurrentThreadEffectiveToken() *Token {
+	typ := TokenPrimary
+	if windowsType, err := tokenTypeOf(windows.GetCurrentThreadEffectiveToken()); err == nil && windowsType == windows.TokenImpersonation {
+		typ = TokenImpersonation
+	}
+	return FromHandle(windows.GetCurrentThreadEffectiveToken(), typ, false)
+}
@@ -0,0 +1,29 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+var (
+	procRegOpenCurrentUser = modadvapi32.NewProc("RegOpenCurrentUser")
+)
+
+// OpenCurrentUserKey opens the HKEY_CURRENT_USER hive of the token's user with RegOpenCurrentUser while impersonating
+// the token, for per-user configuration management from services. access takes the registry.* access rights.
+// The hive is only available while the user's profile is loaded, typically while the user is logged on, otherwise
+// Windows falls back to HKEY_USERS\.DEFAULT. The returned key must be closed by the caller
+func (t *Token) OpenCurrentUserKey(access uint32) (registry.Key, error) {
+	var key registry.Key
+	err := t.RunAs(func() error {
+		r1, _, _ := procRegOpenCurrentUser.Call(uintptr(access), uintptr(unsafe.Pointer(&key)))
+		if r1 != 0 {
+			return fmt.Errorf("error while RegOpenCurrentUser: %w", windows.Errno(r1))
+		}
+		return nil
+	})
+	return key, err
+}
@@ -0,0 +1,170 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// reportTable renders rows in the column layout used by whoami
+type reportTable struct {
+	headers []string
+	rows    [][]string
+}
+
+func (r *reportTable) add(row ...string) {
+	r.rows = append(r.rows, row)
+}
+
+func (r *reportTable) write(sb *strings.Builder) {
+	widths := make([]int, len(r.headers))
+	for i, h := range r.headers {
+		widths[i] = len(h)
+	}
+	for _, row := range r.rows {
+		for i, c := range row {
+			if len(c) > widths[i] {
+				widths[i] = len(c)
+			}
+		}
+	}
+
+	writeRow := func(cells []string) {
+		for i, c := range cells {
+			if i == len(cells)-1 {
+				sb.WriteString(c)
+			} else {
+				fmt.Fprintf(sb, "%-*s ", widths[i], c)
+			}
+		}
+		sb.WriteString("\n")
+	}
+
+	writeRow(r.headers)
+	underline := make([]string, len(widths))
+	for i, w := range widths {
+		underline[i] = strings.Repeat("=", w)
+	}
+	writeRow(underline)
+	for _, row := range r.rows {
+		writeRow(row)
+	}
+}
+
+func reportSection(sb *strings.Builder, title string) {
+	fmt.Fprintf(sb, "\n%s\n%s\n\n", title, strings.Repeat("-", len(title)))
+}
+
+func sidTypeName(typ uint32) string {
+	switch typ {
+	case windows.SidTypeUser:
+		return "User"
+	case windows.SidTypeGroup:
+		return "Group"
+	case windows.SidTypeAlias:
+		return "Alias"
+	case windows.SidTypeWellKnownGroup:
+		return "Well-known group"
+	case windows.SidTypeLabel:
+		return "Label"
+	default:
+		return "Unknown SID type"
+	}
+}
+
+// groupAttributeNames describes group attributes the way whoami does
+func groupAttributeNames(attributes uint32) string {
+	var names []string
+	if attributes&windows.SE_GROUP_MANDATORY != 0 {
+		names = append(names, "Mandatory group")
+	}
+	if attributes&windows.SE_GROUP_ENABLED_BY_DEFAULT != 0 {
+		names = append(names, "Enabled by default")
+	}
+	if attributes&windows.SE_GROUP_ENABLED != 0 {
+		names = append(names, "Enabled group")
+	}
+	if attributes&windows.SE_GROUP_OWNER != 0 {
+		names = append(names, "Group owner")
+	}
+	if attributes&windows.SE_GROUP_USE_FOR_DENY_ONLY != 0 {
+		names = append(names, "Group used for deny only")
+	}
+	if attributes&windows.SE_GROUP_RESOURCE != 0 {
+		names = append(names, "Local Group")
+	}
+	return strings.Join(names, ", ")
+}
+
+// Report renders the user, groups, privileges and claims of the token in the layout of `whoami /all`
+func (t *Token) Report() (string, error) {
+	if err := t.acquire(); err != nil {
+		return "", err
+	}
+	defer t.release()
+
+	var sb strings.Builder
+
+	tu, err := t.token.GetTokenUser()
+	if err != nil {
+		return "", fmt.Errorf("error while getting token user: %w", err)
+	}
+	reportSection(&sb, "USER INFORMATION")
+	users := reportTable{headers: []string{"User Name", "SID"}}
+	users.add(strings.ToLower(accountName(tu.User.Sid)), tu.User.Sid.String())
+	users.write(&sb)
+
+	groups, err := t.token.GetTokenGroups()
+	if err != nil {
+		return "", fmt.Errorf("error while getting token groups: %w", err)
+	}
+	reportSection(&sb, "GROUP INFORMATION")
+	groupTable := reportTable{headers: []string{"Group Name", "Type", "SID", "Attributes"}}
+	for _, g := range groups.AllGroups() {
+		if g.Attributes&windows.SE_GROUP_LOGON_ID == windows.SE_GROUP_LOGON_ID {
+			continue
+		}
+		name, typ := g.Sid.String(), "Unknown SID type"
+		if account, err := LookupAccountSID(g.Sid); err == nil {
+			name, typ = account.String(), sidTypeName(account.Type)
+		}
+		groupTable.add(name, typ, g.Sid.String(), groupAttributeNames(g.Attributes))
+	}
+	groupTable.write(&sb)
+
+	privs, err := t.GetPrivileges()
+	if err != nil {
+		return "", err
+	}
+	reportSection(&sb, "PRIVILEGES INFORMATION")
+	privTable := reportTable{headers: []string{"Privilege Name", "Description", "State"}}
+	for _, p := range privs {
+		state := "Disabled"
+		if p.Enabled {
+			state = "Enabled"
+		}
+		privTable.add(string(p.Name), p.Description, state)
+	}
+	privTable.write(&sb)
+
+	reportSection(&sb, "USER CLAIMS INFORMATION")
+	b, err := t.info(tokenUserClaimAttributes)
+	claims := parseClaims(b)
+	switch {
+	case err != nil || len(claims) == 0:
+		sb.WriteString("User claims unknown.\n")
+	default:
+		claimTable := reportTable{headers: []string{"Claim Name", "Values"}}
+		for _, c := range claims {
+			values := make([]string, len(c.Values))
+			for i, v := range c.Values {
+				values[i] = fmt.Sprint(v)
+			}
+			claimTable.add(c.Name, strings.Join(values, ", "))
+		}
+		claimTable.write(&sb)
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,103 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procCreateRestrictedToken = modadvapi32.NewProc("CreateRestrictedToken")
+)
+
+// CreateRestrictedToken flags
+const (
+	disableMaxPrivilege = 0x1
+	writeRestricted     = 0x8
+)
+
+// RestrictedTokenOptions selects what CreateRestrictedToken strips from a token
+type RestrictedTokenOptions struct {
+	// DisableMaxPrivilege deletes every privilege except SeChangeNotifyPrivilege
+	DisableMaxPrivilege bool
+	// DeletePrivileges are the names of privileges to delete from the token
+	DeletePrivileges []Privilege
+	// DisableSIDs are user or group SIDs turned into deny-only SIDs
+	DisableSIDs []*windows.SID
+	// RestrictingSIDs are checked in a second access check, access is only granted if both checks pass
+	RestrictingSIDs []*windows.SID
+	// WriteRestricted only applies the restricting SIDs to write access checks, it requires RestrictingSIDs
+	WriteRestricted bool
+}
+
+func sidsAndAttributes(sids []*windows.SID) []windows.SIDAndAttributes {
+	sa := make([]windows.SIDAndAttributes, len(sids))
+	for i, sid := range sids {
+		sa[i].Sid = sid
+	}
+	return sa
+}
+
+// CreateRestrictedToken creates a restricted copy of the token using CreateRestrictedToken.
+// The new token has the same type as the original one
+func (t *Token) CreateRestrictedToken(opts RestrictedTokenOptions) (*Token, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	var flags uint32
+	if opts.DisableMaxPrivilege {
+		flags |= disableMaxPrivilege
+	}
+	if opts.WriteRestricted {
+		// without restricting SIDs the flag is silently ignored and the token is not restricted at all
+		if len(opts.RestrictingSIDs) == 0 {
+			return nil, ErrNoRestrictingSIDs
+		}
+		flags |= writeRestricted
+	}
+
+	privs := make([]windows.LUIDAndAttributes, len(opts.DeletePrivileges))
+	for i, p := range opts.DeletePrivileges {
+		if err := windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr(string(p)), &privs[i].Luid); err != nil {
+			return nil, fmt.Errorf("LookupPrivilegeValueW failed: %w", err)
+		}
+	}
+
+	disable := sidsAndAttributes(opts.DisableSIDs)
+	restrict := sidsAndAttributes(opts.RestrictingSIDs)
+
+	var (
+		disablePtr, privsPtr, restrictPtr uintptr
+		restrictedToken                   windows.Token
+	)
+	if len(disable) > 0 {
+		disablePtr = uintptr(unsafe.Pointer(&disable[0]))
+	}
+	if len(privs) > 0 {
+		privsPtr = uintptr(unsafe.Pointer(&privs[0]))
+	}
+	if len(restrict) > 0 {
+		restrictPtr = uintptr(unsafe.Pointer(&restrict[0]))
+	}
+
+	r1, _, err := procCreateRestrictedToken.Call(
+		uintptr(t.token),
+		uintptr(flags),
+		uintptr(len(disable)), disablePtr,
+		uintptr(len(privs)), privsPtr,
+		uintptr(len(restrict)), restrictPtr,
+		uintptr(unsafe.Pointer(&restrictedToken)),
+	)
+	runtime.KeepAlive(disable)
+	runtime.KeepAlive(privs)
+	runtime.KeepAlive(restrict)
+	if r1 == 0 {
+		return nil, fmt.Errorf("error while CreateRestrictedToken: %w", err)
+	}
+
+	return newToken(restrictedToken, t.typ), nil
+}
@@ -0,0 +1,221 @@
+package wintoken
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Severity rates how much a finding about a token exposes the system
+type Severity int
+
+const (
+	SeverityLow Severity = iota + 1
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityLow:
+		return "Low"
+	case SeverityMedium:
+		return "Medium"
+	case SeverityHigh:
+		return "High"
+	case SeverityCritical:
+		return "Critical"
+	default:
+		return "None"
+	}
+}
+
+type privilegeRisk struct {
+	severity Severity
+	reason   string
+}
+
+// dangerousPrivileges are the privileges which let their holder take over the machine or bypass access checks
+var dangerousPrivileges = map[Privilege]privilegeRisk{
+	SeTcbPrivilege:                {SeverityCritical, "act as part of the operating system, create arbitrary logons"},
+	SeCreateTokenPrivilege:        {SeverityCritical, "create tokens with any user, groups and privileges"},
+	SeAssignPrimaryTokenPrivilege: {SeverityCritical, "start processes with any primary token"},
+	SeImpersonatePrivilege:        {SeverityCritical, "impersonate clients, escalates to SYSTEM with potato attacks"},
+	SeDebugPrivilege:              {SeverityCritical, "open any process and steal its token"},
+	SeLoadDriverPrivilege:         {SeverityCritical, "load kernel drivers"},
+	SeRestorePrivilege:            {SeverityHigh, "write any file or registry key regardless of its DACL"},
+	SeBackupPrivilege:             {SeverityHigh, "read any file or registry key, including the SAM and SECURITY hives"},
+	SeTakeOwnershipPrivilege:      {SeverityHigh, "take ownership of any securable object"},
+	SeRelabelPrivilege:            {SeverityHigh, "raise the integrity level of objects"},
+	SeManageVolumePrivilege:       {SeverityMedium, "raw volume access"},
+	SeSecurityPrivilege:           {SeverityMedium, "read and clear the security log, edit SACLs"},
+	SeSystemEnvironmentPrivilege:  {SeverityMedium, "modify firmware environment variables"},
+}
+
+// DangerousPrivilege is a high-risk privilege held by a token, see Token.DangerousPrivileges
+type DangerousPrivilege struct {
+	PrivilegeDetail
+	Severity Severity
+	// Reason explains what the privilege allows
+	Reason string
+}
+
+func (d DangerousPrivilege) String() string {
+	return fmt.Sprintf("[%s] %s (%s): %s", d.Severity, d.Name, d.State(), d.Reason)
+}
+
+// DangerousPrivileges lists the high-risk privileges held by the token, enabled or not since a process can enable
+// its own privileges, sorted from the most severe. Enabled privileges come first within a severity
+func (t *Token) DangerousPrivileges() ([]DangerousPrivilege, error) {
+	privs, err := t.GetPrivileges()
+	if err != nil {
+		return nil, err
+	}
+
+	var dangerous []DangerousPrivilege
+	for _, p := range privs {
+		if p.Removed {
+			continue
+		}
+		for name, risk := range dangerousPrivileges {
+			if strings.EqualFold(string(p.Name), string(name)) {
+				dangerous = append(dangerous, DangerousPrivilege{PrivilegeDetail: p, Severity: risk.severity, Reason: risk.reason})
+				break
+			}
+		}
+	}
+
+	sort.Slice(dangerous, func(i, j int) bool {
+		if dangerous[i].Severity != dangerous[j].Severity {
+			return dangerous[i].Severity > dangerous[j].Severity
+		}
+		if dangerous[i].Enabled != dangerous[j].Enabled {
+			return dangerous[i].Enabled
+		}
+		return dangerous[i].Name < dangerous[j].Name
+	})
+	return dangerous, nil
+}
+
+// RiskFinding is one reason a token is exposed, see AssessToken
+type RiskFinding struct {
+	Severity    Severity
+	Description string
+}
+
+func (f RiskFinding) String() string {
+	return fmt.Sprintf("[%s] %s", f.Severity, f.Description)
+}
+
+// RiskReport scores the exposure of a token, Score goes from 0 to 100 and Severity is the worst finding
+type RiskReport struct {
+	Score    int
+	Severity Severity
+	Findings []RiskFinding
+}
+
+func (r RiskReport) String() string {
+	lines := []string{fmt.Sprintf("Risk: %d (%s)", r.Score, r.Severity)}
+	for _, f := range r.Findings {
+		lines = append(lines, "  "+f.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// severityScores is how much each finding adds to RiskReport.Score
+var severityScores = map[Severity]int{
+	SeverityLow:      5,
+	SeverityMedium:   10,
+	SeverityHigh:     25,
+	SeverityCritical: 40,
+}
+
+func (r *RiskReport) add(severity Severity, format string, a ...interface{}) {
+	r.Findings = append(r.Findings, RiskFinding{Severity: severity, Description: fmt.Sprintf(format, a...)})
+	if r.Score += severityScores[severity]; r.Score > 100 {
+		r.Score = 100
+	}
+	if severity > r.Severity {
+		r.Severity = severity
+	}
+}
+
+// privilegedDomainGroups are the RIDs of the domain groups which control the domain or forest
+var privilegedDomainGroups = map[string]string{
+	"512": "Domain Admins",
+	"518": "Schema Admins",
+	"519": "Enterprise Admins",
+}
+
+// AssessToken scores the exposure of a token from its user, elevation, integrity level, membership of Administrators
+// and privileged domain groups, and its dangerous privileges, so scanners can rank processes by the damage
+// a compromise of each would do. Findings are sorted from the most severe
+func AssessToken(t *Token) (RiskReport, error) {
+	var report RiskReport
+
+	if err := t.acquire(); err != nil {
+		return report, err
+	}
+	defer t.release()
+
+	user, err := t.token.GetTokenUser()
+	if err != nil {
+		return report, fmt.Errorf("error while getting token user: %w", err)
+	}
+	if user.User.Sid.IsWellKnown(windows.WinLocalSystemSid) {
+		report.add(SeverityCritical, "runs as SYSTEM")
+	}
+
+	if t.token.IsElevated() {
+		report.add(SeverityHigh, "token is elevated")
+	}
+
+	il, err := t.integrityLevel()
+	if err != nil {
+		return report, err
+	}
+	switch {
+	case il >= IntegritySystem:
+		report.add(SeverityCritical, "%s integrity level", il)
+	case il >= IntegrityHigh:
+		report.add(SeverityHigh, "%s integrity level", il)
+	}
+
+	groups, err := t.groupsInfo(windows.TokenGroups)
+	if err != nil {
+		return report, fmt.Errorf("error while getting token groups: %w", err)
+	}
+	for _, g := range groups {
+		switch {
+		case g.SID == "S-1-5-32-544" && g.Enabled && !g.DenyOnly:
+			report.add(SeverityHigh, "member of the local Administrators group")
+		case g.SID == "S-1-5-32-544" && g.DenyOnly:
+			report.add(SeverityLow, "filtered member of the local Administrators group, can elevate with UAC")
+		case strings.HasPrefix(g.SID, "S-1-5-21-") && !g.DenyOnly:
+			rid := g.SID[strings.LastIndex(g.SID, "-")+1:]
+			if name, ok := privilegedDomainGroups[rid]; ok {
+				report.add(SeverityCritical, "member of %s (%s)", name, g.Name)
+			}
+		}
+	}
+
+	privs, err := t.DangerousPrivileges()
+	if err != nil {
+		return report, err
+	}
+	for _, p := range privs {
+		state := "held"
+		if p.Enabled {
+			state = "enabled"
+		}
+		report.add(p.Severity, "%s %s: %s", p.Name, state, p.Reason)
+	}
+
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Severity > report.Findings[j].Severity
+	})
+	return report, nil
+}
@@ -0,0 +1,67 @@
+package wintoken
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modrpcrt4                = windows.NewLazySystemDLL("rpcrt4.dll")
+	procRpcImpersonateClient = modrpcrt4.NewProc("RpcImpersonateClient")
+	procRpcRevertToSelfEx    = modrpcrt4.NewProc("RpcRevertToSelfEx")
+)
+
+// RPCBindingHandle is a native RPC_BINDING_HANDLE, as received by RPC server stubs or passed over cgo.
+// A zero handle refers to the client of the call currently being serviced on this thread
+type RPCBindingHandle uintptr
+
+// RpcImpersonateClient impersonates the RPC client identified by binding on the current thread.
+// The calling goroutine is locked to its OS thread until RpcRevertToSelf is called
+func RpcImpersonateClient(binding RPCBindingHandle) error {
+	return guardEnter(func() error {
+		return rpcImpersonateClient(binding)
+	})
+}
+
+func rpcImpersonateClient(binding RPCBindingHandle) error {
+	if r1, _, _ := procRpcImpersonateClient.Call(uintptr(binding)); r1 != 0 {
+		return fmt.Errorf("error while RpcImpersonateClient: %w", windows.Errno(r1))
+	}
+	return nil
+}
+
+// RpcRevertToSelf stops impersonating the RPC client identified by binding and unlocks the goroutine from its thread.
+// It fails with ErrImpersonationThread when called from a thread RpcImpersonateClient was not called on
+func RpcRevertToSelf(binding RPCBindingHandle) error {
+	return guardExit("RpcRevertToSelf", func() error {
+		return rpcRevertToSelf(binding)
+	})
+}
+
+func rpcRevertToSelf(binding RPCBindingHandle) error {
+	if r1, _, _ := procRpcRevertToSelfEx.Call(uintptr(binding)); r1 != 0 {
+		return fmt.Errorf("error while RpcRevertToSelfEx: %w", windows.Errno(r1))
+	}
+	return nil
+}
+
+// GetRPCClientToken captures the token of the RPC client identified by binding
+func GetRPCClientToken(binding RPCBindingHandle, tokenType tokenType, opts ...Option) (*Token, error) {
+	return captureClientToken(func() error {
+		return rpcImpersonateClient(binding)
+	}, func() error {
+		return rpcRevertToSelf(binding)
+	}, tokenType, opts)
+}
+
+// RunAsRPCClient runs fn while impersonating the RPC client identified by binding, see Token.RunAs
+func RunAsRPCClient(binding RPCBindingHandle, fn func() error) error {
+	t, err := GetRPCClientToken(binding, TokenImpersonation)
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	return t.RunAs(fn)
+}
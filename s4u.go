@@ -0,0 +1,189 @@
+package wintoken
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procLsaConnectUntrusted            = modsecur32.NewProc("LsaConnectUntrusted")
+	procLsaRegisterLogonProcess        = modsecur32.NewProc("LsaRegisterLogonProcess")
+	procLsaDeregisterLogonProcess      = modsecur32.NewProc("LsaDeregisterLogonProcess")
+	procLsaLookupAuthenticationPackage = modsecur32.NewProc("LsaLookupAuthenticationPackage")
+	procLsaLogonUser                   = modsecur32.NewProc("LsaLogonUser")
+)
+
+const (
+	// s4uLogonMessage is both MsV1_0S4ULogon and KerbS4ULogon
+	s4uLogonMessage = 12
+	msv10Package    = "MICROSOFT_AUTHENTICATION_PACKAGE_V1_0"
+	kerberosPackage = "Kerberos"
+	lsaLogonNetwork = 3
+)
+
+// s4uLogon is the header of MSV1_0_S4U_LOGON and KERB_S4U_LOGON, which share their layout
+type s4uLogon struct {
+	messageType uint32
+	flags       uint32
+	user        windows.NTUnicodeString
+	domain      windows.NTUnicodeString
+}
+
+// quotaLimits is the QUOTA_LIMITS structure
+type quotaLimits struct {
+	pagedPoolLimit        uintptr
+	nonPagedPoolLimit     uintptr
+	minimumWorkingSetSize uintptr
+	maximumWorkingSetSize uintptr
+	pagefileLimit         uintptr
+	timeLimit             int64
+}
+
+// lsaConnect opens an LSA handle, a trusted one if the caller holds SeTcbPrivilege
+func lsaConnect() (windows.Handle, error) {
+	var h windows.Handle
+	if tcbAvailable() {
+		name, err := windows.NewNTString(defaultTokenSource)
+		if err != nil {
+			return 0, err
+		}
+		var mode uint32
+		if r1, _, _ := procLsaRegisterLogonProcess.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&h)), uintptr(unsafe.Pointer(&mode))); r1 == 0 {
+			return h, nil
+		}
+	}
+
+	if r1, _, _ := procLsaConnectUntrusted.Call(uintptr(unsafe.Pointer(&h))); r1 != 0 {
+		return 0, fmt.Errorf("error while LsaConnectUntrusted: %w", windows.NTStatus(r1))
+	}
+	return h, nil
+}
+
+// isLocalDomain reports whether domain names the local machine rather than an Active Directory domain
+func isLocalDomain(domain string) bool {
+	if domain == "" || domain == "." {
+		return true
+	}
+	host, err := os.Hostname()
+	return err == nil && strings.EqualFold(domain, host)
+}
+
+// newS4ULogon packs the S4U logon message and its strings in one buffer, as LsaLogonUser expects
+func newS4ULogon(user, domain string) []byte {
+	u := utf16.Encode([]rune(user))
+	d := utf16.Encode([]rune(domain))
+
+	header := unsafe.Sizeof(s4uLogon{})
+	b := make([]byte, header+uintptr(len(u)+len(d))*2)
+	msg := (*s4uLogon)(unsafe.Pointer(&b[0]))
+	msg.messageType = s4uLogonMessage
+	if len(u)+len(d) == 0 {
+		return b
+	}
+
+	strs := (*[1 << 16]uint16)(unsafe.Pointer(&b[header]))[: len(u)+len(d) : len(u)+len(d)]
+	copy(strs, u)
+	copy(strs[len(u):], d)
+	if len(u) > 0 {
+		msg.user = windows.NTUnicodeString{Length: uint16(len(u) * 2), MaximumLength: uint16(len(u) * 2), Buffer: &strs[0]}
+	}
+	if len(d) > 0 {
+		msg.domain = windows.NTUnicodeString{Length: uint16(len(d) * 2), MaximumLength: uint16(len(d) * 2), Buffer: &strs[len(u)]}
+	}
+	return b
+}
+
+// S4ULogon logs on user without a password with Service-for-User (S4U). Local accounts go through MSV1_0, domain
+// accounts through Kerberos, for which user is the UPN or the account name. groups are added to the token, which
+// requires SeTcbPrivilege. Without SeTcbPrivilege the token is only usable at SecurityIdentification level,
+// enough for access checks and GetGroups but not for impersonation or launching processes
+func S4ULogon(user, domain string, groups []windows.SIDAndAttributes, tokenType tokenType, opts ...Option) (*Token, error) {
+	start := time.Now()
+	if user == "" {
+		return nil, fmt.Errorf("S4U logon needs a user name")
+	}
+	lsa, err := lsaConnect()
+	if err != nil {
+		return nil, err
+	}
+	defer procLsaDeregisterLogonProcess.Call(uintptr(lsa))
+
+	pkgName := kerberosPackage
+	if isLocalDomain(domain) {
+		pkgName, domain = msv10Package, ""
+	}
+	pkgStr, err := windows.NewNTString(pkgName)
+	if err != nil {
+		return nil, err
+	}
+	var pkg uint32
+	if r1, _, _ := procLsaLookupAuthenticationPackage.Call(uintptr(lsa), uintptr(unsafe.Pointer(pkgStr)), uintptr(unsafe.Pointer(&pkg))); r1 != 0 {
+		return nil, fmt.Errorf("error while LsaLookupAuthenticationPackage %s: %w", pkgName, windows.NTStatus(r1))
+	}
+
+	origin, err := windows.NewNTString(defaultTokenSource)
+	if err != nil {
+		return nil, err
+	}
+	source, err := newTokenSource(defaultTokenSource)
+	if err != nil {
+		return nil, err
+	}
+
+	var localGroups *windows.Tokengroups
+	if len(groups) > 0 {
+		localGroups = newTokenGroups(groups)
+	}
+
+	var (
+		msg           = newS4ULogon(user, domain)
+		profile       uintptr
+		profileLength uint32
+		logonID       windows.LUID
+		h             windows.Token
+		quotas        quotaLimits
+		subStatus     uint32
+	)
+	r1, _, _ := procLsaLogonUser.Call(
+		uintptr(lsa),
+		uintptr(unsafe.Pointer(origin)),
+		lsaLogonNetwork,
+		uintptr(pkg),
+		uintptr(unsafe.Pointer(&msg[0])),
+		uintptr(len(msg)),
+		uintptr(unsafe.Pointer(localGroups)),
+		uintptr(unsafe.Pointer(source)),
+		uintptr(unsafe.Pointer(&profile)),
+		uintptr(unsafe.Pointer(&profileLength)),
+		uintptr(unsafe.Pointer(&logonID)),
+		uintptr(unsafe.Pointer(&h)),
+		uintptr(unsafe.Pointer(&quotas)),
+		uintptr(unsafe.Pointer(&subStatus)),
+	)
+	// the SIDs referenced from the group buffer are not visible to the garbage collector
+	runtime.KeepAlive(groups)
+	if r1 != 0 {
+		err := fmt.Errorf("error while LsaLogonUser S4U %s: %w", user, windows.NTStatus(r1))
+		auditLogon(start, user, domain, LogonNetwork, 0, err)
+		return nil, err
+	}
+	if profile != 0 {
+		procLsaFreeReturnBuffer.Call(profile)
+	}
+	defer windows.CloseHandle(windows.Handle(h))
+	auditLogon(start, user, domain, LogonNetwork, h, nil)
+
+	o := newOptions(opts)
+	duplicatedToken, err := duplicateToken(h, tokenType, o)
+	if err != nil {
+		return nil, err
+	}
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
+}
@@ -0,0 +1,84 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procSaferCreateLevel           = modadvapi32.NewProc("SaferCreateLevel")
+	procSaferComputeTokenFromLevel = modadvapi32.NewProc("SaferComputeTokenFromLevel")
+	procSaferCloseLevel            = modadvapi32.NewProc("SaferCloseLevel")
+)
+
+const (
+	saferScopeIDMachine = 1
+	saferLevelOpen      = 1
+)
+
+// SaferLevel is a software restriction policy (Safer) level, from the least to the most trusted
+type SaferLevel uint32
+
+const (
+	// SaferUntrusted only grants access to resources open to well-known groups, not the rights granted to the user
+	// personally, most applications fail to start
+	SaferUntrusted SaferLevel = 0x01000
+	// SaferConstrained is SaferNormalUser which also cannot access resources such as the cryptographic keys
+	// and credentials of the user
+	SaferConstrained SaferLevel = 0x10000
+	// SaferNormalUser removes the administrator groups and privileges, the token of a standard user
+	SaferNormalUser SaferLevel = 0x20000
+	// SaferFullyTrusted leaves the token unchanged
+	SaferFullyTrusted SaferLevel = 0x40000
+)
+
+func (l SaferLevel) String() string {
+	switch l {
+	case SaferUntrusted:
+		return "Untrusted"
+	case SaferConstrained:
+		return "Constrained"
+	case SaferNormalUser:
+		return "Normal User"
+	case SaferFullyTrusted:
+		return "Fully Trusted"
+	default:
+		return "Unknown"
+	}
+}
+
+// SaferToken computes the token of a Safer level from the token with SaferComputeTokenFromLevel, for example the
+// "Normal User" token of an administrator to run an untrusted installer, in one call instead of choosing the groups
+// and privileges of a restricted token by hand. The new token has the same type and options, such as WithInfoCache,
+// as the original one
+func (t *Token) SaferToken(level SaferLevel) (*Token, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	var h windows.Handle
+	if r1, _, err := procSaferCreateLevel.Call(saferScopeIDMachine, uintptr(level), saferLevelOpen, uintptr(unsafe.Pointer(&h)), 0); r1 == 0 {
+		return nil, fmt.Errorf("error while SaferCreateLevel %s: %w", level, err)
+	}
+	defer procSaferCloseLevel.Call(uintptr(h))
+
+	var st windows.Token
+	if r1, _, err := procSaferComputeTokenFromLevel.Call(uintptr(h), uintptr(t.token), uintptr(unsafe.Pointer(&st)), 0, 0); r1 == 0 {
+		return nil, fmt.Errorf("error while SaferComputeTokenFromLevel %s: %w", level, err)
+	}
+	return newToken(st, t.typ).withOptions(t.derivedOptions()), nil
+}
+
+// NewSaferToken computes the token of a Safer level from the current process token, see Token.SaferToken
+func NewSaferToken(level SaferLevel) (*Token, error) {
+	t, err := OpenProcessToken(0, TokenPrimary)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	return t.SaferToken(level)
+}
@@ -0,0 +1,208 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// SandboxPreset is a named combination of restricted token settings, modelled after the Chromium sandbox token levels
+type SandboxPreset int
+
+const (
+	// SandboxWriteRestricted keeps read access unchanged but restricts writes to objects
+	// accessible to the user, its logon session, Everyone, Users and RESTRICTED. Admin groups are deny-only
+	SandboxWriteRestricted SandboxPreset = iota
+	// SandboxLimited restricts all access to the user, its logon session, Everyone, Users and RESTRICTED,
+	// deletes all privileges except SeChangeNotifyPrivilege and runs at low integrity
+	SandboxLimited
+	// SandboxUntrusted turns every group into a deny-only SID, only allows objects accessible to
+	// the logon session and RESTRICTED, deletes all privileges and runs at untrusted integrity
+	SandboxUntrusted
+	// SandboxLockdown turns the user and every group into deny-only SIDs, restricts access to the
+	// NULL SID so almost no securable object can be opened, deletes all privileges and runs at untrusted integrity
+	SandboxLockdown
+)
+
+var sandboxPresetNames = map[SandboxPreset]string{
+	SandboxWriteRestricted: "writerestricted",
+	SandboxLimited:         "limited",
+	SandboxUntrusted:       "untrusted",
+	SandboxLockdown:        "lockdown",
+}
+
+func (p SandboxPreset) String() string {
+	if name, ok := sandboxPresetNames[p]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseSandboxPreset returns the preset with the given name, such as "lockdown", "limited" or "untrusted"
+func ParseSandboxPreset(name string) (SandboxPreset, error) {
+	for p, n := range sandboxPresetNames {
+		if strings.EqualFold(n, name) {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sandbox preset %q", name)
+}
+
+// sandboxSpec is the set of knobs a preset turns
+type sandboxSpec struct {
+	writeRestricted  bool
+	deletePrivileges bool
+	disableAllGroups bool
+	disableUser      bool
+	restrictUser     bool
+	restrictLogon    bool
+	restrictSIDs     []string
+	integrity        IntegrityLevel
+	keepIntegrity    bool
+}
+
+var sandboxSpecs = map[SandboxPreset]sandboxSpec{
+	SandboxWriteRestricted: {
+		writeRestricted: true,
+		restrictUser:    true,
+		restrictLogon:   true,
+		restrictSIDs:    []string{SIDEveryone, SIDUsers, SIDRestrictedCode},
+		keepIntegrity:   true,
+	},
+	SandboxLimited: {
+		deletePrivileges: true,
+		restrictUser:     true,
+		restrictLogon:    true,
+		restrictSIDs:     []string{SIDEveryone, SIDUsers, SIDRestrictedCode},
+		integrity:        IntegrityLow,
+	},
+	SandboxUntrusted: {
+		deletePrivileges: true,
+		disableAllGroups: true,
+		restrictLogon:    true,
+		restrictSIDs:     []string{SIDRestrictedCode},
+		integrity:        IntegrityUntrusted,
+	},
+	SandboxLockdown: {
+		deletePrivileges: true,
+		disableAllGroups: true,
+		disableUser:      true,
+		restrictSIDs:     []string{"S-1-0-0"},
+		integrity:        IntegrityUntrusted,
+	},
+}
+
+// restrictedTokenOptions translates the spec into CreateRestrictedToken options for token t
+func (s sandboxSpec) restrictedTokenOptions(t windows.Token) (RestrictedTokenOptions, error) {
+	opts := RestrictedTokenOptions{
+		DisableMaxPrivilege: s.deletePrivileges,
+		WriteRestricted:     s.writeRestricted,
+	}
+
+	user, err := t.GetTokenUser()
+	if err != nil {
+		return opts, fmt.Errorf("error while getting token user: %w", err)
+	}
+	groups, err := t.GetTokenGroups()
+	if err != nil {
+		return opts, fmt.Errorf("error while getting token groups: %w", err)
+	}
+
+	if s.disableUser {
+		opts.DisableSIDs = append(opts.DisableSIDs, user.User.Sid)
+	}
+	if s.restrictUser {
+		opts.RestrictingSIDs = append(opts.RestrictingSIDs, user.User.Sid)
+	}
+
+	for _, g := range groups.AllGroups() {
+		switch {
+		case g.Attributes&windows.SE_GROUP_INTEGRITY != 0:
+			continue
+		case g.Attributes&windows.SE_GROUP_LOGON_ID == windows.SE_GROUP_LOGON_ID:
+			if s.restrictLogon {
+				opts.RestrictingSIDs = append(opts.RestrictingSIDs, g.Sid)
+			}
+			if !s.disableAllGroups {
+				continue
+			}
+		}
+
+		if s.disableAllGroups || g.Sid.String() == SIDAdministrators {
+			opts.DisableSIDs = append(opts.DisableSIDs, g.Sid)
+		}
+	}
+
+	for _, str := range s.restrictSIDs {
+		sid, err := windows.StringToSid(str)
+		if err != nil {
+			return opts, err
+		}
+		opts.RestrictingSIDs = append(opts.RestrictingSIDs, sid)
+	}
+
+	return opts, nil
+}
+
+// Sandbox creates a sandbox token from the token using the given preset.
+// The new token has the same type as the original one and the original token is left untouched
+func (t *Token) Sandbox(preset SandboxPreset) (*Token, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	spec, ok := sandboxSpecs[preset]
+	if !ok {
+		return nil, fmt.Errorf("unknown sandbox preset %d", preset)
+	}
+
+	opts, err := spec.restrictedTokenOptions(t.token)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := t.CreateRestrictedToken(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if !spec.keepIntegrity {
+		if err := st.SetIntegrityLevel(spec.integrity); err != nil {
+			st.Close()
+			return nil, err
+		}
+	}
+
+	return st, nil
+}
+
+// WriteRestricted creates a write-restricted copy of the token, like Sandbox(SandboxWriteRestricted): reads are
+// checked as for the original token while writes must also be granted to the user, its logon session, Everyone,
+// Users, RESTRICTED or one of writable, such as the SID of a directory the sandboxed code may write to. Since read
+// access is unchanged far fewer applications break than with a fully restricted token
+func (t *Token) WriteRestricted(writable ...*windows.SID) (*Token, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	opts, err := sandboxSpecs[SandboxWriteRestricted].restrictedTokenOptions(t.token)
+	if err != nil {
+		return nil, err
+	}
+	opts.RestrictingSIDs = append(opts.RestrictingSIDs, writable...)
+	return t.CreateRestrictedToken(opts)
+}
+
+// NewSandboxToken creates a primary sandbox token from the current process token using the given preset
+func NewSandboxToken(preset SandboxPreset) (*Token, error) {
+	t, err := OpenProcessToken(0, TokenPrimary)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	return t.Sandbox(preset)
+}
@@ -0,0 +1,94 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	procGetKernelObjectSecurity = modadvapi32.NewProc("GetKernelObjectSecurity")
+	procSetKernelObjectSecurity = modadvapi32.NewProc("SetKernelObjectSecurity")
+)
+
+// tokenSecurityInformation is what GetSecurity reads, the SACL would need SeSecurityPrivilege
+const tokenSecurityInformation = windows.OWNER_SECURITY_INFORMATION | windows.GROUP_SECURITY_INFORMATION | windows.DACL_SECURITY_INFORMATION | windows.LABEL_SECURITY_INFORMATION
+
+// GetSecurity returns the security descriptor of the token object with GetKernelObjectSecurity, its DACL decides
+// which principals may open or duplicate the token. The handle needs READ_CONTROL
+func (t *Token) GetSecurity() (*windows.SECURITY_DESCRIPTOR, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	var n uint32
+	procGetKernelObjectSecurity.Call(uintptr(t.token), uintptr(tokenSecurityInformation), 0, 0, uintptr(unsafe.Pointer(&n)))
+	if n == 0 {
+		n = 256
+	}
+
+	for {
+		b := make([]byte, n)
+		r1, _, err := procGetKernelObjectSecurity.Call(uintptr(t.token), uintptr(tokenSecurityInformation), uintptr(unsafe.Pointer(&b[0])), uintptr(n), uintptr(unsafe.Pointer(&n)))
+		if r1 != 0 {
+			return (*windows.SECURITY_DESCRIPTOR)(unsafe.Pointer(&b[0])), nil
+		}
+		if err != windows.ERROR_INSUFFICIENT_BUFFER || n <= uint32(len(b)) {
+			return nil, fmt.Errorf("error while GetKernelObjectSecurity: %w", err)
+		}
+	}
+}
+
+// SetSecurity applies the parts of sd selected by info, such as windows.DACL_SECURITY_INFORMATION, to the token object
+// with SetKernelObjectSecurity. Changing the DACL needs WRITE_DAC on the handle and the owner WRITE_OWNER
+func (t *Token) SetSecurity(info windows.SECURITY_INFORMATION, sd *windows.SECURITY_DESCRIPTOR) error {
+	if err := t.acquire(); err != nil {
+		return err
+	}
+	defer t.release()
+
+	if r1, _, err := procSetKernelObjectSecurity.Call(uintptr(t.token), uintptr(info), uintptr(unsafe.Pointer(sd))); r1 == 0 {
+		return fmt.Errorf("error while SetKernelObjectSecurity: %w", err)
+	}
+	return nil
+}
+
+// SetDACL replaces the DACL of the token object, for example to restrict which principals may open or duplicate
+// a token this process created. The DACL can be built with windows.ACLFromEntries
+func (t *Token) SetDACL(dacl *windows.ACL) error {
+	sd, err := windows.NewSecurityDescriptor()
+	if err != nil {
+		return fmt.Errorf("error while creating security descriptor: %w", err)
+	}
+	if err := sd.SetDACL(dacl, true, false); err != nil {
+		return fmt.Errorf("error while setting DACL: %w", err)
+	}
+	return t.SetSecurity(windows.DACL_SECURITY_INFORMATION, sd)
+}
+
+// SetSecuritySDDL applies the DACL of an SDDL string, such as "D:P(A;;GA;;;SY)(A;;GA;;;BA)", to the token object
+func (t *Token) SetSecuritySDDL(sddl string) error {
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return fmt.Errorf("error while parsing SDDL: %w", err)
+	}
+	return t.SetSecurity(windows.DACL_SECURITY_INFORMATION, sd)
+}
+
+// Harden restricts the DACL of the token object to SYSTEM and the owner of the token object, dropping the grants the
+// default DACL gives to the logon session and other groups. The owner is the default owner of the process which created
+// the token, Administrators for elevated brokers, so processes of the same user which are not elevated can no longer
+// open or duplicate tokens held by the broker. The handle needs READ_CONTROL and WRITE_DAC
+func (t *Token) Harden() error {
+	sd, err := t.GetSecurity()
+	if err != nil {
+		return err
+	}
+	owner, _, err := sd.Owner()
+	if err != nil {
+		return fmt.Errorf("error while getting token owner: %w", err)
+	}
+	return t.SetSecuritySDDL(fmt.Sprintf("D:P(A;;GA;;;SY)(A;;GA;;;%s)", owner))
+}
@@ -0,0 +1,176 @@
+package wintoken
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// ServiceAccount is one of the built-in service accounts
+type ServiceAccount int
+
+const (
+	LocalService ServiceAccount = iota
+	NetworkService
+)
+
+func (a ServiceAccount) sid() string {
+	if a == NetworkService {
+		return SIDNetworkService
+	}
+	return SIDLocalService
+}
+
+func (a ServiceAccount) name() string {
+	if a == NetworkService {
+		return "NetworkService"
+	}
+	return "LocalService"
+}
+
+func (a ServiceAccount) String() string {
+	return `NT AUTHORITY\` + a.name()
+}
+
+// GetServiceAccountToken gets a token for the LocalService or NetworkService account.
+// Callers holding SeTcbPrivilege, such as services running as SYSTEM, get a service logon of the account.
+// Other callers look for a running process whose token user is the requested account and duplicate its token,
+// the service logon is still tried if none can be opened
+func GetServiceAccountToken(account ServiceAccount, tokenType tokenType, opts ...Option) (*Token, error) {
+	switch account {
+	case LocalService, NetworkService:
+	default:
+		return nil, ErrUnknownServiceAccount
+	}
+
+	o := newOptions(opts)
+	if tcbAvailable() {
+		if t, err := serviceAccountLogon(account, tokenType, o); err == nil {
+			return t, nil
+		}
+	}
+
+	sid := account.sid()
+	t, err := findProcessToken(func(_ processEntry, t windows.Token) bool {
+		return tokenUserSID(t) == sid
+	}, tokenType, o)
+	if err == nil {
+		return t, nil
+	}
+	if err != ErrNoMatchingProcess {
+		return nil, err
+	}
+
+	return serviceAccountLogon(account, tokenType, o)
+}
+
+// serviceAccountLogon logs on the service account with a service logon, which requires running as SYSTEM
+func serviceAccountLogon(account ServiceAccount, tokenType tokenType, o options) (*Token, error) {
+	lt, err := logonUser(account.name(), "NT AUTHORITY", "", LogonService, logon32ProviderDefault)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(windows.Handle(lt))
+
+	duplicatedToken, err := duplicateToken(lt, tokenType, o)
+	if err != nil {
+		return nil, err
+	}
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
+}
+
+// GetServiceToken gets the token of the process hosting the running service name
+func GetServiceToken(name string, tokenType tokenType, opts ...Option) (*Token, error) {
+	pid, err := servicePID(name)
+	if err != nil {
+		return nil, err
+	}
+	return OpenProcessToken(int(pid), tokenType, opts...)
+}
+
+// servicePID returns the process ID of a running service using QueryServiceStatusEx
+func servicePID(name string) (uint32, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+
+	scm, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return 0, fmt.Errorf("error while OpenSCManager: %w", err)
+	}
+	defer windows.CloseServiceHandle(scm)
+
+	svc, err := windows.OpenService(scm, namePtr, windows.SERVICE_QUERY_STATUS)
+	if err != nil {
+		return 0, fmt.Errorf("error while OpenService %s: %w", name, err)
+	}
+	defer windows.CloseServiceHandle(svc)
+
+	status, err := serviceStatus(svc, name)
+	if err != nil {
+		return 0, err
+	}
+	if status.CurrentState != windows.SERVICE_RUNNING || status.ProcessId == 0 {
+		return 0, ErrServiceNotRunning
+	}
+	return status.ProcessId, nil
+}
+
+func serviceStatus(svc windows.Handle, name string) (windows.SERVICE_STATUS_PROCESS, error) {
+	var (
+		status windows.SERVICE_STATUS_PROCESS
+		n      uint32
+	)
+	if err := windows.QueryServiceStatusEx(svc, windows.SC_STATUS_PROCESS_INFO, (*byte)(unsafe.Pointer(&status)), uint32(unsafe.Sizeof(status)), &n); err != nil {
+		return status, fmt.Errorf("error while QueryServiceStatusEx %s: %w", name, err)
+	}
+	return status, nil
+}
+
+// startService starts the service name unless it is already running, waits up to timeout
+// for it to reach the running state and returns the process ID of the service
+func startService(name string, timeout time.Duration) (uint32, error) {
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+
+	scm, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return 0, fmt.Errorf("error while OpenSCManager: %w", err)
+	}
+	defer windows.CloseServiceHandle(scm)
+
+	svc, err := windows.OpenService(scm, namePtr, windows.SERVICE_QUERY_STATUS|windows.SERVICE_START)
+	if err != nil {
+		return 0, fmt.Errorf("error while OpenService %s: %w", name, err)
+	}
+	defer windows.CloseServiceHandle(svc)
+
+	status, err := serviceStatus(svc, name)
+	if err != nil {
+		return 0, err
+	}
+	if status.CurrentState == windows.SERVICE_STOPPED {
+		if err := windows.StartService(svc, 0, nil); err != nil && err != windows.ERROR_SERVICE_ALREADY_RUNNING {
+			return 0, fmt.Errorf("error while StartService %s: %w", name, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if status, err = serviceStatus(svc, name); err != nil {
+			return 0, err
+		}
+		if status.CurrentState == windows.SERVICE_RUNNING && status.ProcessId != 0 {
+			return status.ProcessId, nil
+		}
+		if time.Now().After(deadline) {
+			return 0, ErrServiceNotRunning
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
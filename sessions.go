@@ -0,0 +1,307 @@
+package wintoken
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modwtsapi32         = windows.NewLazySystemDLL("wtsapi32.dll")
+	procWTSSendMessageW = modwtsapi32.NewProc("WTSSendMessageW")
+
+	procWTSQuerySessionInformationW = modwtsapi32.NewProc("WTSQuerySessionInformationW")
+)
+
+// WTS_INFO_CLASS values queried with WTSQuerySessionInformation
+const (
+	wtsUserName           = 5
+	wtsDomainName         = 7
+	wtsClientName         = 10
+	wtsClientAddress      = 14
+	wtsClientProtocolType = 16
+)
+
+// SessionProtocol is the protocol a session is connected with
+type SessionProtocol uint16
+
+const (
+	ProtocolConsole SessionProtocol = 0
+	ProtocolICA     SessionProtocol = 1
+	ProtocolRDP     SessionProtocol = 2
+)
+
+func (p SessionProtocol) String() string {
+	switch p {
+	case ProtocolConsole:
+		return "Console"
+	case ProtocolICA:
+		return "ICA"
+	case ProtocolRDP:
+		return "RDP"
+	default:
+		return "Unknown"
+	}
+}
+
+// SessionInfo describes a session and the client connected to it
+type SessionInfo struct {
+	ID uint32
+	// Station is the window station name of the session, such as Console or RDP-Tcp#3
+	Station string
+	// State is the connection state, one of the windows.WTS* constants such as windows.WTSActive
+	State    uint32
+	UserName string
+	Domain   string
+	// ClientName is the NetBIOS name of the remote client, empty for the console session
+	ClientName string
+	// ClientAddress is the IP address of the remote client, nil for the console session or unknown address families
+	ClientAddress net.IP
+	Protocol      SessionProtocol
+}
+
+// Responses returned by SendSessionMessage besides the IDOK, IDCANCEL, ... button identifiers of MessageBox
+const (
+	MessageResponseTimeout uint32 = 32000
+	MessageResponseAsync   uint32 = 32001
+)
+
+// SessionResult is the outcome of launching a program in one session with RunInAllSessions
+type SessionResult struct {
+	SessionID uint32
+	// Station is the window station name of the session, such as Console or RDP-Tcp#3
+	Station string
+	// Process is the launched process, nil if Err is set. It must be released or waited on by the caller
+	Process *Process
+	Err     error
+}
+
+// RunInAllSessions starts exe with args as the user of every active session, for notification agents and logoff scripts
+// run from a SYSTEM service. It returns one result per active session, the error is only set if the sessions cannot be
+// enumerated. opts may be nil and is shared by every launch
+func RunInAllSessions(exe string, args []string, opts *StartOptions) ([]SessionResult, error) {
+	sessions, err := enumerateSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SessionResult
+	for _, s := range sessions {
+		if s.state != windows.WTSActive || s.id == 0 {
+			continue
+		}
+
+		result := SessionResult{SessionID: s.id, Station: s.station}
+		result.Process, result.Err = runInSession(s.id, exe, args, opts)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runInSession(sessionID uint32, exe string, args []string, opts *StartOptions) (*Process, error) {
+	t, err := GetSessionToken(sessionID, TokenPrimary)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+
+	return t.StartProcess(exe, args, opts)
+}
+
+// SendSessionMessage shows a message box on the desktop of a session using WTSSendMessage, so a service can notify
+// a user without launching a process. style takes the windows.MB_* flags, a timeout of 0 waits forever.
+// If wait is false the call returns MessageResponseAsync immediately, otherwise it returns the button the user
+// clicked or MessageResponseTimeout
+func SendSessionMessage(sessionID uint32, title, message string, style uint32, timeout time.Duration, wait bool) (uint32, error) {
+	title16, err := windows.UTF16FromString(title)
+	if err != nil {
+		return 0, err
+	}
+	message16, err := windows.UTF16FromString(message)
+	if err != nil {
+		return 0, err
+	}
+
+	var waitFlag uintptr
+	if wait {
+		waitFlag = 1
+	}
+
+	var response uint32
+	r1, _, err := procWTSSendMessageW.Call(
+		uintptr(WTS_CURRENT_SERVER_HANDLE),
+		uintptr(sessionID),
+		uintptr(unsafe.Pointer(&title16[0])),
+		uintptr((len(title16)-1)*2),
+		uintptr(unsafe.Pointer(&message16[0])),
+		uintptr((len(message16)-1)*2),
+		uintptr(style),
+		uintptr(timeout/time.Second),
+		uintptr(unsafe.Pointer(&response)),
+		waitFlag,
+	)
+	if r1 == 0 {
+		return 0, fmt.Errorf("error while WTSSendMessage: %w", err)
+	}
+	return response, nil
+}
+
+// querySessionInformation returns the raw value of a WTS_INFO_CLASS for a session
+func querySessionInformation(sessionID uint32, class uint32) ([]byte, error) {
+	var (
+		buf *byte
+		n   uint32
+	)
+	r1, _, err := procWTSQuerySessionInformationW.Call(uintptr(WTS_CURRENT_SERVER_HANDLE), uintptr(sessionID), uintptr(class), uintptr(unsafe.Pointer(&buf)), uintptr(unsafe.Pointer(&n)))
+	if r1 == 0 {
+		return nil, fmt.Errorf("error while WTSQuerySessionInformation: %w", err)
+	}
+	defer windows.WTSFreeMemory(uintptr(unsafe.Pointer(buf)))
+
+	if n == 0 {
+		return nil, nil
+	}
+	b := make([]byte, n)
+	copy(b, (*[1 << 20]byte)(unsafe.Pointer(buf))[:n:n])
+	return b, nil
+}
+
+func querySessionString(sessionID uint32, class uint32) string {
+	b, err := querySessionInformation(sessionID, class)
+	if err != nil || len(b) < 2 {
+		return ""
+	}
+	return windows.UTF16PtrToString((*uint16)(unsafe.Pointer(&b[0])))
+}
+
+// decodeClientAddress decodes a WTS_CLIENT_ADDRESS, the address bytes start at offset 2 of the Address field
+func decodeClientAddress(b []byte) net.IP {
+	if len(b) < 4+20 {
+		return nil
+	}
+	family := *(*uint32)(unsafe.Pointer(&b[0]))
+	addr := b[4:]
+	switch family {
+	case windows.AF_INET:
+		return net.IPv4(addr[2], addr[3], addr[4], addr[5])
+	case windows.AF_INET6:
+		return net.IP(append([]byte(nil), addr[2:18]...))
+	default:
+		return nil
+	}
+}
+
+// sessionInfo queries the user and client information of a session
+func sessionInfo(s wtsSession) SessionInfo {
+	info := SessionInfo{
+		ID:         s.id,
+		Station:    s.station,
+		State:      s.state,
+		UserName:   querySessionString(s.id, wtsUserName),
+		Domain:     querySessionString(s.id, wtsDomainName),
+		ClientName: querySessionString(s.id, wtsClientName),
+	}
+	if b, err := querySessionInformation(s.id, wtsClientAddress); err == nil {
+		info.ClientAddress = decodeClientAddress(b)
+	}
+	if b, err := querySessionInformation(s.id, wtsClientProtocolType); err == nil && len(b) >= 2 {
+		info.Protocol = SessionProtocol(*(*uint16)(unsafe.Pointer(&b[0])))
+	}
+	return info
+}
+
+// Sessions lists the sessions of the local server with their user and client information
+func Sessions() ([]SessionInfo, error) {
+	sessions, err := enumerateSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]SessionInfo, len(sessions))
+	for i, s := range sessions {
+		infos[i] = sessionInfo(s)
+	}
+	return infos, nil
+}
+
+// FindSession returns the first session accepted by match, such as the session of a given RDP client on a
+// Remote Desktop Session Host. Its token can then be obtained with GetSessionToken
+func FindSession(match func(SessionInfo) bool) (SessionInfo, error) {
+	sessions, err := Sessions()
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	for _, s := range sessions {
+		if match(s) {
+			return s, nil
+		}
+	}
+	return SessionInfo{}, ErrNoMatchingSession
+}
+
+// ByClientName matches the sessions connected from the client with the given NetBIOS name, ignoring case
+func ByClientName(name string) func(SessionInfo) bool {
+	return func(s SessionInfo) bool {
+		return s.ClientName != "" && strings.EqualFold(s.ClientName, name)
+	}
+}
+
+// ByClientAddress matches the sessions connected from the given client IP address
+func ByClientAddress(ip net.IP) func(SessionInfo) bool {
+	return func(s SessionInfo) bool {
+		return s.ClientAddress != nil && s.ClientAddress.Equal(ip)
+	}
+}
+
+// ByProtocol matches the sessions connected with the given protocol, ProtocolConsole matches the console session
+func ByProtocol(p SessionProtocol) func(SessionInfo) bool {
+	return func(s SessionInfo) bool {
+		return s.Protocol == p && s.ID != 0
+	}
+}
+
+// GetTokensForAllUsers gets a token for every user logged on to a session, keyed by DOMAIN\user, so per-user agents
+// can iterate all logged-on identities in one call. A user logged on to several sessions is only returned once,
+// with the token of an active session if there is one. The session states considered can be changed with
+// WithSessionStates. Sessions whose token cannot be queried are skipped, an error is only returned if no token
+// could be obtained at all. The caller must close every returned Token
+func GetTokensForAllUsers(tokenType tokenType, opts ...Option) (map[string]*Token, error) {
+	sessions, err := enumerateSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	console, _ := ConsoleSessionID()
+	tokens := make(map[string]*Token)
+	var lastErr error
+	for _, s := range interactiveSessions(sessions, console, newOptions(opts)) {
+		t, err := GetSessionToken(s.id, tokenType, opts...)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		user, err := t.token.GetTokenUser()
+		if err != nil {
+			t.Close()
+			lastErr = err
+			continue
+		}
+
+		name := accountName(user.User.Sid)
+		if _, ok := tokens[name]; ok {
+			t.Close()
+			continue
+		}
+		tokens[name] = t
+	}
+
+	if len(tokens) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return tokens, nil
+}
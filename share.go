@@ -0,0 +1,86 @@
+package wintoken
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modmpr                    = windows.NewLazySystemDLL("mpr.dll")
+	procWNetAddConnection2W   = modmpr.NewProc("WNetAddConnection2W")
+	procWNetCancelConnection2 = modmpr.NewProc("WNetCancelConnection2W")
+)
+
+const resourceTypeDisk = 0x00000001
+
+// netResource is the NETRESOURCEW structure
+type netResource struct {
+	scope       uint32
+	typ         uint32
+	displayType uint32
+	usage       uint32
+	localName   *uint16
+	remoteName  *uint16
+	comment     *uint16
+	provider    *uint16
+}
+
+// ShareConnection is a connection to a network share made in the logon session of a token, see Token.ConnectShare
+type ShareConnection struct {
+	t      *Token
+	remote string
+}
+
+// ConnectShare connects to the UNC path remote, such as \\server\home$\user, with WNetAddConnection2 while
+// impersonating the token, so a service can reach per-user DFS or home shares as that user. If username is empty
+// the credentials of the token's logon session are used, which works for tokens of interactive users.
+// Files on the share can then be opened with Token.OpenFile. The connection belongs to the token's logon session
+// and must be closed with Close, the Token must stay open until then
+func (t *Token) ConnectShare(remote, username, password string) (*ShareConnection, error) {
+	remotePtr, err := windows.UTF16PtrFromString(remote)
+	if err != nil {
+		return nil, err
+	}
+
+	var userPtr, passwordPtr *uint16
+	if username != "" {
+		if userPtr, err = windows.UTF16PtrFromString(username); err != nil {
+			return nil, err
+		}
+		if passwordPtr, err = windows.UTF16PtrFromString(password); err != nil {
+			return nil, err
+		}
+	}
+
+	res := netResource{typ: resourceTypeDisk, remoteName: remotePtr}
+	err = t.RunAs(func() error {
+		r1, _, _ := procWNetAddConnection2W.Call(uintptr(unsafe.Pointer(&res)), uintptr(unsafe.Pointer(passwordPtr)), uintptr(unsafe.Pointer(userPtr)), 0)
+		if r1 != 0 {
+			return fmt.Errorf("error while WNetAddConnection2 %s: %w", remote, windows.Errno(r1))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ShareConnection{t: t, remote: remote}, nil
+}
+
+// Close cancels the connection to the share, open files are closed forcibly
+func (c *ShareConnection) Close() error {
+	remotePtr, err := windows.UTF16PtrFromString(c.remote)
+	if err != nil {
+		return err
+	}
+
+	return c.t.RunAs(func() error {
+		r1, _, _ := procWNetCancelConnection2.Call(uintptr(unsafe.Pointer(remotePtr)), 0, 1)
+		if r1 != 0 {
+			return fmt.Errorf("error while WNetCancelConnection2 %s: %w", c.remote, windows.Errno(r1))
+		}
+		return nil
+	})
+}
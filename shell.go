@@ -0,0 +1,40 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// CurrentSession selects the session of the calling process, it is WTS_CURRENT_SESSION
+const CurrentSession = ^uint32(0)
+
+// GetShellToken duplicates the token of explorer.exe running in the session sessionID, or in the session of the
+// caller for CurrentSession. That is the token of the unelevated desktop user, so an elevated process can use it to
+// start programs or access files as the user without the elevation it was granted.
+// Services holding SeTcbPrivilege get the same token with WTSQueryUserToken without opening explorer.exe,
+// other callers need SeDebugPrivilege to open the shell of another session
+func GetShellToken(sessionID uint32, tokenType tokenType, opts ...Option) (*Token, error) {
+	if sessionID == CurrentSession {
+		if err := windows.ProcessIdToSessionId(windows.GetCurrentProcessId(), &sessionID); err != nil {
+			return nil, fmt.Errorf("error while ProcessIdToSessionId: %w", err)
+		}
+	}
+
+	if t := sessionTokenSource(sessionID, nil, tokenType, opts); t != nil {
+		return t, nil
+	}
+
+	t, err := findProcessToken(func(p processEntry, t windows.Token) bool {
+		if !strings.EqualFold(p.exe, "explorer.exe") {
+			return false
+		}
+		id, err := tokenInfoUint32(t, windows.TokenSessionId)
+		return err == nil && id == sessionID
+	}, tokenType, newOptions(opts))
+	if err != nil {
+		return nil, fmt.Errorf("error while finding explorer.exe in session %d: %w", sessionID, err)
+	}
+	return t, nil
+}
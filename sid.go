@@ -0,0 +1,95 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// String forms of well-known SIDs commonly found in token users and groups
+const (
+	SIDEveryone           = "S-1-1-0"
+	SIDInteractive        = "S-1-5-4"
+	SIDService            = "S-1-5-6"
+	SIDAuthenticatedUsers = "S-1-5-11"
+	SIDRestrictedCode     = "S-1-5-12"
+	SIDLocalSystem        = "S-1-5-18"
+	SIDLocalService       = "S-1-5-19"
+	SIDNetworkService     = "S-1-5-20"
+	SIDAdministrators     = "S-1-5-32-544"
+	SIDUsers              = "S-1-5-32-545"
+	SIDTrustedInstaller   = "S-1-5-80-956008885-3418522649-1831038044-1853292631-2271478464"
+
+	SIDAllApplicationPackages           = "S-1-15-2-1"
+	SIDAllRestrictedApplicationPackages = "S-1-15-2-2"
+
+	SIDUntrustedIntegrity = "S-1-16-0"
+	SIDLowIntegrity       = "S-1-16-4096"
+	SIDMediumIntegrity    = "S-1-16-8192"
+	SIDHighIntegrity      = "S-1-16-12288"
+	SIDSystemIntegrity    = "S-1-16-16384"
+)
+
+// Account is the account a SID resolves to
+type Account struct {
+	Name   string
+	Domain string
+	// Type is one of the windows.SidType* constants
+	Type uint32
+}
+
+func (a Account) String() string {
+	if a.Domain == "" {
+		return a.Name
+	}
+	return a.Domain + `\` + a.Name
+}
+
+// WellKnownSID creates the SID of a well-known account or group such as windows.WinLocalSystemSid
+func WellKnownSID(sidType windows.WELL_KNOWN_SID_TYPE) (*windows.SID, error) {
+	sid, err := windows.CreateWellKnownSid(sidType)
+	if err != nil {
+		return nil, fmt.Errorf("error while CreateWellKnownSid: %w", err)
+	}
+	return sid, nil
+}
+
+// SIDFromString parses a SID in its S-1-... string form
+func SIDFromString(s string) (*windows.SID, error) {
+	sid, err := windows.StringToSid(s)
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing SID %q: %w", s, err)
+	}
+	return sid, nil
+}
+
+// SIDToString formats a SID in its S-1-... string form
+func SIDToString(sid *windows.SID) string {
+	if sid == nil {
+		return ""
+	}
+	return sid.String()
+}
+
+// LookupAccountSID resolves a SID to its account name and domain using LookupAccountSid
+func LookupAccountSID(sid *windows.SID) (Account, error) {
+	name, domain, typ, err := sid.LookupAccount("")
+	if err != nil {
+		return Account{}, fmt.Errorf("error while LookupAccountSid for %s: %w", sid, err)
+	}
+	return Account{Name: name, Domain: domain, Type: typ}, nil
+}
+
+// LookupAccountName resolves an account name, optionally in DOMAIN\name form, to its SID using LookupAccountName
+func LookupAccountName(name string) (*windows.SID, Account, error) {
+	sid, domain, typ, err := windows.LookupSID("", name)
+	if err != nil {
+		return nil, Account{}, fmt.Errorf("error while LookupAccountName for %s: %w", name, err)
+	}
+
+	if i := strings.LastIndexByte(name, '\\'); i != -1 {
+		name = name[i+1:]
+	}
+	return sid, Account{Name: name, Domain: domain, Type: typ}, nil
+}
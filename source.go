@@ -0,0 +1,181 @@
+package wintoken
+
+import (
+	"fmt"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Query describes the token FindTokenSource looks for, zero fields match any process
+type Query struct {
+	// User is the token user as DOMAIN\user or a SID string, names are resolved to a SID once per search
+	User string
+	// SessionID restricts the search to one session, nil matches any session
+	SessionID *uint32
+	// MinIntegrity is the lowest integrity level accepted
+	MinIntegrity IntegrityLevel
+	// RequiredPrivilege must be held by the token, enabled or not
+	RequiredPrivilege Privilege
+}
+
+// privilegeLUIDs caches the LUIDs of privilege names, they never change during the lifetime of the process
+var privilegeLUIDs sync.Map
+
+// privilegeLUID looks up the LUID of a privilege through privilegeLUIDs
+func privilegeLUID(priv Privilege) (windows.LUID, error) {
+	if luid, ok := privilegeLUIDs.Load(priv); ok {
+		return luid.(windows.LUID), nil
+	}
+
+	name, err := windows.UTF16PtrFromString(string(priv))
+	if err != nil {
+		return windows.LUID{}, err
+	}
+	var luid windows.LUID
+	if err := windows.LookupPrivilegeValue(nil, name, &luid); err != nil {
+		return windows.LUID{}, err
+	}
+	privilegeLUIDs.Store(priv, luid)
+	return luid, nil
+}
+
+// holdsPrivilege reports whether the token holds the privilege luid, enabled or not
+func holdsPrivilege(t windows.Token, luid windows.LUID) bool {
+	held := false
+	withTokenInfo(t, windows.TokenPrivileges, func(b []byte) error {
+		for _, p := range (*windows.Tokenprivileges)(unsafe.Pointer(&b[0])).AllPrivileges() {
+			if p.Luid == luid && p.Attributes&windows.SE_PRIVILEGE_REMOVED == 0 {
+				held = true
+				break
+			}
+		}
+		return nil
+	})
+	return held
+}
+
+// tokenQuery is a Query with its user and privilege resolved once, so matching a process does not look them up again
+type tokenQuery struct {
+	Query
+	userSID string
+	luid    windows.LUID
+}
+
+// resolve looks up the SID of q.User and the LUID of q.RequiredPrivilege
+func (q Query) resolve() (tokenQuery, error) {
+	tq := tokenQuery{Query: q}
+	if q.User != "" {
+		sid, err := windows.StringToSid(q.User)
+		if err != nil {
+			if sid, _, _, err = windows.LookupSID("", q.User); err != nil {
+				return tq, fmt.Errorf("error while looking up %s: %w", q.User, err)
+			}
+		}
+		tq.userSID = sid.String()
+	}
+	if q.RequiredPrivilege != "" {
+		luid, err := privilegeLUID(q.RequiredPrivilege)
+		if err != nil {
+			return tq, fmt.Errorf("error while looking up %s: %w", q.RequiredPrivilege, err)
+		}
+		tq.luid = luid
+	}
+	return tq, nil
+}
+
+// matches checks the constraints of q against a raw token and returns the integrity level of the token
+func (q tokenQuery) matches(t windows.Token) (IntegrityLevel, bool) {
+	if q.userSID != "" && tokenUserSID(t) != q.userSID {
+		return 0, false
+	}
+
+	if q.SessionID != nil {
+		id, err := tokenInfoUint32(t, windows.TokenSessionId)
+		if err != nil || id != *q.SessionID {
+			return 0, false
+		}
+	}
+
+	il, err := tokenIntegrity(t)
+	if err != nil || il < q.MinIntegrity {
+		return 0, false
+	}
+
+	if q.RequiredPrivilege != "" && !holdsPrivilege(t, q.luid) {
+		return 0, false
+	}
+	return il, true
+}
+
+// FindTokenSource searches the running processes for a token to duplicate matching q, abstracting the usual
+// "find a process to steal from" heuristics. The best candidate is the one with the highest integrity level,
+// the first process found on ties. Processes whose token cannot be opened are skipped, ErrNoMatchingProcess
+// is returned when nothing matches. SeDebugPrivilege is needed to search the processes of other users.
+// When the query names a session and the caller holds SeTcbPrivilege, the token of the session user from
+// WTSQueryUserToken is tried before any process is opened
+func FindTokenSource(q Query, tokenType tokenType, opts ...Option) (*Token, error) {
+	start := time.Now()
+	o := newOptions(opts)
+
+	tq, err := q.resolve()
+	if err != nil {
+		return nil, err
+	}
+
+	if q.SessionID != nil {
+		if t := sessionTokenSource(*q.SessionID, func(t windows.Token) bool {
+			il, ok := tq.matches(t)
+			return ok && o.acceptsIntegrity(il)
+		}, tokenType, opts); t != nil {
+			return t, nil
+		}
+	}
+
+	processes, err := enumerateProcesses()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		best    windows.Token
+		bestPID uint32
+		bestIL  IntegrityLevel
+	)
+	for _, p := range processes {
+		if p.pid == 0 {
+			continue
+		}
+
+		t, err := openProcessToken(int(p.pid), windows.TOKEN_QUERY|windows.TOKEN_DUPLICATE)
+		if err != nil {
+			continue
+		}
+
+		il, ok := tq.matches(t)
+		if !ok || !o.acceptsIntegrity(il) || (best != 0 && il <= bestIL) {
+			windows.CloseHandle(windows.Handle(t))
+			continue
+		}
+		if best != 0 {
+			windows.CloseHandle(windows.Handle(best))
+		}
+		best, bestPID, bestIL = t, p.pid, il
+	}
+
+	if best == 0 {
+		audit(AuditStealToken, start, 0, 0, 0, ErrNoMatchingProcess)
+		return nil, ErrNoMatchingProcess
+	}
+	defer windows.CloseHandle(windows.Handle(best))
+
+	duplicatedToken, err := duplicateToken(best, tokenType, o)
+	if err != nil {
+		audit(AuditStealToken, start, int(bestPID), 0, 0, err)
+		return nil, err
+	}
+	audit(AuditStealToken, start, int(bestPID), 0, duplicatedToken, nil)
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
+}
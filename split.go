@@ -0,0 +1,126 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// TOKEN_ELEVATION_TYPE values
+const (
+	tokenElevationTypeDefault = 1
+	tokenElevationTypeFull    = 2
+)
+
+// SplitTokenComparison compares the two halves of a UAC split token, see Token.CompareLinked
+type SplitTokenComparison struct {
+	// GroupsStripped are the groups enabled in the elevated token which are deny-only or absent in the filtered token,
+	// such as BUILTIN\Administrators
+	GroupsStripped []GroupDetail
+	// PrivilegesRemoved are the privileges of the elevated token which the filtered token does not hold
+	PrivilegesRemoved []PrivilegeDetail
+	FilteredIntegrity IntegrityLevel
+	ElevatedIntegrity IntegrityLevel
+}
+
+// IntegrityDelta is how many integrity levels elevation raises, 1 for the usual Medium to High
+func (c SplitTokenComparison) IntegrityDelta() int {
+	return (int(c.ElevatedIntegrity) - int(c.FilteredIntegrity)) / int(IntegrityLow)
+}
+
+func (c SplitTokenComparison) String() string {
+	var groups, privs []string
+	for _, g := range c.GroupsStripped {
+		groups = append(groups, g.Name)
+	}
+	for _, p := range c.PrivilegesRemoved {
+		privs = append(privs, string(p.Name))
+	}
+	return fmt.Sprintf("Integrity: %s -> %s, Groups stripped: [%s], Privileges removed: [%s]", c.FilteredIntegrity, c.ElevatedIntegrity, strings.Join(groups, ", "), strings.Join(privs, ", "))
+}
+
+// CompareLinked fetches the linked token of a UAC split token and compares the filtered and the elevated halves,
+// whichever of them t is. It returns ErrNotSplitToken for tokens without a linked token, such as the ones of SYSTEM
+// or of users who are not administrators
+func (t *Token) CompareLinked() (*SplitTokenComparison, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	typ, err := tokenInfoUint32(t.token, windows.TokenElevationType)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting token elevation type: %w", err)
+	}
+	if typ == tokenElevationTypeDefault {
+		return nil, ErrNotSplitToken
+	}
+
+	linked, err := t.GetLinkedToken()
+	if err != nil {
+		return nil, fmt.Errorf("error while getting linked token: %w", err)
+	}
+	defer linked.Close()
+
+	filtered, elevated := t, linked
+	if typ == tokenElevationTypeFull {
+		filtered, elevated = linked, t
+	}
+	return compareSplitToken(filtered, elevated)
+}
+
+func compareSplitToken(filtered, elevated *Token) (*SplitTokenComparison, error) {
+	var (
+		c   SplitTokenComparison
+		err error
+	)
+
+	if c.FilteredIntegrity, err = filtered.IntegrityLevel(); err != nil {
+		return nil, err
+	}
+	if c.ElevatedIntegrity, err = elevated.IntegrityLevel(); err != nil {
+		return nil, err
+	}
+
+	filteredGroups, err := filtered.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	elevatedGroups, err := elevated.GetGroups()
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[string]bool, len(filteredGroups))
+	for _, g := range filteredGroups {
+		kept[g.SID] = !g.DenyOnly
+	}
+	for _, g := range elevatedGroups {
+		if g.Integrity || g.DenyOnly {
+			continue
+		}
+		if !kept[g.SID] {
+			c.GroupsStripped = append(c.GroupsStripped, g)
+		}
+	}
+
+	filteredPrivs, err := filtered.GetPrivileges()
+	if err != nil {
+		return nil, err
+	}
+	elevatedPrivs, err := elevated.GetPrivileges()
+	if err != nil {
+		return nil, err
+	}
+	held := make(map[Privilege]bool, len(filteredPrivs))
+	for _, p := range filteredPrivs {
+		held[p.Name] = !p.Removed
+	}
+	for _, p := range elevatedPrivs {
+		if !p.Removed && !held[p.Name] {
+			c.PrivilegesRemoved = append(c.PrivilegesRemoved, p)
+		}
+	}
+
+	return &c, nil
+}
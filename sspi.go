@@ -0,0 +1,98 @@
+package wintoken
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modsecur32                    = windows.NewLazySystemDLL("secur32.dll")
+	procAcquireCredentialsHandleW = modsecur32.NewProc("AcquireCredentialsHandleW")
+	procFreeCredentialsHandle     = modsecur32.NewProc("FreeCredentialsHandle")
+
+	modkernel32                 = windows.NewLazySystemDLL("kernel32.dll")
+	procLocalFileTimeToFileTime = modkernel32.NewProc("LocalFileTimeToFileTime")
+)
+
+// CredentialUse is how SSPI credentials will be used, it is the fCredentialUse argument of AcquireCredentialsHandle
+type CredentialUse uint32
+
+const (
+	CredentialInbound  CredentialUse = 1
+	CredentialOutbound CredentialUse = 2
+	CredentialBoth     CredentialUse = 3
+)
+
+// SSPI package names accepted by Token.AcquireCredentials
+const (
+	PackageNegotiate = "Negotiate"
+	PackageKerberos  = "Kerberos"
+	PackageNTLM      = "NTLM"
+)
+
+// neverExpires bounds the expiry timestamps which can be converted to a time.Time
+const neverExpires = 0x7FFFFFFFFFFFFFFF / 2
+
+// Credentials is an SSPI credentials handle, Lower and Upper are the fields of the CredHandle structure
+// so the handle can be passed to SSPI libraries such as github.com/alexbrainman/sspi
+type Credentials struct {
+	Lower uintptr
+	Upper uintptr
+	// Expiry is when the credentials expire, zero if they never expire. SSPI reports it in local time, it is
+	// converted with LocalFileTimeToFileTime
+	Expiry time.Time
+}
+
+// Free releases the credentials handle with FreeCredentialsHandle
+func (c *Credentials) Free() error {
+	if c.Lower == 0 && c.Upper == 0 {
+		return nil
+	}
+	r1, _, _ := procFreeCredentialsHandle.Call(uintptr(unsafe.Pointer(c)))
+	if err := hresultError(r1); err != nil {
+		return fmt.Errorf("error while FreeCredentialsHandle: %w", err)
+	}
+	c.Lower, c.Upper = 0, 0
+	return nil
+}
+
+// AcquireCredentials calls AcquireCredentialsHandle for the SSPI package pkg, such as PackageKerberos, while impersonating
+// the token, so the returned handle carries the credentials of the token's logon session. This is what proxies and gateways
+// need to authenticate with Kerberos as the user. The token must come from a logon with network credentials.
+// The returned Credentials must be freed by the caller
+func (t *Token) AcquireCredentials(pkg string, use CredentialUse) (*Credentials, error) {
+	pkgPtr, err := windows.UTF16PtrFromString(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		creds  Credentials
+		expiry int64
+	)
+	err = t.RunAs(func() error {
+		r1, _, _ := procAcquireCredentialsHandleW.Call(0, uintptr(unsafe.Pointer(pkgPtr)), uintptr(use), 0, 0, 0, 0, uintptr(unsafe.Pointer(&creds)), uintptr(unsafe.Pointer(&expiry)))
+		if err := hresultError(r1); err != nil {
+			return fmt.Errorf("error while AcquireCredentialsHandle %s: %w", pkg, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// credentials which never expire report the maximum LARGE_INTEGER, Expiry is left zero for them
+	if expiry > 0 && expiry < neverExpires {
+		local := windows.Filetime{LowDateTime: uint32(expiry), HighDateTime: uint32(expiry >> 32)}
+		var ft windows.Filetime
+		if r1, _, err := procLocalFileTimeToFileTime.Call(uintptr(unsafe.Pointer(&local)), uintptr(unsafe.Pointer(&ft))); r1 == 0 {
+			creds.Free()
+			return nil, fmt.Errorf("error while LocalFileTimeToFileTime: %w", err)
+		}
+		creds.Expiry = time.Unix(0, ft.Nanoseconds())
+	}
+	return &creds, nil
+}
@@ -0,0 +1,206 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// preferredSystemDonors hold full SYSTEM tokens with every privilege and are not protected processes
+var preferredSystemDonors = []string{"winlogon.exe", "services.exe", "wininit.exe"}
+
+// GetSystemToken gets a SYSTEM token by duplicating the token of a process running as LocalSystem.
+// winlogon.exe and other core processes are preferred since service hosts may run with a reduced privilege set.
+// SeDebugPrivilege must be enabled on the caller's token to open SYSTEM processes, unless the caller runs as SYSTEM itself
+// in which case its own token is duplicated
+func GetSystemToken(tokenType tokenType, opts ...Option) (*Token, error) {
+	o := newOptions(opts)
+
+	// a service running as SYSTEM already holds a full SYSTEM token, no other process needs to be opened
+	if isLocalSystem() {
+		return OpenProcessToken(0, tokenType, opts...)
+	}
+
+	isSystem := func(t windows.Token) bool {
+		return tokenUserSID(t) == SIDLocalSystem
+	}
+
+	t, err := findProcessToken(func(p processEntry, t windows.Token) bool {
+		for _, name := range preferredSystemDonors {
+			if strings.EqualFold(p.exe, name) {
+				return isSystem(t)
+			}
+		}
+		return false
+	}, tokenType, o)
+	if err != ErrNoMatchingProcess {
+		return t, err
+	}
+
+	return findProcessToken(func(_ processEntry, t windows.Token) bool {
+		return isSystem(t)
+	}, tokenType, o)
+}
+
+// EnableProcessPrivileges enables privileges on the token of the current process itself, unlike OpenProcessToken(0, ...)
+// which returns a duplicate whose changes do not affect the process. It is typically used to enable SeDebugPrivilege
+// before opening the tokens of other users' processes
+func EnableProcessPrivileges(privs ...Privilege) (*PrivilegeResult, error) {
+	var h windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &h); err != nil {
+		return nil, fmt.Errorf("error while OpenProcessToken: %w", err)
+	}
+
+	t := newToken(h, TokenPrimary)
+	defer t.Close()
+
+	return t.EnableTokenPrivileges(privs...)
+}
+
+// scopedPrivilege is a privilege enabled on the process token by WithPrivilege, t restores its state when closed
+type scopedPrivilege struct {
+	t     *Token
+	users int
+}
+
+var (
+	scopedPrivilegesMu sync.Mutex
+	scopedPrivileges   = make(map[Privilege]*scopedPrivilege)
+)
+
+// WithPrivilege enables priv on the token of the current process, runs fn and restores the previous state of the
+// privilege, for instance to read files with SeBackupPrivilege. Concurrent calls for the same privilege share it,
+// the state is restored once the last of them returns. Since the process token is shared by every thread, other
+// goroutines also run with the privilege meanwhile. An error is returned without running fn if the process does not
+// hold the privilege
+func WithPrivilege(priv Privilege, fn func() error) error {
+	if err := acquireProcessPrivilege(priv); err != nil {
+		return err
+	}
+	defer releaseProcessPrivilege(priv)
+
+	return fn()
+}
+
+func acquireProcessPrivilege(priv Privilege) error {
+	scopedPrivilegesMu.Lock()
+	defer scopedPrivilegesMu.Unlock()
+
+	if sp, ok := scopedPrivileges[priv]; ok {
+		sp.users++
+		return nil
+	}
+
+	var h windows.Token
+	if err := windows.OpenProcessToken(windows.CurrentProcess(), windows.TOKEN_ADJUST_PRIVILEGES|windows.TOKEN_QUERY, &h); err != nil {
+		return fmt.Errorf("error while OpenProcessToken: %w", err)
+	}
+	t := newToken(h, TokenPrimary).withOptions(newOptions([]Option{WithRestorePrivilegesOnClose()}))
+	if _, err := t.EnableTokenPrivileges(priv); err != nil {
+		t.Close()
+		return err
+	}
+	scopedPrivileges[priv] = &scopedPrivilege{t: t, users: 1}
+	return nil
+}
+
+func releaseProcessPrivilege(priv Privilege) {
+	scopedPrivilegesMu.Lock()
+	defer scopedPrivilegesMu.Unlock()
+
+	sp := scopedPrivileges[priv]
+	if sp.users--; sp.users == 0 {
+		sp.t.Close()
+		delete(scopedPrivileges, priv)
+	}
+}
+
+// RunAsSystem starts exe as SYSTEM in the session of the caller, which must be an elevated administrator or a service.
+// SeDebugPrivilege is enabled on the calling process with WithPrivilege while a SYSTEM process is opened, see
+// GetSystemToken. The launch is done while impersonating SYSTEM since administrators do not hold
+// SeAssignPrimaryTokenPrivilege, which CreateProcessAsUser requires.
+// The returned Process must be released or waited on by the caller
+func RunAsSystem(exe string, args ...string) (*Process, error) {
+	var system *Token
+	err := WithPrivilege(SeDebugPrivilege, func() error {
+		var err error
+		system, err = GetSystemToken(TokenImpersonation)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer system.Close()
+
+	return startImpersonatingSystem(system, exe, args)
+}
+
+// startImpersonatingSystem launches exe with a primary copy of system, an impersonation token of a SYSTEM account,
+// in the session of the caller. It impersonates system to hold the privileges CreateProcessAsUser needs
+func startImpersonatingSystem(system *Token, exe string, args []string) (*Process, error) {
+	primary, err := system.ToPrimary()
+	if err != nil {
+		return nil, err
+	}
+	defer primary.Close()
+
+	if _, err := system.EnableTokenPrivileges(SeAssignPrimaryTokenPrivilege, SeIncreaseQuotaPrivilege, SeTcbPrivilege); err != nil {
+		return nil, err
+	}
+
+	var sessionID uint32
+	if err := windows.ProcessIdToSessionId(windows.GetCurrentProcessId(), &sessionID); err != nil {
+		return nil, fmt.Errorf("error while ProcessIdToSessionId: %w", err)
+	}
+
+	var p *Process
+	err = system.RunAs(func() error {
+		// the donor may run in another session, move the new process to the caller's session
+		if err := windows.SetTokenInformation(primary.token, windows.TokenSessionId, (*byte)(unsafe.Pointer(&sessionID)), uint32(unsafe.Sizeof(sessionID))); err != nil {
+			return fmt.Errorf("error while setting token session: %w", err)
+		}
+
+		var err error
+		p, err = primary.StartProcess(exe, args, nil)
+		return err
+	})
+	return p, err
+}
+
+// trustedInstallerStartTimeout is how long GetTrustedInstallerToken waits for the TrustedInstaller service to start
+const trustedInstallerStartTimeout = 10 * time.Second
+
+// GetTrustedInstallerToken gets the token of the TrustedInstaller service, which owns most of the protected
+// system files and registry keys. The demand-start service is started if needed, which requires administrator
+// rights, and SeDebugPrivilege is enabled on the calling process with WithPrivilege while it is opened
+func GetTrustedInstallerToken(tokenType tokenType, opts ...Option) (*Token, error) {
+	pid, err := startService("TrustedInstaller", trustedInstallerStartTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	var t *Token
+	err = WithPrivilege(SeDebugPrivilege, func() error {
+		var err error
+		t, err = OpenProcessToken(int(pid), tokenType, opts...)
+		return err
+	})
+	return t, err
+}
+
+// RunAsTrustedInstaller starts exe as SYSTEM with the TrustedInstaller group in the session of the caller,
+// so maintenance tools can modify files owned by TrustedInstaller. See GetTrustedInstallerToken and RunAsSystem.
+// The returned Process must be released or waited on by the caller
+func RunAsTrustedInstaller(exe string, args ...string) (*Process, error) {
+	ti, err := GetTrustedInstallerToken(TokenImpersonation)
+	if err != nil {
+		return nil, err
+	}
+	defer ti.Close()
+
+	return startImpersonatingSystem(ti, exe, args)
+}
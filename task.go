@@ -0,0 +1,239 @@
+package wintoken
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	clsidTaskScheduler = "{0F87369F-A4E5-4CFC-BD3E-73E6154572DD}"
+	iidTaskService     = "{2FABA4C7-4DA9-4013-9697-20CC3FD40F85}"
+
+	// method indexes, after the 3 IUnknown and 4 IDispatch methods
+	taskServiceGetFolder    = 7
+	taskServiceConnect      = 10
+	taskFolderDeleteTask    = 15
+	taskFolderRegisterTask  = 16
+	registeredTaskRunEx     = 13
+	runningTaskGetState     = 10
+	runningTaskRefresh      = 13
+	runningTaskGetEnginePID = 14
+
+	taskCreateOrUpdate          = 6
+	taskLogonS4U                = 2
+	taskLogonInteractiveToken   = 3
+	taskLogonGroup              = 4
+	taskLogonServiceAccount     = 5
+	taskRunIgnoreConstraints    = 0x2
+	taskRunUseSessionID         = 0x4
+	taskStateRunning            = 4
+	defaultScheduledTaskTimeout = 10 * time.Second
+)
+
+// ScheduledTask describes a one-shot task run by RunScheduledTask
+type ScheduledTask struct {
+	Command          string
+	Arguments        string
+	WorkingDirectory string
+	// User is the account the task runs as, DOMAIN\user or a SID. SYSTEM and the other service accounts run
+	// as services, other users with an S4U logon unless SessionID is set. When User is empty the task runs as
+	// the user logged on the SessionID session
+	User string
+	// SessionID runs the task in that session with the token of its interactive user, nil runs it non-interactively
+	SessionID *uint32
+	// Elevated runs the task with the highest privileges of the user rather than the filtered UAC token
+	Elevated bool
+	// Timeout bounds the wait for the task process to start, it defaults to 10 seconds
+	Timeout time.Duration
+}
+
+// principal returns the task XML principal and its TASK_LOGON_TYPE
+func (s ScheduledTask) principal() (string, uint32) {
+	runLevel := "LeastPrivilege"
+	if s.Elevated {
+		runLevel = "HighestAvailable"
+	}
+
+	if s.User == "" {
+		return fmt.Sprintf("<GroupId>%s</GroupId><RunLevel>%s</RunLevel>", SIDInteractive, runLevel), taskLogonGroup
+	}
+
+	logonType, logonTypeName := uint32(taskLogonS4U), "S4U"
+	if s.SessionID != nil {
+		logonType, logonTypeName = taskLogonInteractiveToken, "InteractiveToken"
+	}
+	sid, err := windows.StringToSid(s.User)
+	if err != nil {
+		sid, _, _, err = windows.LookupSID("", s.User)
+	}
+	if err == nil {
+		switch sid.String() {
+		case SIDLocalSystem, SIDLocalService, SIDNetworkService:
+			logonType, logonTypeName = taskLogonServiceAccount, "ServiceAccount"
+		}
+	}
+	return fmt.Sprintf("<UserId>%s</UserId><LogonType>%s</LogonType><RunLevel>%s</RunLevel>", escapeXML(s.User), logonTypeName, runLevel), logonType
+}
+
+// definition returns the task XML of s
+func (s ScheduledTask) definition() (string, uint32) {
+	principal, logonType := s.principal()
+
+	var exec strings.Builder
+	fmt.Fprintf(&exec, "<Command>%s</Command>", escapeXML(s.Command))
+	if s.Arguments != "" {
+		fmt.Fprintf(&exec, "<Arguments>%s</Arguments>", escapeXML(s.Arguments))
+	}
+	if s.WorkingDirectory != "" {
+		fmt.Fprintf(&exec, "<WorkingDirectory>%s</WorkingDirectory>", escapeXML(s.WorkingDirectory))
+	}
+
+	return `<?xml version="1.0" encoding="UTF-16"?>` +
+		`<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">` +
+		`<Principals><Principal id="Author">` + principal + `</Principal></Principals>` +
+		`<Settings><MultipleInstancesPolicy>Parallel</MultipleInstancesPolicy>` +
+		`<DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries><StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>` +
+		`<ExecutionTimeLimit>PT0S</ExecutionTimeLimit><AllowStartOnDemand>true</AllowStartOnDemand><Hidden>true</Hidden></Settings>` +
+		`<Actions Context="Author"><Exec>` + exec.String() + `</Exec></Actions>` +
+		`</Task>`, logonType
+}
+
+func escapeXML(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// RunScheduledTask registers a hidden on-demand task in the Task Scheduler root folder, runs it and deletes it once
+// its process started, returning the process ID. It is a fallback to CreateProcessAsUser when the caller cannot
+// launch processes as the user directly, registering tasks for other users requires administrator rights
+func RunScheduledTask(task ScheduledTask) (uint32, error) {
+	start := time.Now()
+	var sessionID uint32
+	if task.SessionID != nil {
+		sessionID = *task.SessionID
+	}
+
+	var pid uint32
+	err := withCOM(func() error {
+		var err error
+		pid, err = runScheduledTask(task)
+		return err
+	})
+	audit(AuditLaunch, start, int(pid), sessionID, 0, err)
+	return pid, err
+}
+
+func runScheduledTask(task ScheduledTask) (uint32, error) {
+	svc, err := coCreateInstance(clsidTaskScheduler, iidTaskService)
+	if err != nil {
+		return 0, err
+	}
+	defer svc.Release()
+
+	var empty variant
+	args := make([]uintptr, 0, 16)
+	for i := 0; i < 4; i++ {
+		args = append(args, variantArgs(&empty)...)
+	}
+	if err := svc.call(taskServiceConnect, args...); err != nil {
+		return 0, fmt.Errorf("error while ITaskService::Connect: %w", err)
+	}
+
+	root, err := newBSTR(`\`)
+	if err != nil {
+		return 0, err
+	}
+	defer root.free()
+	var folder *comObject
+	if err := svc.call(taskServiceGetFolder, uintptr(root), uintptr(unsafe.Pointer(&folder))); err != nil {
+		return 0, fmt.Errorf("error while ITaskService::GetFolder: %w", err)
+	}
+	defer folder.Release()
+
+	name, err := newBSTR(fmt.Sprintf("wintoken-%d-%d", os.Getpid(), time.Now().UnixNano()))
+	if err != nil {
+		return 0, err
+	}
+	defer name.free()
+	definition, logonType := task.definition()
+	xmlText, err := newBSTR(definition)
+	if err != nil {
+		return 0, err
+	}
+	defer xmlText.free()
+
+	var registered *comObject
+	args = append([]uintptr{uintptr(name), uintptr(xmlText), taskCreateOrUpdate}, variantArgs(&empty)...)
+	args = append(args, variantArgs(&empty)...)
+	args = append(args, uintptr(logonType))
+	args = append(args, variantArgs(&empty)...)
+	if err := folder.call(taskFolderRegisterTask, append(args, uintptr(unsafe.Pointer(&registered)))...); err != nil {
+		return 0, fmt.Errorf("error while ITaskFolder::RegisterTask: %w", err)
+	}
+	defer registered.Release()
+	defer folder.call(taskFolderDeleteTask, uintptr(name), 0)
+
+	var (
+		flags     uintptr = taskRunIgnoreConstraints
+		sessionID uintptr
+		running   *comObject
+	)
+	if task.SessionID != nil {
+		flags |= taskRunUseSessionID
+		sessionID = uintptr(*task.SessionID)
+	}
+	args = append(variantArgs(&empty), flags, sessionID, 0, uintptr(unsafe.Pointer(&running)))
+	if err := registered.call(registeredTaskRunEx, args...); err != nil {
+		return 0, fmt.Errorf("error while IRegisteredTask::RunEx: %w", err)
+	}
+	defer running.Release()
+
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = defaultScheduledTaskTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		var (
+			pid   uint32
+			state uint32
+		)
+		if err := running.call(runningTaskRefresh); err != nil {
+			// the instance is gone, its process already exited
+			return 0, ErrTaskProcessNotFound
+		}
+		running.call(runningTaskGetState, uintptr(unsafe.Pointer(&state)))
+		if state == taskStateRunning {
+			if err := running.call(runningTaskGetEnginePID, uintptr(unsafe.Pointer(&pid))); err == nil && pid != 0 {
+				return pid, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return 0, ErrTaskProcessNotFound
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// ScheduledTaskToken runs task with RunScheduledTask and captures the token of the started process, for callers
+// which only need a token of the user. The process keeps running, the caller can terminate it once the token is
+// captured
+func ScheduledTaskToken(task ScheduledTask, tokenType tokenType, opts ...Option) (*Token, uint32, error) {
+	pid, err := RunScheduledTask(task)
+	if err != nil {
+		return nil, 0, err
+	}
+	t, err := OpenProcessToken(int(pid), tokenType, opts...)
+	if err != nil {
+		return nil, pid, err
+	}
+	return t, pid, nil
+}
@@ -0,0 +1,52 @@
+package wintoken
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// tcbAvailable reports whether the calling process has SeTcbPrivilege enabled, which is the case for services
+// running as LocalSystem. Such callers get user tokens from WTSQueryUserToken and logons instead of opening other
+// processes, which keeps working where SeDebugPrivilege is stripped by policy
+func tcbAvailable() bool {
+	luid, err := privilegeLUID(SeTcbPrivilege)
+	if err != nil {
+		return false
+	}
+
+	enabled := false
+	withTokenInfo(windows.GetCurrentProcessToken(), windows.TokenPrivileges, func(b []byte) error {
+		for _, p := range (*windows.Tokenprivileges)(unsafe.Pointer(&b[0])).AllPrivileges() {
+			if p.Luid == luid {
+				enabled = p.Attributes&windows.SE_PRIVILEGE_ENABLED != 0
+				break
+			}
+		}
+		return nil
+	})
+	return enabled
+}
+
+// isLocalSystem reports whether the calling process runs as LocalSystem
+func isLocalSystem() bool {
+	return tokenUserSID(windows.GetCurrentProcessToken()) == SIDLocalSystem
+}
+
+// sessionTokenSource gets the token of the user of a session with WTSQueryUserToken if the caller holds
+// SeTcbPrivilege and the token is accepted by match, it returns nil otherwise so callers fall back to process scans
+func sessionTokenSource(sessionID uint32, match func(t windows.Token) bool, tokenType tokenType, opts []Option) *Token {
+	if !tcbAvailable() {
+		return nil
+	}
+
+	t, err := GetSessionToken(sessionID, tokenType, opts...)
+	if err != nil {
+		return nil
+	}
+	if match != nil && !match(t.token) {
+		t.Close()
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,55 @@
+package wintoken
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// setThreadToken sets or, with a 0 token, clears the impersonation token of the thread tid
+func setThreadToken(tid int, token windows.Token) error {
+	h, err := windows.OpenThread(windows.THREAD_SET_THREAD_TOKEN, false, uint32(tid))
+	if err != nil {
+		return fmt.Errorf("error while OpenThread: %w", err)
+	}
+	defer windows.CloseHandle(h)
+
+	if err := windows.SetThreadToken(&h, token); err != nil {
+		return fmt.Errorf("error while SetThreadToken: %w", err)
+	}
+	return nil
+}
+
+// AssignToThread makes the thread tid impersonate the token, so a controller can put a token on worker threads it manages.
+// Primary tokens are duplicated into an impersonation token first. The thread keeps impersonating after the Token is closed,
+// until ClearThreadToken is called or the thread reverts itself
+func (t *Token) AssignToThread(tid int) error {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return err
+	}
+	defer t.release()
+
+	token := t.token
+	windowsType, err := tokenTypeOf(t.token)
+	if err != nil {
+		return err
+	}
+	if windowsType == windows.TokenPrimary {
+		if err := windows.DuplicateTokenEx(t.token, windows.MAXIMUM_ALLOWED, nil, windows.SecurityImpersonation, windows.TokenImpersonation, &token); err != nil {
+			return fmt.Errorf("error while DuplicateTokenEx: %w", err)
+		}
+		defer windows.CloseHandle(windows.Handle(token))
+	}
+
+	err = setThreadToken(tid, token)
+	auditRecord(AuditRecord{Operation: AuditImpersonate, Detail: fmt.Sprintf("thread %d", tid), Err: err}, start, t.token)
+	return err
+}
+
+// ClearThreadToken removes the impersonation token of the thread tid, reverting it to the process token
+func ClearThreadToken(tid int) error {
+	return setThreadToken(tid, 0)
+}
@@ -0,0 +1,202 @@
+package wintoken
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// tokenInfoUint32 queries a token information class whose value is a single DWORD
+func tokenInfoUint32(t windows.Token, class uint32) (uint32, error) {
+	var v, n uint32
+	if err := windows.GetTokenInformation(t, class, (*byte)(unsafe.Pointer(&v)), uint32(unsafe.Sizeof(v)), &n); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
+
+// tokenTypeOf returns the Windows token type of t, windows.TokenPrimary or windows.TokenImpersonation
+func tokenTypeOf(t windows.Token) (uint32, error) {
+	windowsType, err := tokenInfoUint32(t, windows.TokenType)
+	if err != nil {
+		return 0, fmt.Errorf("error while getting token type: %w", err)
+	}
+	return windowsType, nil
+}
+
+// Token information classes newer than the ones defined in golang.org/x/sys/windows
+const (
+	tokenUserClaimAttributes   = 33
+	tokenDeviceClaimAttributes = 34
+	tokenDeviceGroups          = 37
+)
+
+// getTokenInfo queries a variable sized token information class into a new buffer
+func getTokenInfo(t windows.Token, class uint32) ([]byte, error) {
+	n := uint32(0)
+	windows.GetTokenInformation(t, class, nil, 0, &n)
+	if n == 0 {
+		n = 64
+	}
+
+	for {
+		b := make([]byte, n)
+		err := windows.GetTokenInformation(t, class, &b[0], uint32(len(b)), &n)
+		if err == nil {
+			return b[:n], nil
+		}
+		if err != windows.ERROR_INSUFFICIENT_BUFFER || n <= uint32(len(b)) {
+			return nil, err
+		}
+	}
+}
+
+// tokenInfoBuffers are reused by withTokenInfo, so scanning many processes does not allocate a buffer per query
+var tokenInfoBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 512)
+		return &b
+	},
+}
+
+// withTokenInfo queries a token information class into a pooled buffer and passes it to fn. The buffer is only valid
+// until fn returns, fn must copy anything it keeps. It is meant for hot paths such as process scans
+func withTokenInfo(t windows.Token, class uint32, fn func(b []byte) error) error {
+	bp := tokenInfoBuffers.Get().(*[]byte)
+	defer tokenInfoBuffers.Put(bp)
+
+	for {
+		var n uint32
+		err := windows.GetTokenInformation(t, class, &(*bp)[0], uint32(len(*bp)), &n)
+		if err == nil {
+			return fn((*bp)[:n])
+		}
+		if err != windows.ERROR_INSUFFICIENT_BUFFER || n <= uint32(len(*bp)) {
+			return err
+		}
+		*bp = make([]byte, n)
+	}
+}
+
+// info returns a token information class, from the cache if the Token was created with WithInfoCache.
+// The returned buffer is shared with the cache and must not be modified. The caller must have acquired t
+func (t *Token) info(class uint32) ([]byte, error) {
+	t.mu.Lock()
+	cache := t.cache
+	b, ok := cache[class]
+	t.mu.Unlock()
+	if ok {
+		return b, nil
+	}
+
+	b, err := getTokenInfo(t.token, class)
+	if err != nil || cache == nil {
+		return b, err
+	}
+
+	// store into the map the query started from, if invalidate replaced it meanwhile b may predate the change and
+	// is dropped with the old map
+	t.mu.Lock()
+	cache[class] = b
+	t.mu.Unlock()
+	return b, nil
+}
+
+// QueryRaw returns the raw GetTokenInformation buffer of any TOKEN_INFORMATION_CLASS, such as windows.TokenOrigin
+// or classes this package does not decode. Pointers inside the buffer, as in TOKEN_GROUPS, point into the buffer
+// itself, so it must be kept alive while they are used. The buffer is always queried from the OS, bypassing
+// WithInfoCache, and belongs to the caller
+func (t *Token) QueryRaw(class uint32) ([]byte, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	b, err := getTokenInfo(t.token, class)
+	if err != nil {
+		return nil, fmt.Errorf("error while getting token information class %d: %w", class, err)
+	}
+	return b, nil
+}
+
+// SetRaw sets any TOKEN_INFORMATION_CLASS from a raw buffer with SetTokenInformation, for classes without a typed
+// wrapper such as SetIntegrityLevel. The buffer layout is not checked, pointers inside it must point into the buffer
+// itself or to memory kept alive by the caller. The handle needs TOKEN_ADJUST_DEFAULT and, depending on the class,
+// privileges such as SeTcbPrivilege for TokenSessionId. The information cached with WithInfoCache is dropped
+func (t *Token) SetRaw(class uint32, buf []byte) error {
+	if err := t.acquire(); err != nil {
+		return err
+	}
+	defer t.release()
+
+	var p *byte
+	if len(buf) > 0 {
+		p = &buf[0]
+	}
+	err := windows.SetTokenInformation(t.token, class, p, uint32(len(buf)))
+	runtime.KeepAlive(buf)
+	if err != nil {
+		return fmt.Errorf("error while setting token information class %d: %w", class, err)
+	}
+	t.invalidate()
+	return nil
+}
+
+// invalidate drops the cached token information and the primary copy of SysProcAttrToken after the token was
+// modified through the Token
+func (t *Token) invalidate() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cache != nil {
+		t.cache = make(map[uint32][]byte)
+		t.cacheGen++
+	}
+	// the primary copy of SysProcAttrToken was duplicated before the change, the next call makes a new one
+	if t.primary != 0 {
+		windows.CloseHandle(windows.Handle(t.primary))
+		t.primary = 0
+	}
+}
+
+// Refresh re-queries every cached token information class from the OS, for tokens modified outside of the Token,
+// for example by another component holding the same handle. It does nothing unless the Token was created with WithInfoCache
+func (t *Token) Refresh() error {
+	if err := t.acquire(); err != nil {
+		return err
+	}
+	defer t.release()
+
+	t.mu.Lock()
+	if t.cache == nil {
+		t.mu.Unlock()
+		return nil
+	}
+	gen := t.cacheGen
+	classes := make([]uint32, 0, len(t.cache))
+	for class := range t.cache {
+		classes = append(classes, class)
+	}
+	t.mu.Unlock()
+
+	fresh := make(map[uint32][]byte, len(classes))
+	for _, class := range classes {
+		b, err := getTokenInfo(t.token, class)
+		if err != nil {
+			t.invalidate()
+			return fmt.Errorf("error while refreshing token information class %d: %w", class, err)
+		}
+		fresh[class] = b
+	}
+
+	// the token was modified through the Token meanwhile, fresh may predate the change and is dropped
+	t.mu.Lock()
+	if t.cacheGen == gen {
+		t.cache = fresh
+	}
+	t.mu.Unlock()
+	return nil
+}
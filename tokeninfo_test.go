@@ -0,0 +1,99 @@
+package wintoken
+
+import (
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// openScanTokens opens the tokens of every process the caller can query, as a polling agent does on each scan
+func openScanTokens(tb testing.TB) []windows.Token {
+	processes, err := enumerateProcesses()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var tokens []windows.Token
+	for _, p := range processes {
+		if p.pid == 0 {
+			continue
+		}
+		if t, err := openProcessToken(int(p.pid), windows.TOKEN_QUERY); err == nil {
+			tokens = append(tokens, t)
+		}
+	}
+	if len(tokens) == 0 {
+		tb.Skip("no process token can be opened")
+	}
+	return tokens
+}
+
+func closeScanTokens(tokens []windows.Token) {
+	for _, t := range tokens {
+		windows.CloseHandle(windows.Handle(t))
+	}
+}
+
+// BenchmarkGetTokenInfo is the allocating query path, one buffer per call
+func BenchmarkGetTokenInfo(b *testing.B) {
+	t := windows.GetCurrentProcessToken()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := getTokenInfo(t, windows.TokenGroups); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkWithTokenInfo is the pooled query path, it should not allocate once the pool is warm
+func BenchmarkWithTokenInfo(b *testing.B) {
+	t := windows.GetCurrentProcessToken()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := withTokenInfo(t, windows.TokenGroups, func(buf []byte) error {
+			_ = (*windows.Tokengroups)(unsafe.Pointer(&buf[0])).GroupCount
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkScanQuery matches every queryable process token against a Query, one op being one scan of the
+// "scan 500 processes every 10 seconds" agent loop without the cost of opening the processes
+func BenchmarkScanQuery(b *testing.B) {
+	tokens := openScanTokens(b)
+	defer closeScanTokens(tokens)
+
+	tq, err := Query{MinIntegrity: IntegrityMedium, RequiredPrivilege: SeChangeNotifyPrivilege}.resolve()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, t := range tokens {
+			tq.matches(t)
+		}
+	}
+	b.ReportMetric(float64(len(tokens)), "processes/op")
+}
+
+// BenchmarkScan is a full scan, enumerating and opening the processes and matching their tokens
+func BenchmarkScan(b *testing.B) {
+	tq, err := Query{MinIntegrity: IntegrityMedium}.resolve()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tokens := openScanTokens(b)
+		for _, t := range tokens {
+			tq.matches(t)
+		}
+		closeScanTokens(tokens)
+	}
+}
@@ -0,0 +1,74 @@
+package wintoken
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// TokenTransfer describes a token handle duplicated into another process so it can be passed to that process
+// over any IPC channel, such as JSON over a named pipe. The handle value is only meaningful inside PID.
+//
+// Ownership: once Send returns, the handle belongs to the target process and the broker keeps its own Token.
+// The target takes ownership with ReceiveToken. If the transfer is never delivered, the broker should call Revoke
+// so the handle does not leak in the target process
+type TokenTransfer struct {
+	// PID is the process the handle was duplicated into
+	PID uint32 `json:"pid"`
+	// Handle is the handle value in the target process
+	Handle uint64 `json:"handle"`
+	// Type is the wintoken type of the token
+	Type tokenType `json:"type"`
+}
+
+// Send duplicates the token handle into the process pid with the same access and returns the descriptor
+// to hand to that process. It requires PROCESS_DUP_HANDLE access to the target process
+func (t *Token) Send(pid int) (*TokenTransfer, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
+
+	target, err := windows.OpenProcess(windows.PROCESS_DUP_HANDLE, false, uint32(pid))
+	if err != nil {
+		return nil, fmt.Errorf("error while OpenProcess: %w", err)
+	}
+	defer windows.CloseHandle(target)
+
+	var h windows.Handle
+	if err := windows.DuplicateHandle(windows.CurrentProcess(), windows.Handle(t.token), target, &h, 0, false, windows.DUPLICATE_SAME_ACCESS); err != nil {
+		return nil, fmt.Errorf("error while DuplicateHandle: %w", err)
+	}
+
+	return &TokenTransfer{PID: uint32(pid), Handle: uint64(h), Type: t.typ}, nil
+}
+
+// Revoke closes the handle in the target process, for transfers which were not delivered or are no longer wanted.
+// It must not be called once the target has received the token
+func (tr *TokenTransfer) Revoke() error {
+	target, err := windows.OpenProcess(windows.PROCESS_DUP_HANDLE, false, tr.PID)
+	if err != nil {
+		return fmt.Errorf("error while OpenProcess: %w", err)
+	}
+	defer windows.CloseHandle(target)
+
+	if err := windows.DuplicateHandle(target, windows.Handle(tr.Handle), 0, nil, 0, false, windows.DUPLICATE_CLOSE_SOURCE); err != nil {
+		return fmt.Errorf("error while closing transferred handle: %w", err)
+	}
+	return nil
+}
+
+// ReceiveToken takes ownership of a handle sent to the current process with Send, the returned Token closes it on Close.
+// It fails if the transfer was meant for another process or the handle is not a token
+func ReceiveToken(tr TokenTransfer, opts ...Option) (*Token, error) {
+	if tr.PID != windows.GetCurrentProcessId() {
+		return nil, fmt.Errorf("token transfer is for process %d, not %d", tr.PID, windows.GetCurrentProcessId())
+	}
+
+	h := windows.Token(tr.Handle)
+	if _, err := tokenTypeOf(h); err != nil {
+		return nil, fmt.Errorf("transferred handle is not a token: %w", err)
+	}
+
+	return newToken(h, tr.Type).withOptions(newOptions(opts)), nil
+}
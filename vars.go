@@ -7,5 +7,24 @@ var (
 	ErrInvalidDuplicatedToken               error = fmt.Errorf("invalid duplicated token")
 	ErrOnlyPrimaryImpersonationTokenAllowed error = fmt.Errorf("only primary or impersonation token types allowed")
 	ErrNoPrivilegesSpecified                error = fmt.Errorf("no privileges specified")
+	ErrPrivilegeNotHeld                     error = fmt.Errorf("privilege is not held by the token")
 	ErrTokenClosed                          error = fmt.Errorf("token has been closed")
+	ErrNoConsoleSession                     error = fmt.Errorf("no session is attached to the console")
+	ErrNoMatchingProcess                    error = fmt.Errorf("no process matching the requested token found")
+	ErrUnknownServiceAccount                error = fmt.Errorf("unknown service account")
+	ErrNoAppContainerSID                    error = fmt.Errorf("no AppContainer SID specified")
+	ErrServiceNotRunning                    error = fmt.Errorf("service is not running")
+	ErrNotNamedPipe                         error = fmt.Errorf("connection does not expose a named pipe handle")
+	ErrProtectedProcess                     error = fmt.Errorf("process is protected")
+	ErrNoMatchingSession                    error = fmt.Errorf("no session matching the request found")
+	ErrNotSplitToken                        error = fmt.Errorf("token is not part of a UAC split token")
+	ErrLogonTypeNotGranted                  error = fmt.Errorf("account is not granted the logon type")
+	ErrTaskProcessNotFound                  error = fmt.Errorf("scheduled task process was not found")
+	ErrImpersonationDegraded                error = fmt.Errorf("impersonation degraded to a lower impersonation level")
+	ErrImpersonationUserMismatch            error = fmt.Errorf("thread token user does not match the impersonated token")
+	ErrImpersonationThread                  error = fmt.Errorf("impersonation reverted from a thread other than the impersonating one")
+	ErrStartOptionUnsupported               error = fmt.Errorf("start option is not supported by the launch API")
+	ErrNilRoundTripper                      error = fmt.Errorf("an SSPI authenticating base round tripper is required")
+	ErrNoRestrictingSIDs                    error = fmt.Errorf("write-restricted token requires restricting SIDs")
+	ErrPipeCommandTooLong                   error = fmt.Errorf("pipe command is too long")
 )
@@ -0,0 +1,43 @@
+package wintoken
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+// Domains of the virtual accounts Windows creates for services and IIS application pools
+const (
+	VirtualDomainService = "NT SERVICE"
+	VirtualDomainAppPool = "IIS APPPOOL"
+)
+
+// VirtualAccountLogon logs on a virtual account such as NT SERVICE\MSSQLSERVER or IIS APPPOOL\DefaultAppPool, the
+// identities services and application pools run as, without a password. The logon is a service logon through the
+// virtual logon provider of LogonUserExExW, as the service control manager does, so the caller needs SeTcbPrivilege.
+// The account must exist, that is the service must be installed or the application pool created
+func VirtualAccountLogon(account string, tokenType tokenType, opts ...Option) (*Token, error) {
+	i := strings.IndexByte(account, '\\')
+	if i < 0 {
+		return nil, fmt.Errorf("virtual account %q is not in the DOMAIN\\name form", account)
+	}
+	domain, name := account[:i], account[i+1:]
+
+	if _, _, _, err := windows.LookupSID("", account); err != nil {
+		return nil, fmt.Errorf("error while looking up %s: %w", account, err)
+	}
+
+	lt, err := logonUserGroups(name, domain, "", LogonService, logon32ProviderVirtual, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer windows.CloseHandle(windows.Handle(lt))
+
+	o := newOptions(opts)
+	duplicatedToken, err := duplicateToken(lt, tokenType, o)
+	if err != nil {
+		return nil, err
+	}
+	return newToken(duplicatedToken, tokenType).withOptions(o), nil
+}
@@ -3,7 +3,11 @@ package wintoken
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"runtime"
+	"sync"
+	"time"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -13,6 +17,10 @@ var (
 	modadvapi32                    = windows.NewLazySystemDLL("advapi32.dll")
 	procLookupPrivilegeName        = modadvapi32.NewProc("LookupPrivilegeNameW")
 	procLookupPrivilegeDisplayName = modadvapi32.NewProc("LookupPrivilegeDisplayNameW")
+	procAdjustTokenPrivileges      = modadvapi32.NewProc("AdjustTokenPrivileges")
+
+	// privilegeDisplayNames caches display names, they never change during the lifetime of the process
+	privilegeDisplayNames sync.Map
 )
 
 type (
@@ -29,26 +37,63 @@ const (
 type Token struct {
 	typ   tokenType
 	token windows.Token
+
+	// id identifies the token in the leak detector, it is 0 while leak detection is disabled
+	id uint64
+
+	// mu guards token, closed and refs. Close only releases the handle
+	// once every operation which acquired it has finished
+	mu     sync.Mutex
+	closed bool
+	refs   int
+
+	// borrowed tokens wrap a handle owned by someone else, it is never closed by the package
+	borrowed bool
+	// primary is a primary duplicate handed out by SysProcAttrToken, it is closed with the token
+	primary windows.Token
+
+	// access is the access obtained on the source process token by OpenProcessToken, 0 otherwise
+	access uint32
+
+	// cache holds the token information classes queried so far, it is nil unless WithInfoCache was used.
+	// It is guarded by mu
+	cache map[uint32][]byte
+	// cacheGen is incremented whenever cache is replaced by invalidate, so Refresh can detect a concurrent change
+	cacheGen uint64
+
+	// restore maps the privileges modified through the Token to their original attributes,
+	// it is nil unless WithRestorePrivilegesOnClose was used. It is guarded by mu
+	restore map[windows.LUID]uint32
 }
 
 //TokenUserDetail is the structure that exposes token details
-//Details contain Username, Domain, Account Type, User Profile Directory, Environment
+//Details contain Username, Domain, SID, SAM account name, UPN, Account Type, User Profile Directory, Environment, Integrity Level
 type TokenUserDetail struct {
-	Username       string
-	Domain         string
-	AccountType    uint32
+	Username    string
+	Domain      string
+	AccountType uint32
+	// SID is the user SID in string form, the stable key of the account
+	SID string
+	// SAMAccountName is the down-level logon name DOMAIN\user
+	SAMAccountName string
+	// UPN is the user principal name from the logon session, empty for local accounts or when the logon session cannot be queried
+	UPN            string
 	UserProfileDir string
 	Environ        []string
+	// IntegrityLevel is decoded from the mandatory label SID, IntegrityName is its friendly name such as "High"
+	IntegrityLevel IntegrityLevel
+	IntegrityName  string
 }
 
 func (t TokenUserDetail) String() string {
-	return fmt.Sprintf("Username: %s, Domain: %s, Account Type: %d, UserProfileDir: %s", t.Username, t.Domain, t.AccountType, t.UserProfileDir)
+	return fmt.Sprintf("Username: %s, Domain: %s, SID: %s, UPN: %s, Account Type: %d, UserProfileDir: %s, Integrity: %s", t.Username, t.Domain, t.SID, t.UPN, t.AccountType, t.UserProfileDir, t.IntegrityName)
 }
 
-//Privilege is the structure which exposes privilege details
+//PrivilegeDetail is the structure which exposes privilege details
 //Details contain Name, Description, Enabled, EnabledByDefault, Removed, UsedForAccess
-type Privilege struct {
-	Name             string
+type PrivilegeDetail struct {
+	Name Privilege
+	// Description is the display name of the privilege, such as "Act as part of the operating system"
 	Description      string
 	Enabled          bool
 	EnabledByDefault bool
@@ -56,12 +101,13 @@ type Privilege struct {
 	UsedForAccess    bool
 }
 
-func (p Privilege) String() string {
-	status := "Disabled"
-	if p.Removed {
-		status = "Removed"
-	} else if p.Enabled {
-		status = "Enabled"
+func (p PrivilegeDetail) String() string {
+	status := p.State().String()
+	if p.EnabledByDefault {
+		status += ", EnabledByDefault"
+	}
+	if p.UsedForAccess {
+		status += ", UsedForAccess"
 	}
 	return fmt.Sprintf("%s: %s", p.Name, status)
 }
@@ -71,40 +117,163 @@ const (
 	TokenPrimary
 	TokenImpersonation
 	TokenLinked
+	// TokenIdentification is a query-only impersonation token at SecurityIdentification level.
+	// It can be used to inspect the user, groups and privileges of a token but never to impersonate it
+	TokenIdentification
+)
+
+// ImpersonationLevel is the SECURITY_IMPERSONATION_LEVEL of an impersonation token
+type ImpersonationLevel uint32
+
+const (
+	SecurityAnonymous      ImpersonationLevel = windows.SecurityAnonymous
+	SecurityIdentification ImpersonationLevel = windows.SecurityIdentification
+	SecurityImpersonation  ImpersonationLevel = windows.SecurityImpersonation
+	SecurityDelegation     ImpersonationLevel = windows.SecurityDelegation
 )
 
+func (l ImpersonationLevel) String() string {
+	switch l {
+	case SecurityAnonymous:
+		return "Anonymous"
+	case SecurityIdentification:
+		return "Identification"
+	case SecurityImpersonation:
+		return "Impersonation"
+	case SecurityDelegation:
+		return "Delegation"
+	default:
+		return "Unknown"
+	}
+}
+
 //NewToken can be used to supply your own token for the wintoken struct
 //so you can use the same flexiblity provided by the package
-func NewToken(token windows.Token, typ tokenType) *Token {
-	return &Token{
-		token: token,
-		typ:   typ,
+//The Token takes ownership of the handle and closes it on Close
+func NewToken(token windows.Token, typ tokenType, opts ...Option) *Token {
+	return newToken(token, typ).withOptions(newOptions(opts))
+}
+
+//NewTokenFromWindowsToken duplicates a token obtained elsewhere, such as from golang.org/x/sys/windows/svc code,
+//into a new managed Token of the requested type. The impersonation level can be chosen with WithImpersonationLevel.
+//The caller keeps ownership of token and may close it as soon as this function returns
+func NewTokenFromWindowsToken(token windows.Token, tokenType tokenType, opts ...Option) (*Token, error) {
+	duplicatedToken, err := duplicateToken(token, tokenType, newOptions(opts))
+	if err != nil {
+		return nil, err
 	}
+
+	return newToken(duplicatedToken, tokenType).withOptions(newOptions(opts)), nil
 }
 
-//Token returns the underlying token for use
+//FromHandle wraps a handle obtained from other APIs. If owned is true the Token takes ownership
+//of the handle like NewToken does, otherwise the handle is borrowed: Close only marks the Token
+//closed and the caller remains responsible for closing the handle after the Token is no longer used
+func FromHandle(h windows.Token, typ tokenType, owned bool, opts ...Option) *Token {
+	if owned {
+		return newToken(h, typ).withOptions(newOptions(opts))
+	}
+	return (&Token{token: h, typ: typ, borrowed: true}).withOptions(newOptions(opts))
+}
+
+// withOptions applies the options which affect the Token itself rather than its acquisition
+func (t *Token) withOptions(o options) *Token {
+	if o.restorePrivileges {
+		t.restore = make(map[windows.LUID]uint32)
+	}
+	if o.infoCache {
+		t.cache = make(map[uint32][]byte)
+	}
+	return t
+}
+
+// derivedOptions returns the options withOptions applied to t, so a token derived from t behaves the same
+func (t *Token) derivedOptions() options {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return options{restorePrivileges: t.restore != nil, infoCache: t.cache != nil}
+}
+
+//Token returns the underlying token for use, the handle is only valid until the Token is closed.
+//The handle is also closed by a finalizer once the Token is no longer reachable, so the Token must be kept alive,
+//for example with runtime.KeepAlive, for as long as the handle is used. Use DuplicateHandle for a handle the caller owns
 func (t *Token) Token() windows.Token {
 	return t.token
 }
 
-//Close closes the underlying token
+//Handle returns the underlying token handle, it is the same as Token
+func (t *Token) Handle() windows.Token {
+	return t.token
+}
+
+//ObtainedAccess returns the access mask OpenProcessToken could open the process token with after falling back
+//from the requested access, it is 0 for tokens acquired in other ways. Without TOKEN_DUPLICATE the Token wraps
+//the process token itself, so only the operations allowed by this mask succeed
+func (t *Token) ObtainedAccess() uint32 {
+	return t.access
+}
+
+//Close closes the underlying token, it is safe to call Close more than once and concurrently with other methods.
+//The handle is released once the operations already running on the token have returned
 func (t *Token) Close() {
-	windows.Close(windows.Handle(t.token))
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed || t.token == 0 {
+		return
+	}
+	t.closed = true
+
+	runtime.SetFinalizer(t, nil)
+	untrackToken(t)
+
+	if t.refs == 0 {
+		t.closeHandle()
+	}
+}
+
+// closeHandle restores the recorded privileges and releases the handle unless it is borrowed, t.mu must be held
+func (t *Token) closeHandle() {
+	t.restorePrivileges()
+
+	if !t.borrowed {
+		windows.CloseHandle(windows.Handle(t.token))
+	}
 	t.token = 0
+
+	if t.primary != 0 {
+		windows.CloseHandle(windows.Handle(t.primary))
+		t.primary = 0
+	}
 }
 
-func (t *Token) errIfTokenClosed() error {
-	if t.token == 0 {
+// acquire marks the start of an operation using the handle so a concurrent Close cannot release it mid-call.
+// Every successful acquire must be paired with a release
+func (t *Token) acquire() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed || t.token == 0 {
 		return ErrTokenClosed
 	}
+	t.refs++
 	return nil
 }
 
+func (t *Token) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.refs--
+	if t.closed && t.refs == 0 {
+		t.closeHandle()
+	}
+}
+
 func lookupPrivilegeNameByLUID(luid uint64) (string, string, error) {
 	nameBuffer := make([]uint16, 256)
 	nameBufferSize := uint32(len(nameBuffer))
-	displayNameBuffer := make([]uint16, 256)
-	displayNameBufferSize := uint32(len(displayNameBuffer))
 
 	sysName, err := windows.UTF16PtrFromString("")
 	if err != nil {
@@ -115,16 +284,51 @@ func lookupPrivilegeNameByLUID(luid uint64) (string, string, error) {
 		return "", "", err
 	}
 
-	var langID uint32
-	if r1, _, err := procLookupPrivilegeDisplayName.Call(uintptr(unsafe.Pointer(sysName)), uintptr(unsafe.Pointer(&nameBuffer[0])), uintptr(unsafe.Pointer(&displayNameBuffer[0])), uintptr(unsafe.Pointer(&displayNameBufferSize)), uintptr(unsafe.Pointer(&langID))); r1 == 0 {
+	name := windows.UTF16ToString(nameBuffer)
+	displayName, err := PrivilegeDisplayName(name)
+	if err != nil {
 		return "", "", err
 	}
 
-	return windows.UTF16ToString(nameBuffer), windows.UTF16ToString(displayNameBuffer), nil
+	return name, displayName, nil
+}
+
+// PrivilegeDisplayName returns the localized display name of a privilege using LookupPrivilegeDisplayNameW,
+// such as "Debug programs" for SeDebugPrivilege
+func PrivilegeDisplayName(name string) (string, error) {
+	if displayName, ok := privilegeDisplayNames.Load(name); ok {
+		return displayName.(string), nil
+	}
+
+	namePtr, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return "", err
+	}
+	sysName, err := windows.UTF16PtrFromString("")
+	if err != nil {
+		return "", err
+	}
+
+	displayNameBuffer := make([]uint16, 256)
+	displayNameBufferSize := uint32(len(displayNameBuffer))
+
+	var langID uint32
+	if r1, _, err := procLookupPrivilegeDisplayName.Call(uintptr(unsafe.Pointer(sysName)), uintptr(unsafe.Pointer(namePtr)), uintptr(unsafe.Pointer(&displayNameBuffer[0])), uintptr(unsafe.Pointer(&displayNameBufferSize)), uintptr(unsafe.Pointer(&langID))); r1 == 0 {
+		return "", err
+	}
+
+	displayName := windows.UTF16ToString(displayNameBuffer)
+	privilegeDisplayNames.Store(name, displayName)
+	return displayName, nil
 }
 
 //UserDetails gets User details associated with token
 func (t *Token) UserDetails() (TokenUserDetail, error) {
+	if err := t.acquire(); err != nil {
+		return TokenUserDetail{}, err
+	}
+	defer t.release()
+
 	uSid, err := t.token.GetTokenUser()
 	if err != nil {
 		return TokenUserDetail{}, err
@@ -141,20 +345,37 @@ func (t *Token) UserDetails() (TokenUserDetail, error) {
 	if err != nil {
 		return TokenUserDetail{}, err
 	}
-	return TokenUserDetail{Username: user, Domain: domain, AccountType: typ, UserProfileDir: uProfDir, Environ: env}, nil
+	il, err := t.integrityLevel()
+	if err != nil {
+		return TokenUserDetail{}, err
+	}
+	var upn string
+	if session, err := t.logonSession(); err == nil {
+		upn = session.UPN
+	}
+	return TokenUserDetail{
+		Username:       user,
+		Domain:         domain,
+		AccountType:    typ,
+		SID:            uSid.User.Sid.String(),
+		SAMAccountName: domain + `\` + user,
+		UPN:            upn,
+		UserProfileDir: uProfDir,
+		Environ:        env,
+		IntegrityLevel: il,
+		IntegrityName:  il.String(),
+	}, nil
 }
 
 //GetPrivileges lists all Privileges from the token
-func (t *Token) GetPrivileges() ([]Privilege, error) {
-	if err := t.errIfTokenClosed(); err != nil {
+func (t *Token) GetPrivileges() ([]PrivilegeDetail, error) {
+	if err := t.acquire(); err != nil {
 		return nil, err
 	}
+	defer t.release()
 
-	n := uint32(0)
-	windows.GetTokenInformation(t.token, windows.TokenPrivileges, nil, 0, &n)
-
-	b := make([]byte, n)
-	if err := windows.GetTokenInformation(t.token, windows.TokenPrivileges, &b[0], uint32(len(b)), &n); err != nil {
+	b, err := t.info(windows.TokenPrivileges)
+	if err != nil {
 		return nil, err
 	}
 
@@ -165,14 +386,15 @@ func (t *Token) GetPrivileges() ([]Privilege, error) {
 		return nil, fmt.Errorf("cannot read number of privileges: %w", err)
 	}
 
-	privDetails := make([]Privilege, int(nPrivs))
+	privDetails := make([]PrivilegeDetail, int(nPrivs))
 
 	for i := 0; i < int(nPrivs); i++ {
 
 		var (
 			luid            uint64
 			attributes      uint32
-			currentPrivInfo Privilege
+			currentPrivInfo PrivilegeDetail
+			name            string
 			err             error
 		)
 
@@ -184,10 +406,11 @@ func (t *Token) GetPrivileges() ([]Privilege, error) {
 			return nil, fmt.Errorf("cannot read attributes from buffer: %w", err)
 		}
 
-		currentPrivInfo.Name, currentPrivInfo.Description, err = lookupPrivilegeNameByLUID(luid)
+		name, currentPrivInfo.Description, err = lookupPrivilegeNameByLUID(luid)
 		if err != nil {
 			return nil, fmt.Errorf("cannot get privilege info based on the LUID: %w", err)
 		}
+		currentPrivInfo.Name = Privilege(name)
 
 		currentPrivInfo.EnabledByDefault = (attributes & windows.SE_PRIVILEGE_ENABLED_BY_DEFAULT) > 0
 		currentPrivInfo.UsedForAccess = (attributes & windows.SE_PRIVILEGE_USED_FOR_ACCESS) > 0
@@ -202,16 +425,17 @@ func (t *Token) GetPrivileges() ([]Privilege, error) {
 
 //EnableAllPrivileges enables all privileges in the token
 func (t *Token) EnableAllPrivileges() error {
-	if err := t.errIfTokenClosed(); err != nil {
+	if err := t.acquire(); err != nil {
 		return err
 	}
+	defer t.release()
 
 	privs, err := t.GetPrivileges()
 	if err != nil {
 		return err
 	}
 
-	var toBeEnabled []string
+	var toBeEnabled []Privilege
 
 	for _, p := range privs {
 		if !p.Removed && !p.Enabled {
@@ -223,16 +447,17 @@ func (t *Token) EnableAllPrivileges() error {
 
 //DisableAllPrivileges disables all privileges in the token
 func (t *Token) DisableAllPrivileges() error {
-	if err := t.errIfTokenClosed(); err != nil {
+	if err := t.acquire(); err != nil {
 		return err
 	}
+	defer t.release()
 
 	privs, err := t.GetPrivileges()
 	if err != nil {
 		return err
 	}
 
-	var toBeDisabled []string
+	var toBeDisabled []Privilege
 
 	for _, p := range privs {
 		if !p.Removed && p.Enabled {
@@ -244,16 +469,17 @@ func (t *Token) DisableAllPrivileges() error {
 
 //RemoveAllPrivileges removes all privileges from the token
 func (t *Token) RemoveAllPrivileges() error {
-	if err := t.errIfTokenClosed(); err != nil {
+	if err := t.acquire(); err != nil {
 		return err
 	}
+	defer t.release()
 
 	privs, err := t.GetPrivileges()
 	if err != nil {
 		return err
 	}
 
-	var toBeRemoved []string
+	var toBeRemoved []Privilege
 
 	for _, p := range privs {
 		if !p.Removed {
@@ -263,82 +489,270 @@ func (t *Token) RemoveAllPrivileges() error {
 	return t.modifyTokenPrivileges(toBeRemoved, PrivRemove)
 }
 
-//EnableTokenPrivileges enables token privileges by list of privilege names
-func (t *Token) EnableTokenPrivileges(privs []string) error {
-	return t.modifyTokenPrivileges(privs, PrivEnable)
+//PrivilegeResult reports the outcome of enabling, disabling or removing each of a list of privileges
+type PrivilegeResult struct {
+	// Modified lists the privileges which were modified as requested
+	Modified []Privilege
+	// NotHeld lists the privileges which are not assigned to the token (ERROR_NOT_ALL_ASSIGNED)
+	NotHeld []Privilege
+	// Failed maps privileges which could not be modified to the error returned while modifying them
+	Failed map[Privilege]error
+
+	mode privModType
+}
+
+func (m privModType) String() string {
+	switch m {
+	case PrivDisable:
+		return "disabling"
+	case PrivEnable:
+		return "enabling"
+	case PrivRemove:
+		return "removing"
+	default:
+		return "modifying"
+	}
 }
 
-//DisableTokenPrivileges disables token privileges by list of privilege names
-func (t *Token) DisableTokenPrivileges(privs []string) error {
-	return t.modifyTokenPrivileges(privs, PrivDisable)
+//Err summarizes the privileges which were not held or failed in a single error, it is nil if all privileges were modified
+func (r *PrivilegeResult) Err() error {
+	var errMsg string
+	for _, p := range r.NotHeld {
+		if len(errMsg) != 0 {
+			errMsg += "\n"
+		}
+		errMsg += fmt.Sprintf("%s privilege for %s failed: %s", r.mode, p, ErrPrivilegeNotHeld)
+	}
+	for p, err := range r.Failed {
+		if len(errMsg) != 0 {
+			errMsg += "\n"
+		}
+		errMsg += fmt.Sprintf("%s privilege for %s failed: %s", r.mode, p, err)
+	}
+
+	if len(errMsg) != 0 {
+		return fmt.Errorf("%s", errMsg)
+	}
+	return nil
+}
+
+//EnableTokenPrivileges enables token privileges by privilege names and reports which ones were enabled, were not held or failed.
+//The returned error is non-nil unless every privilege was enabled
+func (t *Token) EnableTokenPrivileges(privs ...Privilege) (*PrivilegeResult, error) {
+	return t.modifyTokenPrivilegesResult(privs, PrivEnable)
+}
+
+//DisableTokenPrivileges disables token privileges by privilege names, see EnableTokenPrivileges
+func (t *Token) DisableTokenPrivileges(privs ...Privilege) (*PrivilegeResult, error) {
+	return t.modifyTokenPrivilegesResult(privs, PrivDisable)
 }
 
-//RemoveTokenPrivileges removes token privileges by list of privilege names
-func (t *Token) RemoveTokenPrivileges(privs []string) error {
-	return t.modifyTokenPrivileges(privs, PrivRemove)
+//RemoveTokenPrivileges removes token privileges by privilege names, see EnableTokenPrivileges
+func (t *Token) RemoveTokenPrivileges(privs ...Privilege) (*PrivilegeResult, error) {
+	return t.modifyTokenPrivilegesResult(privs, PrivRemove)
 }
 
 //EnableTokenPrivileges enables token privileges by privilege name
-func (t *Token) EnableTokenPrivilege(priv string) error {
+func (t *Token) EnableTokenPrivilege(priv Privilege) error {
 	return t.modifyTokenPrivilege(priv, PrivEnable)
 }
 
 //DisableTokenPrivilege disables token privileges by privilege name
-func (t *Token) DisableTokenPrivilege(priv string) error {
+func (t *Token) DisableTokenPrivilege(priv Privilege) error {
 	return t.modifyTokenPrivilege(priv, PrivDisable)
 }
 
 //RemoveTokenPrivilege removes token privileges by privilege name
-func (t *Token) RemoveTokenPrivilege(priv string) error {
+func (t *Token) RemoveTokenPrivilege(priv Privilege) error {
 	return t.modifyTokenPrivilege(priv, PrivRemove)
 }
 
-func (t *Token) modifyTokenPrivileges(privs []string, mode privModType) error {
-	if err := t.errIfTokenClosed(); err != nil {
-		return err
+func (t *Token) modifyTokenPrivileges(privs []Privilege, mode privModType) error {
+	_, err := t.modifyTokenPrivilegesResult(privs, mode)
+	return err
+}
+
+// modifyTokenPrivilegesResult modifies all privileges with a single AdjustTokenPrivileges call.
+// Privileges the token does not hold are left out of the call and reported as NotHeld
+func (t *Token) modifyTokenPrivilegesResult(privs []Privilege, mode privModType) (*PrivilegeResult, error) {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
+		return nil, err
 	}
+	defer t.release()
 
 	if len(privs) == 0 {
-		return ErrNoPrivilegesSpecified
+		return nil, ErrNoPrivilegesSpecified
 	}
 
-	errMsgConst := ""
+	result := &PrivilegeResult{mode: mode}
+	fail := func(p Privilege, err error) {
+		if result.Failed == nil {
+			result.Failed = make(map[Privilege]error)
+		}
+		result.Failed[p] = err
+	}
+
+	held, err := heldPrivileges(t.token)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		requested []Privilege
+		luids     []windows.LUID
+	)
+	for _, p := range privs {
+		var luid windows.LUID
+		if err := windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr(string(p)), &luid); err != nil {
+			fail(p, fmt.Errorf("LookupPrivilegeValueW failed: %w", err))
+			continue
+		}
+		if _, ok := held[luid]; !ok {
+			result.NotHeld = append(result.NotHeld, p)
+			continue
+		}
+		requested = append(requested, p)
+		luids = append(luids, luid)
+	}
+
+	if len(requested) == 0 {
+		return result, result.Err()
+	}
+
+	var attributes uint32
 	switch mode {
-	case PrivDisable:
-		errMsgConst = "disabling"
 	case PrivEnable:
-		errMsgConst = "enabling"
+		attributes = windows.SE_PRIVILEGE_ENABLED
 	case PrivRemove:
-		errMsgConst = "removing"
+		attributes = windows.SE_PRIVILEGE_REMOVED
 	}
-	var errMsg string
-	for _, p := range privs {
-		err := t.modifyTokenPrivilege(p, mode)
-		if err != nil {
-			if len(errMsg) != 0 {
-				errMsg += "\n"
+
+	if mode != PrivRemove {
+		t.recordPrivileges(luids, held)
+	}
+
+	tp := newTokenPrivileges(len(luids))
+	las := tp.AllPrivileges()
+	for i := range las {
+		las[i].Luid = luids[i]
+		las[i].Attributes = attributes
+	}
+
+	err = adjustTokenPrivileges(t.token, tp)
+	switch {
+	case err == nil:
+		result.Modified = append(result.Modified, requested...)
+	case errors.Is(err, ErrPrivilegeNotHeld):
+		// a privilege was removed between the query and the adjustment, find out which
+		held, herr := heldPrivileges(t.token)
+		for i, p := range requested {
+			if _, ok := held[luids[i]]; herr == nil && !ok && mode != PrivRemove {
+				result.NotHeld = append(result.NotHeld, p)
+			} else {
+				result.Modified = append(result.Modified, p)
 			}
-			errMsg += fmt.Sprintf("%s privilege for %s failed: %s", errMsgConst, p, err)
+		}
+	default:
+		for _, p := range requested {
+			fail(p, err)
 		}
 	}
 
-	if len(errMsg) != 0 {
-		return fmt.Errorf(errMsg)
+	t.invalidate()
+	auditRecord(AuditRecord{Operation: AuditPrivilegeChange, Privileges: result.Modified, Detail: mode.String(), Err: result.Err()}, start, t.token)
+	return result, result.Err()
+}
+
+// heldPrivileges returns the attributes of the privileges assigned to the token, enabled or not
+func heldPrivileges(t windows.Token) (map[windows.LUID]uint32, error) {
+	n := uint32(0)
+	windows.GetTokenInformation(t, windows.TokenPrivileges, nil, 0, &n)
+
+	b := make([]byte, n)
+	if err := windows.GetTokenInformation(t, windows.TokenPrivileges, &b[0], uint32(len(b)), &n); err != nil {
+		return nil, err
 	}
-	return nil
+
+	tp := (*windows.Tokenprivileges)(unsafe.Pointer(&b[0]))
+	held := make(map[windows.LUID]uint32, tp.PrivilegeCount)
+	for _, la := range tp.AllPrivileges() {
+		held[la.Luid] = la.Attributes
+	}
+	return held, nil
+}
+
+// newTokenPrivileges allocates a TOKEN_PRIVILEGES structure holding n privileges
+func newTokenPrivileges(n int) *windows.Tokenprivileges {
+	size := unsafe.Sizeof(windows.Tokenprivileges{}) + uintptr(n-1)*unsafe.Sizeof(windows.LUIDAndAttributes{})
+	b := make([]byte, size)
+	tp := (*windows.Tokenprivileges)(unsafe.Pointer(&b[0]))
+	tp.PrivilegeCount = uint32(n)
+	return tp
+}
+
+// recordPrivileges remembers the original attributes of privileges about to be modified
+// if the Token restores its privileges on Close. Privileges already recorded keep their first state
+func (t *Token) recordPrivileges(luids []windows.LUID, held map[windows.LUID]uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.restore == nil {
+		return
+	}
+	for _, luid := range luids {
+		if _, ok := t.restore[luid]; !ok {
+			t.restore[luid] = held[luid]
+		}
+	}
+}
+
+// restorePrivileges puts the recorded privileges back in their original enabled or disabled state, t.mu must be held.
+// Removed privileges cannot be restored and are never recorded
+func (t *Token) restorePrivileges() {
+	if len(t.restore) == 0 || t.token == 0 {
+		return
+	}
+
+	tp := newTokenPrivileges(len(t.restore))
+	las := tp.AllPrivileges()
+	i := 0
+	for luid, attributes := range t.restore {
+		las[i].Luid = luid
+		las[i].Attributes = attributes & windows.SE_PRIVILEGE_ENABLED
+		i++
+	}
+
+	// privileges removed since they were recorded make the call report ERROR_NOT_ALL_ASSIGNED, the others are still restored
+	adjustTokenPrivileges(t.token, tp)
+	t.restore = nil
 }
 
-func (t *Token) modifyTokenPrivilege(priv string, mode privModType) error {
-	if err := t.errIfTokenClosed(); err != nil {
+func (t *Token) modifyTokenPrivilege(priv Privilege, mode privModType) error {
+	start := time.Now()
+
+	if err := t.acquire(); err != nil {
 		return err
 	}
+	defer t.release()
 
 	var luid windows.LUID
 
-	if err := windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr(priv), &luid); err != nil {
+	if err := windows.LookupPrivilegeValue(nil, windows.StringToUTF16Ptr(string(priv)), &luid); err != nil {
 		return fmt.Errorf("LookupPrivilegeValueW failed: %w", err)
 	}
 
+	if mode != PrivRemove {
+		held, err := heldPrivileges(t.token)
+		if err != nil {
+			return err
+		}
+		if _, ok := held[luid]; ok {
+			t.recordPrivileges([]windows.LUID{luid}, held)
+		}
+	}
+
 	ap := windows.Tokenprivileges{
 		PrivilegeCount: 1,
 	}
@@ -351,40 +765,50 @@ func (t *Token) modifyTokenPrivilege(priv string, mode privModType) error {
 		ap.Privileges[0].Attributes = windows.SE_PRIVILEGE_REMOVED
 	}
 
-	if err := windows.AdjustTokenPrivileges(t.token, false, &ap, 0, nil, nil); err != nil {
+	err := adjustTokenPrivileges(t.token, &ap)
+	t.invalidate()
+	auditRecord(AuditRecord{Operation: AuditPrivilegeChange, Privileges: []Privilege{priv}, Detail: mode.String(), Err: err}, start, t.token)
+	return err
+}
+
+// adjustTokenPrivileges calls AdjustTokenPrivileges directly since the x/sys wrapper
+// drops the ERROR_NOT_ALL_ASSIGNED status reported for privileges the token does not hold
+func adjustTokenPrivileges(t windows.Token, privs *windows.Tokenprivileges) error {
+	r1, _, err := procAdjustTokenPrivileges.Call(uintptr(t), 0, uintptr(unsafe.Pointer(privs)), 0, 0, 0)
+	if r1 == 0 {
 		return fmt.Errorf("AdjustTokenPrivileges failed: %w", err)
 	}
-
+	if err == windows.ERROR_NOT_ALL_ASSIGNED {
+		return ErrPrivilegeNotHeld
+	}
 	return nil
 }
 
 // GetIntegrityLevel is used to get integrity level of the token
 func (t *Token) GetIntegrityLevel() (string, error) {
-	if err := t.errIfTokenClosed(); err != nil {
+	if err := t.acquire(); err != nil {
 		return "", err
 	}
+	defer t.release()
 
-	n := uint32(0)
-	windows.GetTokenInformation(t.token, windows.TokenIntegrityLevel, nil, 0, &n)
-
-	b := make([]byte, n)
-	if err := windows.GetTokenInformation(t.token, windows.TokenIntegrityLevel, &b[0], uint32(len(b)), &n); err != nil {
+	b, err := t.info(windows.TokenIntegrityLevel)
+	if err != nil {
 		return "", err
 	}
 
 	tml := (*windows.Tokenmandatorylabel)(unsafe.Pointer(&b[0]))
 	sid := (*windows.SID)(unsafe.Pointer(tml.Label.Sid))
 	switch sid.String() {
-	case "S-1-16-4096":
+	case SIDLowIntegrity:
 		return "Low", nil
 
-	case "S-1-16-8192":
+	case SIDMediumIntegrity:
 		return "Medium", nil
 
-	case "S-1-16-12288":
+	case SIDHighIntegrity:
 		return "High", nil
 
-	case "S-1-16-16384":
+	case SIDSystemIntegrity:
 		return "System", nil
 
 	default:
@@ -394,14 +818,15 @@ func (t *Token) GetIntegrityLevel() (string, error) {
 
 // GetLinkedToken is used to get the linked token if any
 func (t *Token) GetLinkedToken() (*Token, error) {
+	if err := t.acquire(); err != nil {
+		return nil, err
+	}
+	defer t.release()
 
 	lt, err := t.token.GetLinkedToken()
 	if err != nil {
 		return nil, err
 	}
 
-	return &Token{
-		typ:   TokenLinked,
-		token: lt,
-	}, nil
+	return newToken(lt, TokenLinked), nil
 }
@@ -0,0 +1,169 @@
+package wintoken
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	clsidWbemLocator = "{4590F811-1D3A-11D0-891F-00AA004B2E24}"
+	iidWbemLocator   = "{DC12A687-737F-11CF-884D-00AA004B2E24}"
+
+	// method indexes, after the 3 IUnknown methods
+	wbemLocatorConnectServer = 3
+	wbemServicesGetObject    = 6
+	wbemServicesExecMethod   = 24
+	wbemClassObjectGet       = 4
+	wbemClassObjectPut       = 5
+	wbemClassObjectSpawn     = 15
+	wbemClassObjectGetMethod = 19
+	rpcAuthnWinNT            = 10
+	rpcAuthnLevelCall        = 3
+	rpcImpLevelImpersonate   = 3
+	eoacDynamicCloaking      = 0x40
+	wmiProcessClass          = "Win32_Process"
+)
+
+// CreateProcessWMI impersonates the token and starts commandLine with the Win32_Process.Create WMI method, for hosts
+// where policy blocks CreateProcessAsUser and the other process creation APIs. WMI creates the process as the
+// impersonated user in a non-interactive session. currentDirectory may be empty. The process ID is returned
+func (t *Token) CreateProcessWMI(commandLine, currentDirectory string) (uint32, error) {
+	start := time.Now()
+
+	var pid uint32
+	err := t.RunAs(func() error {
+		return withCOM(func() error {
+			var err error
+			pid, err = createProcessWMI(commandLine, currentDirectory)
+			return err
+		})
+	})
+
+	if acquireErr := t.acquire(); acquireErr == nil {
+		audit(AuditLaunch, start, int(pid), 0, t.token, err)
+		t.release()
+	}
+	return pid, err
+}
+
+// setProxyBlanket makes calls on a COM proxy use the thread token, so WMI acts as the impersonated user
+func setProxyBlanket(proxy *comObject) error {
+	r1, _, _ := procCoSetProxyBlanket.Call(uintptr(unsafe.Pointer(proxy)), rpcAuthnWinNT, 0, 0, rpcAuthnLevelCall, rpcImpLevelImpersonate, 0, eoacDynamicCloaking)
+	if err := hresultError(r1); err != nil {
+		return fmt.Errorf("error while CoSetProxyBlanket: %w", err)
+	}
+	return nil
+}
+
+// wmiProperty reads the integer property name of a WMI object
+func wmiProperty(obj *comObject, name string) (uint32, error) {
+	n, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return 0, err
+	}
+	var v variant
+	if err := obj.call(wbemClassObjectGet, uintptr(unsafe.Pointer(n)), 0, uintptr(unsafe.Pointer(&v)), 0, 0); err != nil {
+		return 0, fmt.Errorf("error while IWbemClassObject::Get %s: %w", name, err)
+	}
+	defer v.clear()
+	if v.vt != vtI4 {
+		return 0, fmt.Errorf("unexpected VARIANT type %d of %s", v.vt, name)
+	}
+	return uint32(v.val[0]), nil
+}
+
+// wmiPutString sets the string property name of a WMI object
+func wmiPutString(obj *comObject, name, value string) error {
+	n, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return err
+	}
+	b, err := newBSTR(value)
+	if err != nil {
+		return err
+	}
+	defer b.free()
+
+	v := variant{vt: vtBSTR}
+	v.val[0] = uintptr(b)
+	if err := obj.call(wbemClassObjectPut, uintptr(unsafe.Pointer(n)), 0, uintptr(unsafe.Pointer(&v)), 0); err != nil {
+		return fmt.Errorf("error while IWbemClassObject::Put %s: %w", name, err)
+	}
+	return nil
+}
+
+func createProcessWMI(commandLine, currentDirectory string) (uint32, error) {
+	locator, err := coCreateInstance(clsidWbemLocator, iidWbemLocator)
+	if err != nil {
+		return 0, err
+	}
+	defer locator.Release()
+
+	namespace, err := newBSTR(`root\cimv2`)
+	if err != nil {
+		return 0, err
+	}
+	defer namespace.free()
+	var services *comObject
+	if err := locator.call(wbemLocatorConnectServer, uintptr(namespace), 0, 0, 0, 0, 0, 0, uintptr(unsafe.Pointer(&services))); err != nil {
+		return 0, fmt.Errorf("error while IWbemLocator::ConnectServer: %w", err)
+	}
+	defer services.Release()
+	if err := setProxyBlanket(services); err != nil {
+		return 0, err
+	}
+
+	class, err := newBSTR(wmiProcessClass)
+	if err != nil {
+		return 0, err
+	}
+	defer class.free()
+	var processClass *comObject
+	if err := services.call(wbemServicesGetObject, uintptr(class), 0, 0, uintptr(unsafe.Pointer(&processClass)), 0); err != nil {
+		return 0, fmt.Errorf("error while IWbemServices::GetObject %s: %w", wmiProcessClass, err)
+	}
+	defer processClass.Release()
+
+	method, err := newBSTR("Create")
+	if err != nil {
+		return 0, err
+	}
+	defer method.free()
+	var signature *comObject
+	if err := processClass.call(wbemClassObjectGetMethod, uintptr(method), 0, uintptr(unsafe.Pointer(&signature)), 0); err != nil {
+		return 0, fmt.Errorf("error while IWbemClassObject::GetMethod Create: %w", err)
+	}
+	defer signature.Release()
+	var in *comObject
+	if err := signature.call(wbemClassObjectSpawn, 0, uintptr(unsafe.Pointer(&in))); err != nil {
+		return 0, fmt.Errorf("error while IWbemClassObject::SpawnInstance: %w", err)
+	}
+	defer in.Release()
+
+	if err := wmiPutString(in, "CommandLine", commandLine); err != nil {
+		return 0, err
+	}
+	if currentDirectory != "" {
+		if err := wmiPutString(in, "CurrentDirectory", currentDirectory); err != nil {
+			return 0, err
+		}
+	}
+
+	var out *comObject
+	if err := services.call(wbemServicesExecMethod, uintptr(class), uintptr(method), 0, 0, uintptr(unsafe.Pointer(in)), uintptr(unsafe.Pointer(&out)), 0); err != nil {
+		return 0, fmt.Errorf("error while IWbemServices::ExecMethod Win32_Process.Create: %w", err)
+	}
+	defer out.Release()
+
+	rv, err := wmiProperty(out, "ReturnValue")
+	if err != nil {
+		return 0, err
+	}
+	if rv != 0 {
+		return 0, fmt.Errorf("error while Win32_Process.Create: return value %d", rv)
+	}
+	return wmiProperty(out, "ProcessId")
+}